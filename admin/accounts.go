@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+type account struct {
+	ChainID     uint64 `json:"chainId"`
+	Address     string `json:"address"`
+	Enable      bool   `json:"enable"`
+	RemainGas   string `json:"remainGas"`
+	UsedGas     string `json:"usedGas"`
+	LastRequest int64  `json:"lastRequest"`
+}
+
+// updateAccountRequest enables or disables the account and overrides its remaining quota in wei.
+type updateAccountRequest struct {
+	Enable    *bool   `json:"enable"`
+	RemainGas *string `json:"remainGas"`
+}
+
+func toAccount(rec *models.Account) *account {
+	return &account{
+		ChainID:     rec.ChainID,
+		Address:     rec.Address,
+		Enable:      rec.Enable,
+		RemainGas:   rec.RemainGas,
+		UsedGas:     rec.UsedGas,
+		LastRequest: rec.LastRequest.Unix(),
+	}
+}
+
+func (h *Handler) getAccount(c *gin.Context) {
+	address := c.Param("address")
+	if !common.IsHexAddress(address) {
+		badRequest(c, "invalid address")
+		return
+	}
+	chainID, ok := h.queryChainID(c)
+	if !ok {
+		return
+	}
+	rec, err := (&models.Account{}).FindByAddress(h.rep, chainID, strings.ToLower(address))
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if rec == nil {
+		notFound(c)
+		return
+	}
+	c.JSON(http.StatusOK, toAccount(rec))
+}
+
+func (h *Handler) updateAccount(c *gin.Context) {
+	address := c.Param("address")
+	if !common.IsHexAddress(address) {
+		badRequest(c, "invalid address")
+		return
+	}
+	chainID, ok := h.queryChainID(c)
+	if !ok {
+		return
+	}
+	var req updateAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid request")
+		return
+	}
+	if req.RemainGas != nil {
+		remain, ok := new(big.Int).SetString(*req.RemainGas, 10)
+		if !ok || remain.Sign() < 0 {
+			badRequest(c, "invalid remainGas")
+			return
+		}
+	}
+
+	var rec *models.Account
+	err := h.rep.Transaction(func(tx db.Repository) error {
+		var err error
+		rec, _, err = (&models.Account{}).FindForUpdate(tx, chainID, strings.ToLower(address), "0")
+		if err != nil {
+			return err
+		}
+		if req.Enable != nil {
+			rec.Enable = *req.Enable
+		}
+		if req.RemainGas != nil {
+			rec.RemainGas = *req.RemainGas
+			rec.LastRequest = time.Now()
+		}
+		return tx.Save(rec).Error
+	})
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, toAccount(rec))
+}
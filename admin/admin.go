@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+const keyLength = 32
+
+// Handler serves the admin REST API for users, api keys and accounts.
+type Handler struct {
+	rep db.Repository
+	// defaultChain is the chain of account requests without the chainId query param.
+	defaultChain uint64
+	// secrets encrypts the signing secrets of the api keys, nil stores them in plain.
+	secrets *models.SecretKey
+}
+
+func NewHandler(rep db.Repository, defaultChain uint64, secrets *models.SecretKey) *Handler {
+	return &Handler{rep: rep, defaultChain: defaultChain, secrets: secrets}
+}
+
+// Register adds the admin routes to group, all of them authenticated by the bearer token.
+func (h *Handler) Register(group *gin.RouterGroup, token string) {
+	group.Use(authenticate(token))
+
+	group.GET("/users", h.listUsers)
+	group.POST("/users", h.createUser)
+	group.GET("/users/:id", h.getUser)
+	group.PUT("/users/:id", h.updateUser)
+	group.DELETE("/users/:id", h.deleteUser)
+
+	group.GET("/users/:id/keys", h.listKeys)
+	group.POST("/users/:id/keys", h.createKey)
+	group.GET("/keys/:id", h.getKey)
+	group.PUT("/keys/:id", h.updateKey)
+	group.POST("/keys/:id/rotate", h.rotateKey)
+	group.DELETE("/keys/:id", h.deleteKey)
+
+	group.GET("/accounts/:address", h.getAccount)
+	group.PUT("/accounts/:address", h.updateAccount)
+}
+
+func authenticate(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth := c.GetHeader("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func paramID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		badRequest(c, "invalid id")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// queryChainID returns the chainId query param, the default chain if it is absent.
+func (h *Handler) queryChainID(c *gin.Context) (uint64, bool) {
+	value := c.Query("chainId")
+	if value == "" {
+		return h.defaultChain, true
+	}
+	chainID, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		badRequest(c, "invalid chainId")
+		return 0, false
+	}
+	return chainID, true
+}
+
+func badRequest(c *gin.Context, message string) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": message})
+}
+
+func notFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+}
+
+func internalError(c *gin.Context, err error) {
+	logger.S().Errorf("admin api error: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,269 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/quota"
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+type apiKey struct {
+	ID            uint    `json:"id"`
+	UserID        uint    `json:"userId"`
+	Key           string  `json:"key,omitempty"`
+	SigningSecret string  `json:"signingSecret,omitempty"`
+	KeyPrefix     string  `json:"keyPrefix"`
+	Enable        bool    `json:"enable"`
+	Description   string  `json:"description"`
+	Limits        *limits `json:"limits"`
+	QuotaWindow   string  `json:"quotaWindow"`
+	Scopes        string  `json:"scopes"`
+	RateLimits    *rates  `json:"rateLimits"`
+	ValidFor      uint    `json:"validFor"`
+	CreatedAt     int64   `json:"createdAt"`
+}
+
+type createKeyRequest struct {
+	Description string         `json:"description"`
+	Enable      *bool          `json:"enable"`
+	Limits      *limitsRequest `json:"limits"`
+	QuotaWindow string         `json:"quotaWindow"`
+	Scopes      string         `json:"scopes"`
+	ValidFor    uint           `json:"validFor"`
+}
+
+type updateKeyRequest struct {
+	Description *string        `json:"description"`
+	Enable      *bool          `json:"enable"`
+	Limits      *limitsRequest `json:"limits"`
+	QuotaWindow *string        `json:"quotaWindow"`
+	Scopes      *string        `json:"scopes"`
+	RateLimits  *rates         `json:"rateLimits"`
+	ValidFor    *uint          `json:"validFor"`
+}
+
+// toApiKey converts rec, key and the signing secret are only set when the key is generated
+// since only the hash of the key is stored and the secret is a credential as the key is.
+func toApiKey(rec *models.ApiKeys, key string, secret string) *apiKey {
+	return &apiKey{
+		ID:            rec.ID,
+		SigningSecret: secret,
+		UserID:        rec.UserID,
+		Key:           key,
+		KeyPrefix:     rec.KeyPrefix,
+		Enable:        rec.Enable,
+		Description:   rec.Description,
+		Limits:        toLimits(&rec.Limits),
+		QuotaWindow:   rec.QuotaWindow,
+		Scopes:        rec.Scopes,
+		RateLimits:    &rates{Sponsor: rec.RateLimits.Sponsor, Read: rec.RateLimits.Read},
+		ValidFor:      rec.ValidFor,
+		CreatedAt:     rec.CreatedAt.Unix(),
+	}
+}
+
+func (h *Handler) listKeys(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+	recs, err := (&models.ApiKeys{}).FindByUser(h.rep, user.ID)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	keys := make([]*apiKey, len(recs))
+	for i := range recs {
+		keys[i] = toApiKey(&recs[i], "", "")
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+func (h *Handler) createKey(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+	var req createKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid request")
+		return
+	}
+	if !quota.Valid(req.QuotaWindow) {
+		badRequest(c, "invalid quotaWindow")
+		return
+	}
+	if !validScopes(req.Scopes) {
+		badRequest(c, "invalid scopes")
+		return
+	}
+	key, err := utils.RandomKey(keyLength)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	rec := &models.ApiKeys{
+		UserID:      user.ID,
+		Enable:      req.Enable == nil || *req.Enable,
+		Description: req.Description,
+		QuotaWindow: req.QuotaWindow,
+		Scopes:      req.Scopes,
+		ValidFor:    req.ValidFor,
+	}
+	rec.SetKey(key)
+	secret, err := utils.RandomKey(keyLength)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if err := rec.SetSigningSecret(h.secrets, secret); err != nil {
+		internalError(c, err)
+		return
+	}
+	if req.Limits != nil {
+		if err := req.Limits.apply(&rec.Limits); err != nil {
+			badRequest(c, err.Error())
+			return
+		}
+	}
+	if err := h.rep.Create(rec).Error; err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, toApiKey(rec, key, secret))
+}
+
+func (h *Handler) getKey(c *gin.Context) {
+	rec, ok := h.findKey(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, toApiKey(rec, "", ""))
+}
+
+func (h *Handler) updateKey(c *gin.Context) {
+	rec, ok := h.findKey(c)
+	if !ok {
+		return
+	}
+	var req updateKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid request")
+		return
+	}
+	if req.Description != nil {
+		rec.Description = *req.Description
+	}
+	if req.Enable != nil {
+		rec.Enable = *req.Enable
+	}
+	if req.QuotaWindow != nil {
+		if !quota.Valid(*req.QuotaWindow) {
+			badRequest(c, "invalid quotaWindow")
+			return
+		}
+		rec.QuotaWindow = *req.QuotaWindow
+	}
+	if req.Scopes != nil {
+		if !validScopes(*req.Scopes) {
+			badRequest(c, "invalid scopes")
+			return
+		}
+		rec.Scopes = *req.Scopes
+	}
+	if req.RateLimits != nil {
+		if !req.RateLimits.valid() {
+			badRequest(c, "invalid rateLimits")
+			return
+		}
+		rec.RateLimits = models.RateLimits{Sponsor: req.RateLimits.Sponsor, Read: req.RateLimits.Read}
+	}
+	if req.ValidFor != nil {
+		rec.ValidFor = *req.ValidFor
+	}
+	if req.Limits != nil {
+		if err := req.Limits.apply(&rec.Limits); err != nil {
+			badRequest(c, err.Error())
+			return
+		}
+	}
+	if err := h.rep.Save(rec).Error; err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, toApiKey(rec, "", ""))
+}
+
+// rotateKey replaces the key and the signing secret with new random ones, the old ones stop working immediately.
+func (h *Handler) rotateKey(c *gin.Context) {
+	rec, ok := h.findKey(c)
+	if !ok {
+		return
+	}
+	key, err := utils.RandomKey(keyLength)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	rec.SetKey(key)
+	secret, err := utils.RandomKey(keyLength)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if err := rec.SetSigningSecret(h.secrets, secret); err != nil {
+		internalError(c, err)
+		return
+	}
+	if err := h.rep.Save(rec).Error; err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, toApiKey(rec, key, secret))
+}
+
+func (h *Handler) deleteKey(c *gin.Context) {
+	rec, ok := h.findKey(c)
+	if !ok {
+		return
+	}
+	if err := h.rep.Delete(rec).Error; err != nil {
+		internalError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) findKey(c *gin.Context) (*models.ApiKeys, bool) {
+	id, ok := paramID(c)
+	if !ok {
+		return nil, false
+	}
+	rec, err := (&models.ApiKeys{}).FindByID(h.rep, id)
+	if err != nil {
+		internalError(c, err)
+		return nil, false
+	}
+	if rec == nil {
+		notFound(c)
+		return nil, false
+	}
+	return rec, true
+}
+
+// validScopes reports whether scopes is empty or a comma separated list of known scopes.
+func validScopes(scopes string) bool {
+	if strings.TrimSpace(scopes) == "" {
+		return true
+	}
+	for _, scope := range strings.Split(scopes, ",") {
+		if !models.ValidScope(strings.TrimSpace(scope)) {
+			return false
+		}
+	}
+	return true
+}
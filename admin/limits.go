@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/ratelimit"
+)
+
+// limits are the quota limits of an api key or user in wei, "0" is unlimited.
+type limits struct {
+	DailyBudget     string `json:"dailyBudget"`
+	MonthlyBudget   string `json:"monthlyBudget"`
+	SenderAllowance string `json:"senderAllowance"`
+	SenderMaxOps    uint   `json:"senderMaxOps"`
+	HardCap         string `json:"hardCap"`
+}
+
+// limitsRequest updates the given limits, omitted ones are unchanged.
+type limitsRequest struct {
+	DailyBudget     *string `json:"dailyBudget"`
+	MonthlyBudget   *string `json:"monthlyBudget"`
+	SenderAllowance *string `json:"senderAllowance"`
+	SenderMaxOps    *uint   `json:"senderMaxOps"`
+	HardCap         *string `json:"hardCap"`
+}
+
+func toLimits(rec *models.Limits) *limits {
+	return &limits{
+		DailyBudget:     rec.GetDailyBudget().String(),
+		MonthlyBudget:   rec.GetMonthlyBudget().String(),
+		SenderAllowance: rec.GetSenderAllowance().String(),
+		SenderMaxOps:    rec.SenderMaxOps,
+		HardCap:         rec.GetHardCap().String(),
+	}
+}
+
+func (r *limitsRequest) apply(rec *models.Limits) error {
+	wei := []struct {
+		value *string
+		field *string
+	}{
+		{r.DailyBudget, &rec.DailyBudget},
+		{r.MonthlyBudget, &rec.MonthlyBudget},
+		{r.SenderAllowance, &rec.SenderAllowance},
+		{r.HardCap, &rec.HardCap},
+	}
+	for _, w := range wei {
+		if w.value == nil {
+			continue
+		}
+		n, ok := new(big.Int).SetString(*w.value, 10)
+		if !ok || n.Sign() < 0 {
+			return errors.New("invalid limit")
+		}
+		*w.field = n.String()
+	}
+	if r.SenderMaxOps != nil {
+		rec.SenderMaxOps = *r.SenderMaxOps
+	}
+	return nil
+}
+
+// rates are the "<rate>:<burst>" rate limits of an api key per rate class, empty for the default limits.
+type rates struct {
+	Sponsor string `json:"sponsor"`
+	Read    string `json:"read"`
+}
+
+func (r *rates) valid() bool {
+	for _, value := range []string{r.Sponsor, r.Read} {
+		if _, err := ratelimit.ParseLimit(value); err != nil {
+			return false
+		}
+	}
+	return true
+}
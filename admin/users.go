@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+type user struct {
+	ID        uint    `json:"id"`
+	Address   string  `json:"address"`
+	Limits    *limits `json:"limits"`
+	CreatedAt int64   `json:"createdAt"`
+}
+
+type userRequest struct {
+	Address string         `json:"address" binding:"required"`
+	Limits  *limitsRequest `json:"limits"`
+}
+
+func toUser(rec *models.User) *user {
+	return &user{
+		ID:        rec.ID,
+		Address:   rec.Address,
+		Limits:    toLimits(&rec.Limits),
+		CreatedAt: rec.CreatedAt.Unix(),
+	}
+}
+
+func (h *Handler) listUsers(c *gin.Context) {
+	var recs []models.User
+	if err := h.rep.Model(&models.User{}).Order("id").Find(&recs).Error; err != nil {
+		internalError(c, err)
+		return
+	}
+	users := make([]*user, len(recs))
+	for i := range recs {
+		users[i] = toUser(&recs[i])
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+func (h *Handler) createUser(c *gin.Context) {
+	var req userRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !common.IsHexAddress(req.Address) {
+		badRequest(c, "invalid address")
+		return
+	}
+	rec := &models.User{Address: strings.ToLower(req.Address)}
+	if req.Limits != nil {
+		if err := req.Limits.apply(&rec.Limits); err != nil {
+			badRequest(c, err.Error())
+			return
+		}
+	}
+	if err := h.rep.Create(rec).Error; err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, toUser(rec))
+}
+
+func (h *Handler) getUser(c *gin.Context) {
+	rec, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, toUser(rec))
+}
+
+func (h *Handler) updateUser(c *gin.Context) {
+	rec, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+	var req userRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !common.IsHexAddress(req.Address) {
+		badRequest(c, "invalid address")
+		return
+	}
+	rec.Address = strings.ToLower(req.Address)
+	if req.Limits != nil {
+		if err := req.Limits.apply(&rec.Limits); err != nil {
+			badRequest(c, err.Error())
+			return
+		}
+	}
+	if err := h.rep.Save(rec).Error; err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, toUser(rec))
+}
+
+func (h *Handler) deleteUser(c *gin.Context) {
+	rec, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+	keys, err := (&models.ApiKeys{}).FindByUser(h.rep, rec.ID)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if len(keys) > 0 {
+		badRequest(c, "user has api keys")
+		return
+	}
+	if err := h.rep.Delete(rec).Error; err != nil {
+		internalError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) findUser(c *gin.Context) (*models.User, bool) {
+	id, ok := paramID(c)
+	if !ok {
+		return nil, false
+	}
+	rec, err := (&models.User{}).FindByID(h.rep, id)
+	if err != nil {
+		internalError(c, err)
+		return nil, false
+	}
+	if rec == nil {
+		notFound(c)
+		return nil, false
+	}
+	return rec, true
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+// maxContextSize is the max size of the JSON encoded context param.
+const maxContextSize = 4096
+
+// Bounds of the requested validity: validForSeconds fits a time.Duration and validAfter the uint48
+// of the paymaster data.
+const (
+	maxContextValidFor   = math.MaxInt64 / uint64(time.Second)
+	maxContextValidAfter = 1<<48 - 1
+)
+
+var contextIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+// SponsorContext is the context param of pm_sponsorUserOperation, telling why the sponsorship is requested.
+// It is stored with the audit record and echoed in the result.
+type SponsorContext struct {
+	// PolicyID selects the policy rules of that policy in addition to the rules without policy.
+	PolicyID string `json:"policyId,omitempty"`
+	// SponsorshipType is a label of the dapp for the kind of sponsorship, e.g. onboarding.
+	SponsorshipType string `json:"sponsorshipType,omitempty"`
+	// ValidForSeconds requests how long the sponsorship is valid, clamped to the validity bounds.
+	ValidForSeconds uint64 `json:"validForSeconds,omitempty"`
+	// ValidAfter requests the unix time the sponsorship becomes valid at, for scheduled operations.
+	ValidAfter uint64 `json:"validAfter,omitempty"`
+	// Metadata is a JSON object of the dapp kept with the audit record.
+	Metadata map[string]any `json:"metadata,omitempty"`
+	// WebhookData is passed through unchanged for the webhooks of the dapp.
+	WebhookData any `json:"webhookData,omitempty"`
+}
+
+// parseContext decodes and validates the context param, which can be omitted or null.
+func parseContext(ctx any) (*SponsorContext, error) {
+	parsed := &SponsorContext{}
+	if ctx == nil {
+		return parsed, nil
+	}
+	data, err := json.Marshal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxContextSize {
+		return nil, contextError(fmt.Sprintf("context exceeds %d bytes", maxContextSize))
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(parsed); err != nil {
+		return nil, contextError(err.Error())
+	}
+	if parsed.PolicyID != "" && !contextIDPattern.MatchString(parsed.PolicyID) {
+		return nil, contextError("invalid policyId")
+	}
+	if parsed.SponsorshipType != "" && !contextIDPattern.MatchString(parsed.SponsorshipType) {
+		return nil, contextError("invalid sponsorshipType")
+	}
+	if parsed.ValidForSeconds > maxContextValidFor {
+		return nil, contextError("validForSeconds is out of range")
+	}
+	if parsed.ValidAfter > maxContextValidAfter {
+		return nil, contextError("validAfter is out of range")
+	}
+	return parsed, nil
+}
+
+// empty reports whether the caller did not pass any context.
+func (c *SponsorContext) empty() bool {
+	return c.PolicyID == "" && c.SponsorshipType == "" && c.ValidForSeconds == 0 && c.ValidAfter == 0 &&
+		c.Metadata == nil && c.WebhookData == nil
+}
+
+func contextError(reason string) error {
+	return errors.NewRPCError(errors.INVALID_FIELDS, "Invalid context", reason)
+}
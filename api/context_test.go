@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+func TestParseContextBounds(t *testing.T) {
+	for _, c := range []struct {
+		context string
+		valid   bool
+	}{
+		{`{"validForSeconds": 3600, "validAfter": 1700000000}`, true},
+		{`{"validForSeconds": 9223372036}`, true},
+		{`{"validForSeconds": 9223372037}`, false},
+		{`{"validForSeconds": 18446744073709551615}`, false},
+		{`{"validForSeconds": 18446744073709551616}`, false},
+		{`{"validForSeconds": -1}`, false},
+		{`{"validAfter": 281474976710655}`, true},
+		{`{"validAfter": 281474976710656}`, false},
+		{`{"validAfter": 9223372036854775808}`, false},
+	} {
+		var ctx any
+		if err := json.Unmarshal([]byte(c.context), &ctx); err != nil {
+			t.Fatal(err)
+		}
+		_, err := parseContext(ctx)
+		if c.valid {
+			if err != nil {
+				t.Errorf("context %s: %v", c.context, err)
+			}
+			continue
+		}
+		rpcErr, ok := err.(*errors.RPCError)
+		if !ok || rpcErr.Code() != errors.INVALID_FIELDS {
+			t.Errorf("context %s returns %v, expected invalid params", c.context, err)
+		}
+	}
+}
+
+func TestValidityWindow(t *testing.T) {
+	p := &validityPolicy{
+		validFor:      time.Hour,
+		minValidFor:   time.Minute,
+		maxValidFor:   24 * time.Hour,
+		maxValidAfter: 7 * 24 * time.Hour,
+	}
+	now := time.Unix(1700000000, 0)
+	for _, c := range []struct {
+		ctx        SponsorContext
+		validAfter int64
+		validFor   int64
+	}{
+		{SponsorContext{}, 1700000000, 3600},
+		{SponsorContext{ValidForSeconds: 1}, 1700000000, 60},
+		{SponsorContext{ValidForSeconds: maxContextValidFor}, 1700000000, 24 * 3600},
+		{SponsorContext{ValidForSeconds: math.MaxUint64}, 1700000000, 24 * 3600},
+		{SponsorContext{ValidAfter: 1700000600}, 1700000600, 3600},
+		{SponsorContext{ValidAfter: maxContextValidAfter}, 1700000000 + 7*24*3600, 3600},
+		{SponsorContext{ValidAfter: math.MaxUint64}, 1700000000 + 7*24*3600, 3600},
+	} {
+		ctx := c.ctx
+		validUntil, validAfter := p.window(&models.ApiKeys{}, &ctx, now)
+		if validAfter.Int64() != c.validAfter || validUntil.Int64()-validAfter.Int64() != c.validFor {
+			t.Errorf("context %+v: window [%s, %s], expected [%d, %d]", ctx, validAfter, validUntil, c.validAfter, c.validAfter+c.validFor)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"crypto/rand"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ququzone/verifying-paymaster-service/container"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/quota"
+)
+
+// testRepository connects to the postgres database of TEST_DATABASE_DSN and migrates it,
+// the test is skipped without it.
+func testRepository(t *testing.T) db.Repository {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	rep, err := db.Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rep.Close() })
+	err = rep.AutoMigrate(&models.User{}, &models.ApiKeys{}, &models.Account{}, &models.PolicyRule{}, &models.Reservation{}, &models.Sponsorship{}, &models.RateBucket{}, &models.SettlementCursor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rep
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// testSigner returns a signer of a chain unused by other tests, granting maxGas once to every sender.
+func testSigner(t *testing.T, rep db.Repository, maxGas int64) *Signer {
+	windows, err := quota.NewWindows(quota.Lifetime, "00:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainID := new(big.Int).SetBytes(randomBytes(t, 4))
+	return &Signer{
+		Container:  container.NewContainer(rep),
+		ChainID:    chainID.Add(chainID, big.NewInt(1000000)),
+		Contract:   common.BytesToAddress(randomBytes(t, 20)),
+		EntryPoint: common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"),
+		MaxGas:     big.NewInt(maxGas),
+		Windows:    windows,
+	}
+}
+
+func testApiKey(t *testing.T, rep db.Repository) *models.ApiKeys {
+	apiKey := &models.ApiKeys{Enable: true}
+	apiKey.SetKey(hexutil.Encode(randomBytes(t, 16)))
+	if err := rep.Create(apiKey).Error; err != nil {
+		t.Fatal(err)
+	}
+	return apiKey
+}
+
+// testSponsorship returns the record of a sponsorship of sender by apiKey costing maxCost.
+func testSponsorship(t *testing.T, s *Signer, apiKey *models.ApiKeys, sender common.Address, maxCost int64) *models.Sponsorship {
+	return &models.Sponsorship{
+		UserOpHash:                    hexutil.Encode(randomBytes(t, 32)),
+		ChainID:                       s.ChainID.Uint64(),
+		Sender:                        strings.ToLower(sender.Hex()),
+		Nonce:                         "0",
+		ApiKeyID:                      apiKey.ID,
+		EntryPoint:                    strings.ToLower(s.EntryPoint.Hex()),
+		CallGasLimit:                  "0",
+		VerificationGasLimit:          "0",
+		PreVerificationGas:            "0",
+		PaymasterVerificationGasLimit: "0",
+		PaymasterPostOpGasLimit:       "0",
+		MaxFeePerGas:                  "0",
+		MaxPriorityFeePerGas:          "0",
+		MaxCost:                       big.NewInt(maxCost).String(),
+		ValidUntil:                    time.Now().Add(time.Hour),
+		ValidAfter:                    time.Now(),
+	}
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// PaymasterStatus is the EntryPoint deposit and stake of a paymaster and the cost of its pending reservations.
+type PaymasterStatus struct {
+	Paymaster       string `json:"paymaster"`
+	EntryPoint      string `json:"entryPoint"`
+	Deposit         string `json:"deposit"`
+	Staked          bool   `json:"staked"`
+	Stake           string `json:"stake"`
+	UnstakeDelaySec uint32 `json:"unstakeDelaySec"`
+	WithdrawTime    int64  `json:"withdrawTime"`
+	Outstanding     string `json:"outstanding"`
+	// Headroom is the deposit left once the pending reservations are charged, negative when they exceed it.
+	Headroom  string `json:"headroom"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+// depositMonitor polls the EntryPoint deposit of a paymaster.
+type depositMonitor struct {
+	paymaster  common.Address
+	entryPoint common.Address
+	caller     *contracts.EntryPointCaller
+
+	mu        sync.RWMutex
+	info      *contracts.IStakeManagerDepositInfo
+	updatedAt time.Time
+
+	depositGauge     metrics.GaugeFloat64
+	stakeGauge       metrics.GaugeFloat64
+	outstandingGauge metrics.GaugeFloat64
+	headroomGauge    metrics.GaugeFloat64
+}
+
+func (s *Signer) newDepositMonitor(paymaster common.Address, entryPoint common.Address) (*depositMonitor, error) {
+	caller, err := contracts.NewEntryPointCaller(entryPoint, s.Client)
+	if err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("paymaster/%s/%s/", s.ChainID, strings.ToLower(paymaster.Hex()))
+	return &depositMonitor{
+		paymaster:        paymaster,
+		entryPoint:       entryPoint,
+		caller:           caller,
+		depositGauge:     metrics.NewRegisteredGaugeFloat64(prefix+"deposit", nil),
+		stakeGauge:       metrics.NewRegisteredGaugeFloat64(prefix+"stake", nil),
+		outstandingGauge: metrics.NewRegisteredGaugeFloat64(prefix+"outstanding", nil),
+		headroomGauge:    metrics.NewRegisteredGaugeFloat64(prefix+"headroom", nil),
+	}, nil
+}
+
+// StartDepositMonitor loads the deposits of the paymasters and polls them every interval.
+func (s *Signer) StartDepositMonitor(interval time.Duration) error {
+	s.deposits = make(map[common.Address]*depositMonitor)
+	for _, paymaster := range s.paymasters() {
+		entryPoint := s.EntryPoint
+		if paymaster == s.ContractV07 {
+			entryPoint = s.EntryPointV07
+		}
+		monitor, err := s.newDepositMonitor(paymaster, entryPoint)
+		if err != nil {
+			return err
+		}
+		if err := s.updateDeposit(monitor); err != nil {
+			return err
+		}
+		s.deposits[paymaster] = monitor
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, monitor := range s.deposits {
+				if err := s.updateDeposit(monitor); err != nil {
+					logger.S().Errorf("update deposit of paymaster %s error: %v", monitor.paymaster, err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *Signer) updateDeposit(monitor *depositMonitor) error {
+	info, err := monitor.caller.GetDepositInfo(nil, monitor.paymaster)
+	if err != nil {
+		return err
+	}
+	monitor.mu.Lock()
+	monitor.info = &info
+	monitor.updatedAt = time.Now()
+	monitor.mu.Unlock()
+
+	outstanding, err := (&models.Reservation{}).SumPending(s.Container.GetRepository(), s.ChainID.Uint64(), strings.ToLower(monitor.entryPoint.Hex()))
+	if err != nil {
+		return err
+	}
+	headroom := new(big.Int).Sub(info.Deposit, outstanding)
+	monitor.depositGauge.Update(weiFloat(info.Deposit))
+	monitor.stakeGauge.Update(weiFloat(info.Stake))
+	monitor.outstandingGauge.Update(weiFloat(outstanding))
+	monitor.headroomGauge.Update(weiFloat(headroom))
+	if headroom.Sign() <= 0 {
+		logger.S().Warnf("Chain %s paymaster %s has no deposit headroom: deposit %s, pending reservations %s",
+			s.ChainID, monitor.paymaster, info.Deposit, outstanding)
+	}
+	return nil
+}
+
+func (m *depositMonitor) deposit() (*contracts.IStakeManagerDepositInfo, time.Time) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.info, m.updatedAt
+}
+
+// checkDeposit rejects a sponsorship of cost through entryPoint when the pending reservations and cost
+// exceed the last known deposit of the paymaster, bundlers would reject the operation anyway.
+// It locks the pending reservations of the paymaster until tx ends, so concurrent sponsorships
+// can't both fit in the same headroom.
+func (s *Signer) checkDeposit(tx db.Repository, entryPoint string, cost *big.Int) error {
+	paymaster := s.Contract
+	if common.HexToAddress(entryPoint) == s.EntryPointV07 {
+		paymaster = s.ContractV07
+	}
+	monitor, ok := s.deposits[paymaster]
+	if !ok {
+		return nil
+	}
+	info, _ := monitor.deposit()
+	if info == nil {
+		return nil
+	}
+	err := (&models.Reservation{}).LockPending(tx, s.ChainID.Uint64(), entryPoint)
+	if err != nil {
+		logger.S().Errorf("Lock pending reservations error: %v", err)
+		return err
+	}
+	outstanding, err := (&models.Reservation{}).SumPending(tx, s.ChainID.Uint64(), entryPoint)
+	if err != nil {
+		return err
+	}
+	projected := new(big.Int).Add(outstanding, cost)
+	if projected.Cmp(info.Deposit) <= 0 {
+		return nil
+	}
+	logger.S().Warnf("Chain %s paymaster %s deposit %s is too low for pending reservations of %s",
+		s.ChainID, monitor.paymaster, info.Deposit, projected)
+	return errors.NewRPCError(
+		errors.REJECTED_BY_PAYMASTER,
+		"Paymaster deposit is too low",
+		map[string]string{
+			"paymaster": monitor.paymaster.Hex(),
+			"deposit":   info.Deposit.String(),
+		},
+	)
+}
+
+// Pm_paymasterStatus returns the deposit and stake of the paymasters of the chain.
+func (s *Signer) Pm_paymasterStatus() ([]*PaymasterStatus, error) {
+	statuses := make([]*PaymasterStatus, 0, len(s.deposits))
+	for _, paymaster := range s.paymasters() {
+		monitor, ok := s.deposits[paymaster]
+		if !ok {
+			continue
+		}
+		info, updatedAt := monitor.deposit()
+		outstanding, err := (&models.Reservation{}).SumPending(s.Container.GetRepository(), s.ChainID.Uint64(), strings.ToLower(monitor.entryPoint.Hex()))
+		if err != nil {
+			logger.S().Errorf("Query pending reservations error: %v", err)
+			return nil, err
+		}
+		statuses = append(statuses, &PaymasterStatus{
+			Paymaster:       paymaster.Hex(),
+			EntryPoint:      monitor.entryPoint.Hex(),
+			Deposit:         info.Deposit.String(),
+			Staked:          info.Staked,
+			Stake:           info.Stake.String(),
+			UnstakeDelaySec: info.UnstakeDelaySec,
+			WithdrawTime:    info.WithdrawTime.Int64(),
+			Outstanding:     outstanding.String(),
+			Headroom:        new(big.Int).Sub(info.Deposit, outstanding).String(),
+			UpdatedAt:       updatedAt.Unix(),
+		})
+	}
+	return statuses, nil
+}
+
+// weiFloat converts wei to a float64 gauge value, precise enough for monitoring.
+func weiFloat(wei *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(wei).Float64()
+	return f
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ququzone/verifying-paymaster-service/config"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+// supportedEntryPoints returns the entry points of the paymasters of chain, restricted to
+// chain.EntryPoints when it is set. entryPoint is the entry point of the v0.6 paymaster.
+func supportedEntryPoints(chain *config.Chain, entryPoint common.Address) ([]common.Address, error) {
+	served := []common.Address{entryPoint}
+	if chain.ContractV07 != "" {
+		served = append(served, common.HexToAddress(chain.EntryPointV07))
+	}
+	if len(chain.EntryPoints) == 0 {
+		return served, nil
+	}
+
+	var supported []common.Address
+	for _, value := range chain.EntryPoints {
+		if !common.IsHexAddress(value) {
+			return nil, fmt.Errorf("invalid entry point %s", value)
+		}
+		address := common.HexToAddress(value)
+		found := false
+		for _, s := range served {
+			found = found || s == address
+		}
+		if !found {
+			return nil, fmt.Errorf("entry point %s has no configured paymaster", value)
+		}
+		supported = append(supported, address)
+	}
+	return supported, nil
+}
+
+// entryPointOf parses the entryPoint param, rejecting entry points which are not supported.
+func (s *Signer) entryPointOf(entryPoint string) (common.Address, error) {
+	if common.IsHexAddress(entryPoint) {
+		address := common.HexToAddress(entryPoint)
+		for _, supported := range s.EntryPoints {
+			if supported == address {
+				return address, nil
+			}
+		}
+	}
+	return common.Address{}, errors.NewRPCError(
+		errors.INVALID_FIELDS,
+		fmt.Sprintf("Unsupported entry point %s", entryPoint),
+		map[string]any{"supportedEntryPoints": s.supportedEntryPoints()},
+	)
+}
+
+func (s *Signer) supportedEntryPoints() []string {
+	entryPoints := make([]string, len(s.EntryPoints))
+	for i, entryPoint := range s.EntryPoints {
+		entryPoints[i] = entryPoint.Hex()
+	}
+	return entryPoints
+}
+
+// Pm_supportedEntryPoints returns the entry points accepted by pm_sponsorUserOperation, like eth_supportedEntryPoints.
+func (s *Signer) Pm_supportedEntryPoints() ([]string, error) {
+	return s.supportedEntryPoints(), nil
+}
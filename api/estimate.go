@@ -2,12 +2,10 @@ package api
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"errors"
 	"fmt"
 	"math/big"
 	"strings"
-	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
@@ -17,8 +15,8 @@ import (
 	"github.com/ethereum/go-ethereum/rpc"
 
 	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/signer"
 	"github.com/ququzone/verifying-paymaster-service/types"
-	"github.com/ququzone/verifying-paymaster-service/utils"
 )
 
 type RPCError struct {
@@ -32,11 +30,16 @@ func NewRPCError(code int, message string, data any) error {
 	return &RPCError{code, message, data}
 }
 
-// Code returns the message field of the JSON-RPC error object.
+// Error returns the message field of the JSON-RPC error object.
 func (e *RPCError) Error() string {
 	return e.message
 }
 
+// Code returns the code field of the JSON-RPC error object.
+func (e *RPCError) Code() int {
+	return e.code
+}
+
 // Data returns the data field of the JSON-RPC error object.
 func (e *RPCError) Data() any {
 	return e.data
@@ -165,11 +168,15 @@ func NewFailedOp(err error) (*FailedOpRevert, error) {
 
 func estimate(
 	client *ethclient.Client,
-	key *ecdsa.PrivateKey,
+	key signer.Signer,
+	pvgStrategy PVGStrategy,
+	chainID *big.Int,
 	paymasterAddr common.Address,
-	paymaster *contracts.VerifyingPaymaster,
+	senderNonce *big.Int,
 	entryPoint common.Address,
 	op *types.UserOperation,
+	validUntil *big.Int,
+	validAfter *big.Int,
 ) (preVerificationGas *big.Int, verificationGas *big.Int, callGas *big.Int, err error) {
 	defaultGas := big.NewInt(1000000)
 
@@ -179,8 +186,6 @@ func estimate(
 
 	op.CallGasLimit = defaultGas
 	op.VerificationGasLimit = defaultGas
-	validAfter := new(big.Int).SetInt64(time.Now().Unix())
-	validUntil := new(big.Int).Add(validAfter, validTimeDelay)
 	timeRangeData, err := timeRangeABI.Pack(validUntil, validAfter)
 	if err != nil {
 		return nil, nil, nil, err
@@ -189,23 +194,11 @@ func estimate(
 	preSignature := op.Signature
 	op.Signature = []byte{}
 
-	hash, err := paymaster.GetHash(nil, contracts.UserOperation{
-		Sender:               op.Sender,
-		Nonce:                op.Nonce,
-		InitCode:             op.InitCode,
-		CallData:             op.CallData,
-		CallGasLimit:         op.CallGasLimit,
-		VerificationGasLimit: op.VerificationGasLimit,
-		PreVerificationGas:   op.PreVerificationGas,
-		MaxFeePerGas:         op.MaxFeePerGas,
-		MaxPriorityFeePerGas: op.MaxPriorityFeePerGas,
-		PaymasterAndData:     op.PaymasterAndData,
-		Signature:            op.Signature,
-	}, validUntil, validAfter)
+	hash, err := paymasterHashV06(op, chainID, paymasterAddr, senderNonce, validUntil, validAfter)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	signature, err := utils.SignMessage(key, hash[:])
+	signature, err := key.SignMessage(hash[:])
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -262,7 +255,7 @@ func estimate(
 		}
 	}
 
-	pvg, err := CalcPreVerificationGas(op)
+	pvg, err := CalcPreVerificationGas(pvgStrategy, op, entryPoint)
 	if err != nil {
 		return nil, nil, nil, err
 	}
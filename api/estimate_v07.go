@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/signer"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+var simulationsABI, _ = abi.JSON(strings.NewReader(contracts.EntryPointSimulationsABI))
+
+type executionResultV07 struct {
+	PreOpGas                *big.Int
+	Paid                    *big.Int
+	AccountValidationData   *big.Int
+	PaymasterValidationData *big.Int
+	TargetSuccess           bool
+	TargetResult            []byte
+}
+
+func estimateV07(
+	client *ethclient.Client,
+	rpcClient *rpc.Client,
+	key signer.Signer,
+	pvgStrategy PVGStrategy,
+	chainID *big.Int,
+	paymasterAddr common.Address,
+	paymasterVerificationGas *big.Int,
+	paymasterPostOpGas *big.Int,
+	entryPoint common.Address,
+	simulations []byte,
+	op *types.UserOperationV07,
+	validUntil *big.Int,
+	validAfter *big.Int,
+) (preVerificationGas *big.Int, verificationGas *big.Int, callGas *big.Int, err error) {
+	if len(simulations) == 0 {
+		return nil, nil, nil, errors.New("entry point v0.7 simulation is not configured")
+	}
+	defaultGas := big.NewInt(1000000)
+
+	reqCallGasLimit := op.CallGasLimit
+	reqVerificationGasLimit := op.VerificationGasLimit
+	reqPreVerificationGas := op.PreVerificationGas
+
+	op.CallGasLimit = defaultGas
+	op.VerificationGasLimit = defaultGas
+	op.Paymaster = paymasterAddr
+	op.PaymasterVerificationGasLimit = paymasterVerificationGas
+	op.PaymasterPostOpGasLimit = paymasterPostOpGas
+	timeRangeData, err := timeRangeABI.Pack(validUntil, validAfter)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	op.PaymasterData = append(timeRangeData, emptySignature...)
+
+	hash, err := paymasterHashV07(op, chainID, paymasterAddr, validUntil, validAfter)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	signature, err := key.SignMessage(hash[:])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	op.PaymasterData = append(timeRangeData, signature...)
+
+	input, err := simulationsABI.Pack("simulateHandleOp", op.Packed(), common.Address{}, []byte{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var data hexutil.Bytes
+	err = rpcClient.CallContext(
+		context.Background(),
+		&data,
+		"eth_call",
+		map[string]any{
+			"from": common.Address{},
+			"to":   entryPoint,
+			"data": hexutil.Bytes(input),
+		},
+		"latest",
+		map[common.Address]map[string]any{
+			entryPoint: {"code": hexutil.Bytes(simulations)},
+		},
+	)
+	if err != nil {
+		fo, foErr := NewFailedOp(err)
+		if foErr != nil {
+			return nil, nil, nil, err
+		}
+		return nil, nil, nil, NewRPCError(-32500, fo.Reason, fo)
+	}
+	outputs, err := simulationsABI.Unpack("simulateHandleOp", data)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("executionResult: %s", err)
+	}
+	if len(outputs) != 1 {
+		return nil, nil, nil, fmt.Errorf("executionResult: invalid outputs length: expected 1, got %d", len(outputs))
+	}
+	sim, ok := abi.ConvertType(outputs[0], new(executionResultV07)).(*executionResultV07)
+	if !ok {
+		return nil, nil, nil, errors.New("executionResult: cannot assert type: result is not of type ExecutionResult")
+	}
+
+	code, err := client.CodeAt(context.Background(), op.Sender, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var est uint64 = 100000
+	if len(code) > 0 || len(op.CallData) == 0 {
+		est, err = client.EstimateGas(context.Background(), ethereum.CallMsg{
+			From: entryPoint,
+			To:   &op.Sender,
+			Data: op.CallData,
+		})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	pvg, err := CalcPreVerificationGasV07(pvgStrategy, op, entryPoint)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if reqPreVerificationGas != nil && reqPreVerificationGas.Cmp(pvg) > 0 {
+		preVerificationGas = reqPreVerificationGas
+	} else {
+		preVerificationGas = pvg
+	}
+	if reqVerificationGasLimit != nil && reqVerificationGasLimit.Cmp(sim.PreOpGas) > 0 {
+		verificationGas = reqVerificationGasLimit
+	} else {
+		verificationGas = sim.PreOpGas
+	}
+	callGas = big.NewInt(int64(est))
+	if reqCallGasLimit != nil && reqCallGasLimit.Cmp(callGas) > 0 {
+		callGas = reqCallGasLimit
+	}
+	return
+}
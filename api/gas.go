@@ -5,12 +5,13 @@ import (
 	"math"
 	"math/big"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 
 	"github.com/ququzone/verifying-paymaster-service/types"
 )
 
-func CalcCallDataCost(op *types.UserOperation) float64 {
+func CalcCallDataCost(op types.Packer) float64 {
 	cost := float64(0)
 	for _, b := range op.Pack() {
 		if b == byte(0) {
@@ -23,14 +24,14 @@ func CalcCallDataCost(op *types.UserOperation) float64 {
 	return cost
 }
 
-func CalcPerUserOpCost(op *types.UserOperation) float64 {
+func CalcPerUserOpCost(op types.Packer) float64 {
 	opLen := math.Floor(float64(len(op.Pack())+31) / 32)
 	cost := (25 * opLen) + 22874
 
 	return cost
 }
 
-func CalcPreVerificationGas(op *types.UserOperation) (*big.Int, error) {
+func CalcPreVerificationGas(strategy PVGStrategy, op *types.UserOperation, entryPoint common.Address) (*big.Int, error) {
 	// Sanitize fields to reduce as much variability due to length and zero bytes
 	data, err := op.ToMap()
 	if err != nil {
@@ -45,14 +46,29 @@ func CalcPreVerificationGas(op *types.UserOperation) (*big.Int, error) {
 		return nil, err
 	}
 
+	return strategy.PreVerificationGas(tmp, entryPoint, op.MaxFeePerGas, op.MaxPriorityFeePerGas)
+}
+
+func CalcPreVerificationGasV07(strategy PVGStrategy, op *types.UserOperationV07, entryPoint common.Address) (*big.Int, error) {
+	// Sanitize fields to reduce as much variability due to length and zero bytes
+	tmp := *op
+	tmp.PreVerificationGas = big.NewInt(100000)
+	tmp.VerificationGasLimit = big.NewInt(1000000)
+	tmp.CallGasLimit = big.NewInt(1000000)
+	tmp.PaymasterVerificationGasLimit = big.NewInt(1000000)
+	tmp.PaymasterPostOpGasLimit = big.NewInt(1000000)
+	tmp.Signature = bytes.Repeat([]byte{1}, len(op.Signature))
+
+	return strategy.PreVerificationGas(&tmp, entryPoint, op.MaxFeePerGas, op.MaxPriorityFeePerGas)
+}
+
+func calcPreVerificationGas(op types.Packer) *big.Int {
 	// Calculate the additional gas for adding this userOp to a batch.
-	batchOv := (21000 / 1) + CalcCallDataCost(tmp)
+	batchOv := (21000 / 1) + CalcCallDataCost(op)
 
 	// The total PVG is the sum of the batch overhead and the overhead for this userOp's validation and
 	// execution.
-	pvg := batchOv + CalcPerUserOpCost(tmp)
+	pvg := batchOv + CalcPerUserOpCost(op)
 	pvg = pvg * 1.1
-	static := big.NewInt(int64(math.Round(pvg)))
-
-	return static, nil
+	return big.NewInt(int64(math.Round(pvg)))
 }
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ququzone/verifying-paymaster-service/config"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/gasprice"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+const (
+	feeCapReject = "reject"
+	feeCapClamp  = "clamp"
+)
+
+// gasPricePolicy bounds the fees of sponsored operations by the market fees of the chain, since
+// the sponsorship cost is charged at the max fee the client chooses.
+type gasPricePolicy struct {
+	maxMultiplier *big.Float
+	minMultiplier *big.Float
+	clamp         bool
+}
+
+func newGasPricePolicy(chain *config.Chain) (*gasPricePolicy, error) {
+	if chain.MaxFeeMultiplier < 1 {
+		return nil, fmt.Errorf("invalid max fee multiplier %v", chain.MaxFeeMultiplier)
+	}
+	if *chain.MinFeeMultiplier < 0 || *chain.MinFeeMultiplier > chain.MaxFeeMultiplier {
+		return nil, fmt.Errorf("invalid min fee multiplier %v", *chain.MinFeeMultiplier)
+	}
+	if chain.FeeCapMode != feeCapReject && chain.FeeCapMode != feeCapClamp {
+		return nil, fmt.Errorf("unknown fee cap mode %s", chain.FeeCapMode)
+	}
+	return &gasPricePolicy{
+		maxMultiplier: big.NewFloat(chain.MaxFeeMultiplier),
+		minMultiplier: big.NewFloat(*chain.MinFeeMultiplier),
+		clamp:         chain.FeeCapMode == feeCapClamp,
+	}, nil
+}
+
+// marketFees returns the next base fee and the standard priority fee of the gas price oracle, or the
+// base fee of the latest block and the suggested priority fee of the chain without a recent sample.
+// Without base fee the legacy gas price is the base fee.
+func (s *Signer) marketFees() (baseFee *big.Int, tip *big.Int, err error) {
+	if s.gasPrices != nil {
+		if suggestions, err := s.gasPrices.Suggestions(); err == nil {
+			if suggestions.BaseFee.Sign() == 0 {
+				return suggestions.Standard.MaxFeePerGas, new(big.Int), nil
+			}
+			return suggestions.BaseFee, suggestions.Standard.MaxPriorityFeePerGas, nil
+		}
+	}
+	header, err := s.Client.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.BaseFee == nil {
+		gasPrice, err := s.Client.SuggestGasPrice(context.Background())
+		if err != nil {
+			return nil, nil, err
+		}
+		return gasPrice, new(big.Int), nil
+	}
+	tip, err = s.Client.SuggestGasTipCap(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+	return header.BaseFee, tip, nil
+}
+
+// checkGasPrice rejects an operation whose max fee is below the min multiple of the base fee, and
+// rejects or clamps fees above the max multiple of the market fees. It reports whether the fees of
+// the operation were clamped.
+func (s *Signer) checkGasPrice(op sponsoredOp) (bool, error) {
+	maxFee, maxPriorityFee := op.maxFeePerGas(), op.maxPriorityFeePerGas()
+	if maxPriorityFee.Cmp(maxFee) > 0 {
+		return false, errors.NewRPCError(errors.INVALID_FIELDS, "maxPriorityFeePerGas is higher than maxFeePerGas", nil)
+	}
+	baseFee, tip, err := s.marketFees()
+	if err != nil {
+		return false, err
+	}
+
+	minFee := mulFloat(baseFee, s.gasPrice.minMultiplier)
+	if maxFee.Cmp(minFee) < 0 {
+		return false, errors.NewRPCError(
+			errors.INVALID_FIELDS,
+			"maxFeePerGas is too low",
+			map[string]string{
+				"maxFeePerGas": maxFee.String(),
+				"minimum":      minFee.String(),
+			},
+		)
+	}
+
+	maxFeeCap := mulFloat(new(big.Int).Add(baseFee, tip), s.gasPrice.maxMultiplier)
+	// chains suggesting no tip do not cap the priority fee below the max fee
+	maxPriorityFeeCap := maxFeeCap
+	if tip.Sign() > 0 {
+		maxPriorityFeeCap = mulFloat(tip, s.gasPrice.maxMultiplier)
+	}
+	if maxFee.Cmp(maxFeeCap) <= 0 && maxPriorityFee.Cmp(maxPriorityFeeCap) <= 0 {
+		return false, nil
+	}
+	if !s.gasPrice.clamp {
+		return false, errors.NewRPCError(
+			errors.INVALID_FIELDS,
+			"Gas fees are too high",
+			map[string]string{
+				"maxFeePerGas":            maxFee.String(),
+				"maxFeePerGasCap":         maxFeeCap.String(),
+				"maxPriorityFeePerGas":    maxPriorityFee.String(),
+				"maxPriorityFeePerGasCap": maxPriorityFeeCap.String(),
+			},
+		)
+	}
+
+	if maxFee.Cmp(maxFeeCap) > 0 {
+		maxFee = maxFeeCap
+	}
+	if maxPriorityFee.Cmp(maxPriorityFeeCap) > 0 {
+		maxPriorityFee = maxPriorityFeeCap
+	}
+	if maxPriorityFee.Cmp(maxFee) > 0 {
+		maxPriorityFee = maxFee
+	}
+	op.setFees(maxFee, maxPriorityFee)
+	return true, nil
+}
+
+func mulFloat(n *big.Int, f *big.Float) *big.Int {
+	product, _ := new(big.Float).Mul(new(big.Float).SetInt(n), f).Int(nil)
+	return product
+}
+
+// GasPriceLevel are the fees suggested for a speed of inclusion, hex encoded.
+type GasPriceLevel struct {
+	MaxFeePerGas         string `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas"`
+}
+
+// UserOperationGasPrice are the fees suggested for the slow, standard and fast inclusion of a user operation.
+type UserOperationGasPrice struct {
+	Slow     GasPriceLevel `json:"slow"`
+	Standard GasPriceLevel `json:"standard"`
+	Fast     GasPriceLevel `json:"fast"`
+}
+
+// StartGasPriceOracle samples the fee history of the last blocks every interval.
+func (s *Signer) StartGasPriceOracle(interval time.Duration, blocks uint64) error {
+	// suggestions outlive a few failed samples
+	oracle := gasprice.NewOracle(s.Client, blocks, 3*interval)
+	if err := oracle.Update(context.Background()); err != nil {
+		return err
+	}
+	s.gasPrices = oracle
+	go oracle.Run(interval)
+	return nil
+}
+
+// Pm_getUserOperationGasPrice returns the fees suggested by the fee history of the chain.
+func (s *Signer) Pm_getUserOperationGasPrice() (*UserOperationGasPrice, error) {
+	if s.gasPrices == nil {
+		return nil, fmt.Errorf("gas price oracle of chain %s is not started", s.ChainID)
+	}
+	suggestions, err := s.gasPrices.Suggestions()
+	if err != nil {
+		logger.S().Errorf("Query gas price of chain %s error: %v", s.ChainID, err)
+		return nil, err
+	}
+	return &UserOperationGasPrice{
+		Slow:     gasPriceLevel(suggestions.Slow),
+		Standard: gasPriceLevel(suggestions.Standard),
+		Fast:     gasPriceLevel(suggestions.Fast),
+	}, nil
+}
+
+func gasPriceLevel(fees gasprice.Fees) GasPriceLevel {
+	return GasPriceLevel{
+		MaxFeePerGas:         hexutil.EncodeBig(fees.MaxFeePerGas),
+		MaxPriorityFeePerGas: hexutil.EncodeBig(fees.MaxPriorityFeePerGas),
+	}
+}
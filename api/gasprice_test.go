@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+
+	"github.com/ququzone/verifying-paymaster-service/config"
+	"github.com/ququzone/verifying-paymaster-service/gasprice"
+)
+
+// fakeFeeHistory answers the oracle with history, or with gasPrice on chains without fee history.
+type fakeFeeHistory struct {
+	history  *ethereum.FeeHistory
+	gasPrice *big.Int
+}
+
+func (b *fakeFeeHistory) FeeHistory(_ context.Context, _ uint64, _ *big.Int, _ []float64) (*ethereum.FeeHistory, error) {
+	return b.history, nil
+}
+
+func (b *fakeFeeHistory) SuggestGasPrice(_ context.Context) (*big.Int, error) {
+	return b.gasPrice, nil
+}
+
+func TestMarketFeesOfOracle(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend *fakeFeeHistory
+		baseFee int64
+		tip     int64
+	}{
+		{"fee history", &fakeFeeHistory{history: &ethereum.FeeHistory{
+			Reward:       [][]*big.Int{{big.NewInt(1), big.NewInt(2), big.NewInt(3)}},
+			BaseFee:      []*big.Int{big.NewInt(90), big.NewInt(100)},
+			GasUsedRatio: []float64{0.5},
+		}}, 100, 2},
+		{"legacy", &fakeFeeHistory{history: &ethereum.FeeHistory{}, gasPrice: big.NewInt(50)}, 50, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			oracle := gasprice.NewOracle(tc.backend, 1, time.Minute)
+			if err := oracle.Update(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			// the signer has no client, the fees come from the oracle
+			s := &Signer{gasPrices: oracle}
+			baseFee, tip, err := s.marketFees()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if baseFee.Int64() != tc.baseFee || tip.Int64() != tc.tip {
+				t.Fatalf("market fees %s and %s, expected %d and %d", baseFee, tip, tc.baseFee, tc.tip)
+			}
+		})
+	}
+}
+
+func TestGasPricePolicyMinFeeMultiplier(t *testing.T) {
+	multiplier := func(v float64) *float64 { return &v }
+	for _, tc := range []struct {
+		min   *float64
+		valid bool
+	}{
+		{multiplier(0), true},
+		{multiplier(1), true},
+		{multiplier(3), true},
+		{multiplier(-1), false},
+		{multiplier(4), false},
+	} {
+		policy, err := newGasPricePolicy(&config.Chain{MaxFeeMultiplier: 3, MinFeeMultiplier: tc.min, FeeCapMode: feeCapReject})
+		if (err == nil) != tc.valid {
+			t.Errorf("min fee multiplier %v: error %v", *tc.min, err)
+			continue
+		}
+		if err == nil {
+			if min, _ := policy.minMultiplier.Float64(); min != *tc.min {
+				t.Errorf("min fee multiplier %v, expected %v", min, *tc.min)
+			}
+		}
+	}
+}
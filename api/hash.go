@@ -0,0 +1,215 @@
+package api
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+var (
+	errHashMismatch = errors.New("paymaster hash mismatch")
+
+	verifyingPaymasterV07ABI, _ = abi.JSON(strings.NewReader(contracts.VerifyingPaymasterV07ABI))
+
+	addressTy, _ = abi.NewType("address", "", nil)
+	uint256Ty, _ = abi.NewType("uint256", "", nil)
+	bytes32Ty, _ = abi.NewType("bytes32", "", nil)
+
+	userOpHashABI = abi.Arguments{
+		{Name: "opHash", Type: bytes32Ty},
+		{Name: "entryPoint", Type: addressTy},
+		{Name: "chainId", Type: uint256Ty},
+	}
+
+	packV06ABI = abi.Arguments{
+		{Name: "sender", Type: addressTy},
+		{Name: "nonce", Type: uint256Ty},
+		{Name: "initCodeHash", Type: bytes32Ty},
+		{Name: "callDataHash", Type: bytes32Ty},
+		{Name: "callGasLimit", Type: uint256Ty},
+		{Name: "verificationGasLimit", Type: uint256Ty},
+		{Name: "preVerificationGas", Type: uint256Ty},
+		{Name: "maxFeePerGas", Type: uint256Ty},
+		{Name: "maxPriorityFeePerGas", Type: uint256Ty},
+		{Name: "paymasterAndDataHash", Type: bytes32Ty},
+	}
+
+	packV07ABI = abi.Arguments{
+		{Name: "sender", Type: addressTy},
+		{Name: "nonce", Type: uint256Ty},
+		{Name: "initCodeHash", Type: bytes32Ty},
+		{Name: "callDataHash", Type: bytes32Ty},
+		{Name: "accountGasLimits", Type: bytes32Ty},
+		{Name: "preVerificationGas", Type: uint256Ty},
+		{Name: "gasFees", Type: bytes32Ty},
+		{Name: "paymasterAndDataHash", Type: bytes32Ty},
+	}
+
+	paymasterHashV06ABI = abi.Arguments{
+		{Name: "sender", Type: addressTy},
+		{Name: "nonce", Type: uint256Ty},
+		{Name: "initCodeHash", Type: bytes32Ty},
+		{Name: "callDataHash", Type: bytes32Ty},
+		{Name: "callGasLimit", Type: uint256Ty},
+		{Name: "verificationGasLimit", Type: uint256Ty},
+		{Name: "preVerificationGas", Type: uint256Ty},
+		{Name: "maxFeePerGas", Type: uint256Ty},
+		{Name: "maxPriorityFeePerGas", Type: uint256Ty},
+		{Name: "chainId", Type: uint256Ty},
+		{Name: "paymaster", Type: addressTy},
+		{Name: "senderNonce", Type: uint256Ty},
+		{Name: "validUntil", Type: uint48Ty},
+		{Name: "validAfter", Type: uint48Ty},
+	}
+
+	paymasterHashV07ABI = abi.Arguments{
+		{Name: "sender", Type: addressTy},
+		{Name: "nonce", Type: uint256Ty},
+		{Name: "initCodeHash", Type: bytes32Ty},
+		{Name: "callDataHash", Type: bytes32Ty},
+		{Name: "accountGasLimits", Type: bytes32Ty},
+		{Name: "paymasterGasLimits", Type: bytes32Ty},
+		{Name: "preVerificationGas", Type: uint256Ty},
+		{Name: "gasFees", Type: bytes32Ty},
+		{Name: "chainId", Type: uint256Ty},
+		{Name: "paymaster", Type: addressTy},
+		{Name: "validUntil", Type: uint48Ty},
+		{Name: "validAfter", Type: uint48Ty},
+	}
+)
+
+// paymasterHashV06 computes VerifyingPaymaster.getHash of EntryPoint v0.6,
+// senderNonce is VerifyingPaymaster.senderNonce of the op sender.
+func paymasterHashV06(
+	op *types.UserOperation,
+	chainID *big.Int,
+	paymaster common.Address,
+	senderNonce *big.Int,
+	validUntil *big.Int,
+	validAfter *big.Int,
+) (common.Hash, error) {
+	data, err := paymasterHashV06ABI.Pack(
+		op.Sender,
+		op.Nonce,
+		crypto.Keccak256Hash(op.InitCode),
+		crypto.Keccak256Hash(op.CallData),
+		op.CallGasLimit,
+		op.VerificationGasLimit,
+		op.PreVerificationGas,
+		op.MaxFeePerGas,
+		op.MaxPriorityFeePerGas,
+		chainID,
+		paymaster,
+		senderNonce,
+		validUntil,
+		validAfter,
+	)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(data), nil
+}
+
+// hashChecker decides when a locally computed paymaster hash is cross-checked
+// against the on-chain getHash, at most once per interval. A zero interval disables checks.
+type hashChecker struct {
+	interval time.Duration
+	mu       sync.Mutex
+	last     time.Time
+}
+
+func (c *hashChecker) due() bool {
+	if c.interval <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.last) < c.interval {
+		return false
+	}
+	c.last = time.Now()
+	return true
+}
+
+// paymasterHashV07 computes VerifyingPaymaster.getHash of EntryPoint v0.7.
+func paymasterHashV07(
+	op *types.UserOperationV07,
+	chainID *big.Int,
+	paymaster common.Address,
+	validUntil *big.Int,
+	validAfter *big.Int,
+) (common.Hash, error) {
+	data, err := paymasterHashV07ABI.Pack(
+		op.Sender,
+		op.Nonce,
+		crypto.Keccak256Hash(op.InitCode()),
+		crypto.Keccak256Hash(op.CallData),
+		op.AccountGasLimits(),
+		op.PaymasterGasLimits(),
+		op.PreVerificationGas,
+		op.GasFees(),
+		chainID,
+		paymaster,
+		validUntil,
+		validAfter,
+	)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(data), nil
+}
+
+// userOpHashV06 computes EntryPoint.getUserOpHash of EntryPoint v0.6.
+func userOpHashV06(op *types.UserOperation, entryPoint common.Address, chainID *big.Int) (common.Hash, error) {
+	packed, err := packV06ABI.Pack(
+		op.Sender,
+		op.Nonce,
+		crypto.Keccak256Hash(op.InitCode),
+		crypto.Keccak256Hash(op.CallData),
+		op.CallGasLimit,
+		op.VerificationGasLimit,
+		op.PreVerificationGas,
+		op.MaxFeePerGas,
+		op.MaxPriorityFeePerGas,
+		crypto.Keccak256Hash(op.PaymasterAndData),
+	)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return userOpHash(packed, entryPoint, chainID)
+}
+
+// userOpHashV07 computes EntryPoint.getUserOpHash of EntryPoint v0.7.
+func userOpHashV07(op *types.UserOperationV07, entryPoint common.Address, chainID *big.Int) (common.Hash, error) {
+	packed, err := packV07ABI.Pack(
+		op.Sender,
+		op.Nonce,
+		crypto.Keccak256Hash(op.InitCode()),
+		crypto.Keccak256Hash(op.CallData),
+		op.AccountGasLimits(),
+		op.PreVerificationGas,
+		op.GasFees(),
+		crypto.Keccak256Hash(op.PaymasterAndData()),
+	)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return userOpHash(packed, entryPoint, chainID)
+}
+
+func userOpHash(packed []byte, entryPoint common.Address, chainID *big.Int) (common.Hash, error) {
+	data, err := userOpHashABI.Pack(crypto.Keccak256Hash(packed), entryPoint, chainID)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(data), nil
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+// The reference contracts of contracts/testdata have the getHash of the eth-infinitism VerifyingPaymaster samples.
+const setSenderNonceABI = `[{"inputs":[{"name":"sender","type":"address"},{"name":"nonce","type":"uint256"}],"name":"setSenderNonce","outputs":[],"stateMutability":"nonpayable","type":"function"}]`
+
+func newSimulatedBackend(t *testing.T) (*backends.SimulatedBackend, *bind.TransactOpts) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance := new(big.Int).Exp(big.NewInt(10), big.NewInt(24), nil)
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{auth.From: {Balance: balance}}, 30000000)
+	t.Cleanup(func() { backend.Close() })
+	return backend, auth
+}
+
+// deployFixture deploys the contract of the bytecode file of contracts/testdata with the address constructor args.
+func deployFixture(t *testing.T, backend *backends.SimulatedBackend, auth *bind.TransactOpts, name string, args ...common.Address) common.Address {
+	code, err := os.ReadFile("../contracts/testdata/" + name + ".bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytecode := hexutil.MustDecode("0x" + strings.TrimSpace(string(code)))
+	for _, arg := range args {
+		bytecode = append(bytecode, common.LeftPadBytes(arg.Bytes(), 32)...)
+	}
+	address, _, _, err := bind.DeployContract(auth, abi.ABI{}, bytecode, backend)
+	if err != nil {
+		t.Fatalf("deploy %s: %v", name, err)
+	}
+	backend.Commit()
+	return address
+}
+
+func TestPaymasterHashV06MatchesGetHash(t *testing.T) {
+	backend, auth := newSimulatedBackend(t)
+	contract := deployFixture(t, backend, auth, "VerifyingPaymaster", common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"), auth.From)
+	paymaster, err := contracts.NewVerifyingPaymaster(contract, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := &types.UserOperation{
+		Sender:               common.HexToAddress("0x1306b01bC3e4AD202612D3843387e94737673F53"),
+		Nonce:                big.NewInt(7),
+		InitCode:             hexutil.MustDecode("0x9406cc6185a346906296840746125a0e449764545fbfb9cf000000000000000000000000"),
+		CallData:             hexutil.MustDecode("0xb61d27f6000000000000000000000000"),
+		CallGasLimit:         big.NewInt(35000),
+		VerificationGasLimit: big.NewInt(150000),
+		PreVerificationGas:   big.NewInt(48000),
+		MaxFeePerGas:         big.NewInt(2000000000),
+		MaxPriorityFeePerGas: big.NewInt(1000000000),
+		Signature:            []byte{},
+	}
+	nonces := bind.NewBoundContract(contract, mustABI(setSenderNonceABI), backend, backend, backend)
+	if _, err := nonces.Transact(auth, "setSenderNonce", op.Sender, big.NewInt(3)); err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+
+	s := &Signer{ChainID: big.NewInt(1337), Contract: contract, Paymaster: paymaster}
+	o := &sponsoredOpV06{s: s, op: op}
+	senderNonce, err := o.getSenderNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if senderNonce.Int64() != 3 {
+		t.Fatalf("sender nonce is %s, expected 3", senderNonce)
+	}
+	validUntil, validAfter := big.NewInt(time.Now().Add(time.Hour).Unix()), big.NewInt(1700000000)
+	hash, err := paymasterHashV06(op, s.ChainID, contract, senderNonce, validUntil, validAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.checkHash(hash, validUntil, validAfter); err != nil {
+		t.Fatalf("local hash differs from getHash: %v", err)
+	}
+
+	op.CallGasLimit = big.NewInt(35001)
+	if err := o.checkHash(hash, validUntil, validAfter); err == nil {
+		t.Fatal("expected hash mismatch after changing the operation")
+	}
+}
+
+func TestPaymasterHashV07MatchesGetHash(t *testing.T) {
+	backend, auth := newSimulatedBackend(t)
+	contract := deployFixture(t, backend, auth, "VerifyingPaymasterV07", common.HexToAddress("0x0000000071727De22E5E9d8BAf0edAc6f37da032"), auth.From)
+
+	s := &Signer{
+		ChainID:      big.NewInt(1337),
+		ContractV07:  contract,
+		PaymasterV07: bind.NewBoundContract(contract, verifyingPaymasterV07ABI, backend, backend, backend),
+	}
+	op := &types.UserOperationV07{
+		Sender:                        common.HexToAddress("0x1306b01bC3e4AD202612D3843387e94737673F53"),
+		Nonce:                         big.NewInt(7),
+		Factory:                       common.HexToAddress("0x9406Cc6185a346906296840746125a0E44976454"),
+		FactoryData:                   hexutil.MustDecode("0x5fbfb9cf"),
+		CallData:                      hexutil.MustDecode("0xb61d27f6000000000000000000000000"),
+		CallGasLimit:                  big.NewInt(35000),
+		VerificationGasLimit:          big.NewInt(150000),
+		PreVerificationGas:            big.NewInt(48000),
+		MaxFeePerGas:                  big.NewInt(2000000000),
+		MaxPriorityFeePerGas:          big.NewInt(1000000000),
+		Paymaster:                     contract,
+		PaymasterVerificationGasLimit: big.NewInt(100000),
+		PaymasterPostOpGasLimit:       big.NewInt(20000),
+		Signature:                     []byte{},
+	}
+	validUntil, validAfter := big.NewInt(time.Now().Add(time.Hour).Unix()), big.NewInt(1700000000)
+	timeRangeData, err := timeRangeABI.Pack(validUntil, validAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op.PaymasterData = append(timeRangeData, emptySignature...)
+
+	o := &sponsoredOpV07{s: s, op: op}
+	hash, err := paymasterHashV07(op, s.ChainID, contract, validUntil, validAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.checkHash(hash, validUntil, validAfter); err != nil {
+		t.Fatalf("local hash differs from getHash: %v", err)
+	}
+
+	op.PaymasterPostOpGasLimit = big.NewInt(20001)
+	if err := o.checkHash(hash, validUntil, validAfter); err == nil {
+		t.Fatal("expected hash mismatch after changing the paymaster gas limits")
+	}
+}
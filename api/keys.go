@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/signer"
+)
+
+// paymasters returns the paymaster contracts of the signer.
+func (s *Signer) paymasters() []common.Address {
+	paymasters := []common.Address{s.Contract}
+	if s.ContractV07 != (common.Address{}) {
+		paymasters = append(paymasters, s.ContractV07)
+	}
+	return paymasters
+}
+
+// checkVerifyingSigners loads the verifying signers of the paymasters. With strict a verifying
+// signer without key is an error, otherwise it is logged and its paymaster stops signing
+// until the key is loaded or the verifying signer is changed back.
+func (s *Signer) checkVerifyingSigners(strict bool) error {
+	for _, paymaster := range s.paymasters() {
+		caller, err := contracts.NewVerifyingPaymasterCaller(paymaster, s.Client)
+		if err != nil {
+			return err
+		}
+		verifyingSigner, err := caller.VerifyingSigner(nil)
+		if err != nil {
+			return fmt.Errorf("query verifying signer of paymaster %s: %v", paymaster, err)
+		}
+
+		s.verifyingSignersMu.Lock()
+		previous, loaded := s.verifyingSigners[paymaster]
+		s.verifyingSigners[paymaster] = verifyingSigner
+		s.verifyingSignersMu.Unlock()
+
+		if loaded && previous == verifyingSigner {
+			continue
+		}
+		if s.Keys.Get(verifyingSigner) == nil {
+			if strict {
+				return fmt.Errorf("no key of verifying signer %s of paymaster %s", verifyingSigner, paymaster)
+			}
+			logger.S().Errorf("Chain %s VerifyingPaymaster %s rotated to signer %s without key", s.ChainID, paymaster, verifyingSigner)
+			continue
+		}
+		if loaded {
+			logger.S().Infof("Chain %s VerifyingPaymaster %s rotated signer from %s to %s", s.ChainID, paymaster, previous, verifyingSigner)
+		} else {
+			logger.S().Infof("Chain %s VerifyingPaymaster %s signer: %s", s.ChainID, paymaster, verifyingSigner)
+		}
+	}
+	return nil
+}
+
+// WatchVerifyingSigners polls the verifying signers of the paymasters every interval,
+// switching to the key of a rotated verifying signer without restart.
+func (s *Signer) WatchVerifyingSigners(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.checkVerifyingSigners(false); err != nil {
+			logger.S().Errorf("check verifying signers error: %v", err)
+		}
+	}
+}
+
+// keyOf returns the key of the verifying signer of paymaster.
+func (s *Signer) keyOf(paymaster common.Address) (signer.Signer, error) {
+	s.verifyingSignersMu.RLock()
+	verifyingSigner := s.verifyingSigners[paymaster]
+	s.verifyingSignersMu.RUnlock()
+
+	key := s.Keys.Get(verifyingSigner)
+	if key == nil {
+		return nil, fmt.Errorf("no key of verifying signer %s", verifyingSigner)
+	}
+	return key, nil
+}
@@ -0,0 +1,15 @@
+package api
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.InitLogger(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+
+	"github.com/ququzone/verifying-paymaster-service/auth"
+	"github.com/ququzone/verifying-paymaster-service/jsonrpc"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+type sponsorUserOperationParams struct {
+	UserOperation map[string]any `json:"userOperation"`
+	EntryPoint    string         `json:"entryPoint"`
+	Context       any            `json:"context,omitempty"`
+}
+
+type addressParams struct {
+	Address string `json:"address"`
+}
+
+type userOpHashParams struct {
+	UserOpHash string `json:"userOpHash"`
+}
+
+type senderPageParams struct {
+	Sender string `json:"sender"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+}
+
+type pageParams struct {
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// Register adds the paymaster methods of the signer to registry.
+func (s *Signer) Register(registry *jsonrpc.Registry) {
+	sponsor := jsonrpc.MethodOptions{Scopes: []string{models.ScopeSponsor}, RateClass: jsonrpc.RateSponsor}
+	read := jsonrpc.MethodOptions{Scopes: []string{models.ScopeRead}, RateClass: jsonrpc.RateRead}
+
+	jsonrpc.Register(registry, "pm_sponsorUserOperation", sponsor, func(ctx context.Context, p *sponsorUserOperationParams) (*PaymasterResult, error) {
+		return s.Pm_sponsorUserOperation(apiKeyOf(ctx), p.UserOperation, p.EntryPoint, p.Context)
+	})
+	jsonrpc.Register(registry, "pm_requestGas", sponsor, func(ctx context.Context, p *addressParams) (bool, error) {
+		return s.Pm_requestGas(apiKeyOf(ctx), p.Address)
+	})
+	jsonrpc.Register(registry, "pm_gasRemain", read, func(ctx context.Context, p *addressParams) (*GasRemain, error) {
+		return s.Pm_gasRemain(apiKeyOf(ctx), p.Address)
+	})
+	jsonrpc.Register(registry, "pm_getSponsorship", read, func(ctx context.Context, p *userOpHashParams) (*Sponsorship, error) {
+		return s.Pm_getSponsorship(apiKeyOf(ctx), p.UserOpHash)
+	})
+	jsonrpc.Register(registry, "pm_getSponsorshipsBySender", read, func(ctx context.Context, p *senderPageParams) ([]*Sponsorship, error) {
+		return s.Pm_getSponsorshipsBySender(apiKeyOf(ctx), p.Sender, p.Offset, p.Limit)
+	})
+	jsonrpc.Register(registry, "pm_getSponsorshipsByKey", read, func(ctx context.Context, p *pageParams) ([]*Sponsorship, error) {
+		return s.Pm_getSponsorshipsByKey(apiKeyOf(ctx), p.Offset, p.Limit)
+	})
+	jsonrpc.Register(registry, "pm_supportedEntryPoints", read, func(_ context.Context, _ *struct{}) ([]string, error) {
+		return s.Pm_supportedEntryPoints()
+	})
+	jsonrpc.Register(registry, "pm_paymasterStatus", read, func(_ context.Context, _ *struct{}) ([]*PaymasterStatus, error) {
+		return s.Pm_paymasterStatus()
+	})
+	jsonrpc.Register(registry, "pm_getUserOperationGasPrice", read, func(_ context.Context, _ *struct{}) (*UserOperationGasPrice, error) {
+		return s.Pm_getUserOperationGasPrice()
+	})
+	jsonrpc.Register(registry, "pm_keyUsage", read, func(ctx context.Context, _ *struct{}) (*KeyUsage, error) {
+		return s.Pm_keyUsage(apiKeyOf(ctx))
+	})
+}
+
+// apiKeyOf returns the api key of the caller, the registry only calls methods of authenticated requests.
+func apiKeyOf(ctx context.Context) *models.ApiKeys {
+	return auth.FromContext(ctx).ApiKey
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+const (
+	PVGEvm      = "evm"
+	PVGOptimism = "optimism"
+	PVGArbitrum = "arbitrum"
+)
+
+var (
+	// gasPriceOracle is the OP stack GasPriceOracle predeploy.
+	gasPriceOracle    = common.HexToAddress("0x420000000000000000000000000000000000000F")
+	gasPriceOracleABI = mustABI(`[{"inputs":[{"internalType":"bytes","name":"_data","type":"bytes"}],"name":"getL1Fee","outputs":[{"internalType":"uint256","name":"","type":"uint256"}],"stateMutability":"view","type":"function"}]`)
+	// nodeInterface is the Arbitrum NodeInterface precompile, only available to eth_call.
+	nodeInterface    = common.HexToAddress("0x00000000000000000000000000000000000000C8")
+	nodeInterfaceABI = mustABI(`[{"inputs":[{"internalType":"address","name":"to","type":"address"},{"internalType":"bool","name":"contractCreation","type":"bool"},{"internalType":"bytes","name":"data","type":"bytes"}],"name":"gasEstimateL1Component","outputs":[{"internalType":"uint64","name":"gasEstimateForL1","type":"uint64"},{"internalType":"uint256","name":"baseFee","type":"uint256"},{"internalType":"uint256","name":"l1BaseFeeEstimate","type":"uint256"}],"stateMutability":"payable","type":"function"}]`)
+)
+
+// PVGStrategy calculates the preVerificationGas of a user operation on a chain. The operation is
+// sanitized, the fees are the ones it pays.
+type PVGStrategy interface {
+	PreVerificationGas(op types.Packer, entryPoint common.Address, maxFeePerGas *big.Int, maxPriorityFeePerGas *big.Int) (*big.Int, error)
+}
+
+// pvgBackend is the chain access of the rollup strategies, an ethclient.Client.
+type pvgBackend interface {
+	bind.ContractCaller
+	HeaderByNumber(ctx context.Context, number *big.Int) (*gethtypes.Header, error)
+}
+
+// NewPVGStrategy returns the strategy of name, evm, optimism or arbitrum.
+func NewPVGStrategy(name string, backend pvgBackend) (PVGStrategy, error) {
+	switch name {
+	case PVGEvm:
+		return &evmPVG{}, nil
+	case PVGOptimism:
+		return &optimismPVG{backend: backend}, nil
+	case PVGArbitrum:
+		return &arbitrumPVG{backend: backend}, nil
+	}
+	return nil, fmt.Errorf("unknown pvg strategy %s", name)
+}
+
+// evmPVG covers the L1 calldata cost of the operation.
+type evmPVG struct{}
+
+func (p *evmPVG) PreVerificationGas(op types.Packer, _ common.Address, _ *big.Int, _ *big.Int) (*big.Int, error) {
+	return calcPreVerificationGas(op), nil
+}
+
+// optimismPVG adds the L1 data fee of the GasPriceOracle, converted to gas at the gas price the operation pays.
+type optimismPVG struct {
+	backend pvgBackend
+}
+
+func (p *optimismPVG) PreVerificationGas(op types.Packer, _ common.Address, maxFeePerGas *big.Int, maxPriorityFeePerGas *big.Int) (*big.Int, error) {
+	pvg := calcPreVerificationGas(op)
+	input, err := gasPriceOracleABI.Pack("getL1Fee", op.Pack())
+	if err != nil {
+		return nil, err
+	}
+	out, err := p.backend.CallContract(context.Background(), ethereum.CallMsg{To: &gasPriceOracle, Data: input}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("query l1 fee: %v", err)
+	}
+	values, err := gasPriceOracleABI.Unpack("getL1Fee", out)
+	if err != nil {
+		return nil, fmt.Errorf("query l1 fee: %v", err)
+	}
+	l1Fee := values[0].(*big.Int)
+
+	header, err := p.backend.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	gasPrice := new(big.Int).Set(maxFeePerGas)
+	if header.BaseFee != nil {
+		if effective := new(big.Int).Add(header.BaseFee, maxPriorityFeePerGas); effective.Cmp(gasPrice) < 0 {
+			gasPrice = effective
+		}
+	}
+	if gasPrice.Sign() == 0 {
+		return nil, errors.NewRPCError(errors.INVALID_FIELDS, "maxFeePerGas is required to cover the l1 data fee", nil)
+	}
+	l1Gas := new(big.Int).Add(l1Fee, new(big.Int).Sub(gasPrice, common.Big1))
+	return pvg.Add(pvg, l1Gas.Div(l1Gas, gasPrice)), nil
+}
+
+// arbitrumPVG adds the L1 gas of the NodeInterface, which is already charged in L2 gas.
+type arbitrumPVG struct {
+	backend pvgBackend
+}
+
+func (p *arbitrumPVG) PreVerificationGas(op types.Packer, entryPoint common.Address, _ *big.Int, _ *big.Int) (*big.Int, error) {
+	pvg := calcPreVerificationGas(op)
+	input, err := nodeInterfaceABI.Pack("gasEstimateL1Component", entryPoint, false, op.Pack())
+	if err != nil {
+		return nil, err
+	}
+	out, err := p.backend.CallContract(context.Background(), ethereum.CallMsg{To: &nodeInterface, Data: input}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("query l1 gas: %v", err)
+	}
+	values, err := nodeInterfaceABI.Unpack("gasEstimateL1Component", out)
+	if err != nil {
+		return nil, fmt.Errorf("query l1 gas: %v", err)
+	}
+	return pvg.Add(pvg, new(big.Int).SetUint64(values[0].(uint64))), nil
+}
+
+func mustABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+// fakePVGBackend answers the eth_call of the rollup strategies with out and the latest header with header.
+type fakePVGBackend struct {
+	out       []byte
+	callErr   error
+	header    *gethtypes.Header
+	headerErr error
+
+	calls []ethereum.CallMsg
+}
+
+func (b *fakePVGBackend) CodeAt(_ context.Context, _ common.Address, _ *big.Int) ([]byte, error) {
+	return []byte{1}, nil
+}
+
+func (b *fakePVGBackend) CallContract(_ context.Context, call ethereum.CallMsg, _ *big.Int) ([]byte, error) {
+	b.calls = append(b.calls, call)
+	return b.out, b.callErr
+}
+
+func (b *fakePVGBackend) HeaderByNumber(_ context.Context, _ *big.Int) (*gethtypes.Header, error) {
+	return b.header, b.headerErr
+}
+
+var pvgEntryPoint = common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+
+func pvgUserOperation() *types.UserOperation {
+	return &types.UserOperation{
+		Sender:               common.HexToAddress("0x1306b01bC3e4AD202612D3843387e94737673F53"),
+		Nonce:                big.NewInt(7),
+		InitCode:             []byte{},
+		CallData:             hexutil.MustDecode("0xb61d27f6000000000000000000000000"),
+		CallGasLimit:         big.NewInt(35000),
+		VerificationGasLimit: big.NewInt(150000),
+		PreVerificationGas:   big.NewInt(48000),
+		MaxFeePerGas:         big.NewInt(3000000000),
+		MaxPriorityFeePerGas: big.NewInt(1000000000),
+		PaymasterAndData:     bytes.Repeat([]byte{1}, 149),
+		Signature:            bytes.Repeat([]byte{1}, 65),
+	}
+}
+
+func l1FeeOutput(t *testing.T, fee *big.Int) []byte {
+	out, err := gasPriceOracleABI.Methods["getL1Fee"].Outputs.Pack(fee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestNewPVGStrategy(t *testing.T) {
+	for _, name := range []string{PVGEvm, PVGOptimism, PVGArbitrum} {
+		if _, err := NewPVGStrategy(name, &fakePVGBackend{}); err != nil {
+			t.Errorf("strategy %s: %v", name, err)
+		}
+	}
+	if _, err := NewPVGStrategy("zksync", &fakePVGBackend{}); err == nil {
+		t.Error("unknown strategy is created")
+	}
+}
+
+func TestEvmPVG(t *testing.T) {
+	op := pvgUserOperation()
+	strategy, _ := NewPVGStrategy(PVGEvm, nil)
+	pvg, err := strategy.PreVerificationGas(op, pvgEntryPoint, op.MaxFeePerGas, op.MaxPriorityFeePerGas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pvg.Cmp(calcPreVerificationGas(op)) != 0 || pvg.Sign() <= 0 {
+		t.Fatalf("preVerificationGas %s, expected the calldata cost %s", pvg, calcPreVerificationGas(op))
+	}
+}
+
+func TestOptimismPVG(t *testing.T) {
+	op := pvgUserOperation()
+	base := calcPreVerificationGas(op)
+	for _, tc := range []struct {
+		name    string
+		l1Fee   int64
+		baseFee *big.Int
+		l1Gas   int64
+	}{
+		// the gas price is the base fee and the tip, below maxFeePerGas
+		{"base fee and tip", 1000000000000, big.NewInt(1000000000), 500},
+		{"rounded up", 1000000000001, big.NewInt(1000000000), 501},
+		// the gas price is capped at maxFeePerGas
+		{"max fee", 3000000000000, big.NewInt(5000000000), 1000},
+		{"legacy chain", 3000000000000, nil, 1000},
+		{"no l1 fee", 0, big.NewInt(1000000000), 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := &fakePVGBackend{
+				out:    l1FeeOutput(t, big.NewInt(tc.l1Fee)),
+				header: &gethtypes.Header{BaseFee: tc.baseFee},
+			}
+			strategy, _ := NewPVGStrategy(PVGOptimism, backend)
+			pvg, err := strategy.PreVerificationGas(op, pvgEntryPoint, op.MaxFeePerGas, op.MaxPriorityFeePerGas)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := new(big.Int).Add(base, big.NewInt(tc.l1Gas)); pvg.Cmp(expected) != 0 {
+				t.Fatalf("preVerificationGas %s, expected %s", pvg, expected)
+			}
+			if len(backend.calls) != 1 || *backend.calls[0].To != gasPriceOracle {
+				t.Fatalf("calls %v, expected the GasPriceOracle", backend.calls)
+			}
+			args, err := gasPriceOracleABI.Methods["getL1Fee"].Inputs.Unpack(backend.calls[0].Data[4:])
+			if err != nil || !bytes.Equal(args[0].([]byte), op.Pack()) {
+				t.Fatalf("getL1Fee of %x, expected the packed operation", backend.calls[0].Data)
+			}
+		})
+	}
+}
+
+func TestOptimismPVGErrors(t *testing.T) {
+	op := pvgUserOperation()
+	header := &gethtypes.Header{BaseFee: big.NewInt(1000000000)}
+	for _, tc := range []struct {
+		name    string
+		backend *fakePVGBackend
+		maxFee  *big.Int
+		tip     *big.Int
+		err     string
+	}{
+		{"call error", &fakePVGBackend{callErr: stderrors.New("execution reverted"), header: header},
+			op.MaxFeePerGas, op.MaxPriorityFeePerGas, "query l1 fee: execution reverted"},
+		{"not an oracle", &fakePVGBackend{out: []byte{}, header: header},
+			op.MaxFeePerGas, op.MaxPriorityFeePerGas, "query l1 fee"},
+		{"header error", &fakePVGBackend{out: l1FeeOutput(t, big.NewInt(1)), headerErr: stderrors.New("not found")},
+			op.MaxFeePerGas, op.MaxPriorityFeePerGas, "not found"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy, _ := NewPVGStrategy(PVGOptimism, tc.backend)
+			_, err := strategy.PreVerificationGas(op, pvgEntryPoint, tc.maxFee, tc.tip)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("error %v, expected %s", err, tc.err)
+			}
+		})
+	}
+
+	// a legacy chain without fees can't convert the l1 data fee to gas
+	backend := &fakePVGBackend{out: l1FeeOutput(t, big.NewInt(1)), header: &gethtypes.Header{}}
+	strategy, _ := NewPVGStrategy(PVGOptimism, backend)
+	_, err := strategy.PreVerificationGas(op, pvgEntryPoint, new(big.Int), new(big.Int))
+	if rpcErr, ok := err.(*errors.RPCError); !ok || rpcErr.Code() != errors.INVALID_FIELDS {
+		t.Fatalf("error %v of zero fees, expected invalid fields", err)
+	}
+}
+
+func TestArbitrumPVG(t *testing.T) {
+	op := pvgUserOperation()
+	out, err := nodeInterfaceABI.Methods["gasEstimateL1Component"].Outputs.Pack(uint64(1234), big.NewInt(100000000), big.NewInt(30000000000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakePVGBackend{out: out}
+	strategy, _ := NewPVGStrategy(PVGArbitrum, backend)
+	pvg, err := strategy.PreVerificationGas(op, pvgEntryPoint, op.MaxFeePerGas, op.MaxPriorityFeePerGas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := new(big.Int).Add(calcPreVerificationGas(op), big.NewInt(1234)); pvg.Cmp(expected) != 0 {
+		t.Fatalf("preVerificationGas %s, expected %s", pvg, expected)
+	}
+	if len(backend.calls) != 1 || *backend.calls[0].To != nodeInterface {
+		t.Fatalf("calls %v, expected the NodeInterface", backend.calls)
+	}
+	args, err := nodeInterfaceABI.Methods["gasEstimateL1Component"].Inputs.Unpack(backend.calls[0].Data[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0].(common.Address) != pvgEntryPoint || args[1].(bool) || !bytes.Equal(args[2].([]byte), op.Pack()) {
+		t.Fatalf("gasEstimateL1Component of %v, expected the entry point and the packed operation", args)
+	}
+}
+
+func TestArbitrumPVGErrors(t *testing.T) {
+	op := pvgUserOperation()
+	for _, tc := range []struct {
+		name    string
+		backend *fakePVGBackend
+		err     string
+	}{
+		{"call error", &fakePVGBackend{callErr: stderrors.New("execution reverted")}, "query l1 gas: execution reverted"},
+		{"not a node interface", &fakePVGBackend{out: []byte{}}, "query l1 gas"},
+		{"short output", &fakePVGBackend{out: make([]byte, 32)}, "query l1 gas"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy, _ := NewPVGStrategy(PVGArbitrum, tc.backend)
+			_, err := strategy.PreVerificationGas(op, pvgEntryPoint, op.MaxFeePerGas, op.MaxPriorityFeePerGas)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("error %v, expected %s", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestCalcPreVerificationGasStrategy(t *testing.T) {
+	op := pvgUserOperation()
+	out, err := nodeInterfaceABI.Methods["gasEstimateL1Component"].Outputs.Pack(uint64(1000), new(big.Int), new(big.Int))
+	if err != nil {
+		t.Fatal(err)
+	}
+	evm, _ := NewPVGStrategy(PVGEvm, nil)
+	arbitrum, _ := NewPVGStrategy(PVGArbitrum, &fakePVGBackend{out: out})
+	evmGas, err := CalcPreVerificationGas(evm, op, pvgEntryPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arbitrumGas, err := CalcPreVerificationGas(arbitrum, op, pvgEntryPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if new(big.Int).Sub(arbitrumGas, evmGas).Int64() != 1000 {
+		t.Fatalf("arbitrum preVerificationGas %s, expected the evm %s and the l1 gas", arbitrumGas, evmGas)
+	}
+
+	_, err = CalcPreVerificationGas(&arbitrumPVG{backend: &fakePVGBackend{callErr: stderrors.New("timeout")}}, op, pvgEntryPoint)
+	if err == nil {
+		t.Fatal("error of the strategy is dropped")
+	}
+}
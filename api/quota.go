@@ -0,0 +1,185 @@
+package api
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/quota"
+)
+
+// QuotaUsage is the spend in wei and the number of operations sponsored in a period.
+type QuotaUsage struct {
+	Cost string `json:"cost"`
+	Ops  int64  `json:"ops"`
+}
+
+// QuotaLimits are the limits of an api key or user, "0" is unlimited.
+type QuotaLimits struct {
+	DailyBudget     string `json:"dailyBudget"`
+	MonthlyBudget   string `json:"monthlyBudget"`
+	SenderAllowance string `json:"senderAllowance"`
+	SenderMaxOps    uint   `json:"senderMaxOps"`
+	HardCap         string `json:"hardCap"`
+}
+
+type KeyUsage struct {
+	Limits     *QuotaLimits `json:"limits"`
+	UserLimits *QuotaLimits `json:"userLimits"`
+	Daily      *QuotaUsage  `json:"daily"`
+	Monthly    *QuotaUsage  `json:"monthly"`
+	Total      *QuotaUsage  `json:"total"`
+}
+
+func toQuotaLimits(limits *models.Limits) *QuotaLimits {
+	return &QuotaLimits{
+		DailyBudget:     limits.GetDailyBudget().String(),
+		MonthlyBudget:   limits.GetMonthlyBudget().String(),
+		SenderAllowance: limits.GetSenderAllowance().String(),
+		SenderMaxOps:    limits.SenderMaxOps,
+		HardCap:         limits.GetHardCap().String(),
+	}
+}
+
+// checkQuota enforces the limits of the api key and of its user on chainID on a new sponsorship of sender
+// costing cost. It locks the api key and user rows, so it must run in the reservation transaction tx
+// to serialize concurrent sponsorships of the same key. The periods of the limits are the days and months of windows.
+// The limits of a static key are the ones of the keys file,
+// its id may also be the id of an unrelated api_keys row.
+func checkQuota(tx db.Repository, windows *quota.Windows, chainID uint64, key *models.ApiKeys, sender string, cost *big.Int, now time.Time) error {
+	if key.Static {
+		if err := key.LockStatic(tx, key.ID); err != nil {
+			logger.S().Errorf("Lock api key error: %v", err)
+			return err
+		}
+		return checkLimits(tx, windows, "apiKey", &key.Limits, models.UsageFilter{ChainID: chainID, ApiKeyID: key.ID}, sender, cost, now)
+	}
+
+	apiKey, err := (&models.ApiKeys{}).FindByIDForUpdate(tx, key.ID)
+	if err != nil {
+		logger.S().Errorf("Query api key error: %v", err)
+		return err
+	}
+	if apiKey == nil {
+		return nil
+	}
+	err = checkLimits(tx, windows, "apiKey", &apiKey.Limits, models.UsageFilter{ChainID: chainID, ApiKeyID: apiKey.ID}, sender, cost, now)
+	if err != nil || apiKey.UserID == 0 {
+		return err
+	}
+
+	user, err := (&models.User{}).FindByIDForUpdate(tx, apiKey.UserID)
+	if err != nil {
+		logger.S().Errorf("Query user error: %v", err)
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+	return checkLimits(tx, windows, "user", &user.Limits, models.UsageFilter{ChainID: chainID, UserID: user.ID}, sender, cost, now)
+}
+
+func checkLimits(
+	tx db.Repository,
+	windows *quota.Windows,
+	scope string,
+	limits *models.Limits,
+	filter models.UsageFilter,
+	sender string,
+	cost *big.Int,
+	now time.Time,
+) error {
+	budgets := []struct {
+		name  string
+		limit *big.Int
+		since time.Time
+	}{
+		{"dailyBudget", limits.GetDailyBudget(), windows.DayStart(now)},
+		{"monthlyBudget", limits.GetMonthlyBudget(), windows.MonthStart(now)},
+		{"hardCap", limits.GetHardCap(), time.Time{}},
+	}
+	for _, budget := range budgets {
+		if budget.limit.Sign() == 0 {
+			continue
+		}
+		filter.Since = budget.since
+		usage, err := (&models.Usage{}).Find(tx, filter)
+		if err != nil {
+			logger.S().Errorf("Query usage error: %v", err)
+			return err
+		}
+		if new(big.Int).Add(usage.GetCost(), cost).Cmp(budget.limit) > 0 {
+			return quotaError(scope, budget.name, budget.limit.String(), usage.Cost)
+		}
+	}
+
+	allowance := limits.GetSenderAllowance()
+	if allowance.Sign() == 0 && limits.SenderMaxOps == 0 {
+		return nil
+	}
+	filter.Sender = sender
+	filter.Since = windows.DayStart(now)
+	usage, err := (&models.Usage{}).Find(tx, filter)
+	if err != nil {
+		logger.S().Errorf("Query usage error: %v", err)
+		return err
+	}
+	if allowance.Sign() > 0 && new(big.Int).Add(usage.GetCost(), cost).Cmp(allowance) > 0 {
+		return quotaError(scope, "senderAllowance", allowance.String(), usage.Cost)
+	}
+	if limits.SenderMaxOps > 0 && usage.Ops >= int64(limits.SenderMaxOps) {
+		return quotaError(scope, "senderMaxOps", limits.SenderMaxOps, usage.Ops)
+	}
+	return nil
+}
+
+func quotaError(scope string, limit string, value any, used any) error {
+	return errors.NewRPCError(
+		errors.REJECTED_BY_PAYMASTER,
+		"quota exceeded: "+scope+" "+limit,
+		map[string]any{
+			"scope": scope,
+			"limit": limit,
+			"value": value,
+			"used":  used,
+		},
+	)
+}
+
+// Pm_keyUsage returns the limits of the caller's api key and its spend on the chain in the current periods.
+func (s *Signer) Pm_keyUsage(apiKey *models.ApiKeys) (*KeyUsage, error) {
+	rep := s.Container.GetRepository()
+	now := time.Now()
+	result := &KeyUsage{Limits: toQuotaLimits(&apiKey.Limits)}
+	if apiKey.UserID != 0 {
+		user, err := (&models.User{}).FindByID(rep, apiKey.UserID)
+		if err != nil {
+			logger.S().Errorf("Query user error: %v", err)
+			return nil, err
+		}
+		if user != nil {
+			result.UserLimits = toQuotaLimits(&user.Limits)
+		}
+	}
+
+	periods := []struct {
+		usage **QuotaUsage
+		since time.Time
+	}{
+		{&result.Daily, s.Windows.DayStart(now)},
+		{&result.Monthly, s.Windows.MonthStart(now)},
+		{&result.Total, time.Time{}},
+	}
+	for _, period := range periods {
+		usage, err := (&models.Usage{}).Find(rep, models.UsageFilter{ChainID: s.ChainID.Uint64(), ApiKeyID: apiKey.ID, Since: period.since})
+		if err != nil {
+			logger.S().Errorf("Query usage error: %v", err)
+			return nil, err
+		}
+		*period.usage = &QuotaUsage{Cost: usage.GetCost().String(), Ops: usage.Ops}
+	}
+	return result, nil
+}
@@ -0,0 +1,420 @@
+package api
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/event"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+const (
+	// releaseDelay is how long a reservation is kept after validUntil, waiting for a late UserOperationEvent.
+	releaseDelay = 5 * time.Minute
+	// settlementBlockRange is the number of blocks whose UserOperationEvents are queried at once.
+	settlementBlockRange = 2000
+)
+
+// reserveGas checks the quota of the api key and the paymaster deposit, holds the max cost of the sponsorship from the sender
+// account and records the sponsorship, refilling the account as the quota window of the api key allows.
+// The account row is locked so concurrent sponsorships can not overspend it. An operation requested or signed again
+// for the same api key is not charged twice, the sponsorship still valid for it is returned instead. block is the
+// chain head the operation is signed at, its UserOperationEvent is searched from there.
+func (s *Signer) reserveGas(apiKey *models.ApiKeys, record *models.Sponsorship, block uint64) (*models.Sponsorship, error) {
+	amount, _ := new(big.Int).SetString(record.MaxCost, 10)
+	window, err := s.Windows.Get(apiKey.QuotaWindow)
+	if err != nil {
+		return nil, err
+	}
+	var existing *models.Sponsorship
+	err = s.Container.GetRepository().Transaction(func(tx db.Repository) error {
+		err := checkQuota(tx, s.Windows, record.ChainID, apiKey, record.Sender, amount, time.Now())
+		if err != nil {
+			return err
+		}
+		err = s.checkDeposit(tx, record.EntryPoint, amount)
+		if err != nil {
+			return err
+		}
+		account, _, err := (&models.Account{}).FindForUpdate(tx, record.ChainID, record.Sender, s.MaxGas.String())
+		if nil != err {
+			logger.S().Errorf("Query account error: %v", err)
+			return err
+		}
+		if !account.Enable {
+			return errors.NewRPCError(
+				errors.REJECTED_BY_PAYMASTER,
+				"Account is disabled",
+				map[string]string{"sender": record.Sender},
+			)
+		}
+		// the userOpHash and the request hash commit to the sender, so the account lock serializes sponsorships
+		// of the same operation
+		if record.RequestHash != "" {
+			existing, err = (&models.Sponsorship{}).FindValidByRequestHash(tx, record.ChainID, record.ApiKeyID, record.RequestHash, time.Now())
+			if nil != err {
+				logger.S().Errorf("Query sponsorship error: %v", err)
+				return err
+			}
+			if existing != nil {
+				return nil
+			}
+		}
+		existing, err = (&models.Sponsorship{}).FindByUserOpHash(tx, record.UserOpHash)
+		if nil != err {
+			logger.S().Errorf("Query sponsorship error: %v", err)
+			return err
+		}
+		if existing != nil {
+			if existing.ChainID == record.ChainID && existing.ApiKeyID == record.ApiKeyID {
+				return nil
+			}
+			existing = nil
+			return errors.NewRPCError(
+				errors.REJECTED_BY_PAYMASTER,
+				"User operation is already sponsored",
+				map[string]string{"userOpHash": record.UserOpHash},
+			)
+		}
+		refilled, err := window.Refill(tx, account, s.MaxGas, time.Now())
+		if nil != err {
+			logger.S().Errorf("Refill account error: %v", err)
+			return err
+		}
+		if refilled {
+			err = tx.Save(account).Error
+			if nil != err {
+				logger.S().Errorf("save account error: %v", err)
+				return err
+			}
+		}
+		ok, err := account.Reserve(tx, amount)
+		if nil != err {
+			logger.S().Errorf("save account error: %v", err)
+			return err
+		}
+		if !ok {
+			return errors.NewRPCError(errors.REJECTED_BY_PAYMASTER, "insufficient gas", nil)
+		}
+		err = tx.Create(&models.Reservation{
+			UserOpHash:    record.UserOpHash,
+			AccountID:     account.ID,
+			Amount:        amount.String(),
+			ActualGasCost: "0",
+			Status:        models.ReservationPending,
+			ValidUntil:    record.ValidUntil,
+			Block:         block,
+		}).Error
+		if nil != err {
+			logger.S().Errorf("save reservation error: %v", err)
+			return err
+		}
+		err = tx.Create(record).Error
+		if nil != err {
+			logger.S().Errorf("save sponsorship error: %v", err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// closeReservation settles the pending reservation of userOpHash with actualGasCost,
+// or releases it when actualGasCost is nil. The unused part of the reservation goes back to
+// the account unless the account was refilled since the reservation was made.
+func (s *Signer) closeReservation(userOpHash common.Hash, actualGasCost *big.Int) error {
+	return s.Container.GetRepository().Transaction(func(tx db.Repository) error {
+		reservation, err := (&models.Reservation{}).FindPendingForUpdate(tx, userOpHash.Hex())
+		if err != nil || reservation == nil {
+			return err
+		}
+		account, err := (&models.Account{}).FindByIDForUpdate(tx, reservation.AccountID)
+		if err != nil {
+			return err
+		}
+
+		actual := new(big.Int)
+		reservation.Status = models.ReservationReleased
+		if actualGasCost != nil {
+			actual = actualGasCost
+			reservation.Status = models.ReservationSettled
+		}
+		reservation.ActualGasCost = actual.String()
+		if account != nil {
+			refund := new(big.Int).Sub(reservation.GetAmount(), actual)
+			if refund.Sign() < 0 || reservation.CreatedAt.Before(account.LastRequest) {
+				refund = new(big.Int)
+			}
+			if err := account.Settle(tx, refund, actual); err != nil {
+				return err
+			}
+		}
+		return tx.Save(reservation).Error
+	})
+}
+
+// reopenReservation makes the settled reservation of userOpHash pending again when its inclusion is
+// reorged out of the chain, taking back the settlement from the account.
+func (s *Signer) reopenReservation(userOpHash common.Hash) error {
+	return s.Container.GetRepository().Transaction(func(tx db.Repository) error {
+		reservation, err := (&models.Reservation{}).FindSettledForUpdate(tx, userOpHash.Hex())
+		if err != nil || reservation == nil {
+			return err
+		}
+		account, err := (&models.Account{}).FindByIDForUpdate(tx, reservation.AccountID)
+		if err != nil {
+			return err
+		}
+
+		actual, ok := new(big.Int).SetString(reservation.ActualGasCost, 10)
+		if !ok {
+			actual = new(big.Int)
+		}
+		if account != nil {
+			refund := new(big.Int).Sub(reservation.GetAmount(), actual)
+			if refund.Sign() < 0 || reservation.CreatedAt.Before(account.LastRequest) {
+				refund = new(big.Int)
+			}
+			if err := account.Settle(tx, refund.Neg(refund), actual.Neg(actual)); err != nil {
+				return err
+			}
+		}
+		reservation.Status = models.ReservationPending
+		reservation.ActualGasCost = "0"
+		return tx.Save(reservation).Error
+	})
+}
+
+// StartSettlement settles reservations from the UserOperationEvents of the paymasters
+// and releases the reservations which expired without inclusion.
+func (s *Signer) StartSettlement(interval time.Duration) error {
+	if err := s.watchUserOperationEvents(s.EntryPoint, s.Contract, interval); err != nil {
+		return err
+	}
+	if s.ContractV07 != (common.Address{}) {
+		if err := s.watchUserOperationEvents(s.EntryPointV07, s.ContractV07, interval); err != nil {
+			return err
+		}
+	}
+	go s.releaseExpiredReservations(interval)
+	return nil
+}
+
+func (s *Signer) watchUserOperationEvents(entryPoint common.Address, paymaster common.Address, interval time.Duration) error {
+	filterer, err := contracts.NewEntryPointFilterer(entryPoint, s.Client)
+	if err != nil {
+		return err
+	}
+	if s.eventFilterers == nil {
+		s.eventFilterers = make(map[common.Address]*contracts.EntryPointFilterer)
+	}
+	s.eventFilterers[paymaster] = filterer
+	paymasters := []common.Address{paymaster}
+
+	sink := make(chan *contracts.EntryPointUserOperationEvent)
+	sub, err := filterer.WatchUserOperationEvent(&bind.WatchOpts{}, sink, nil, nil, paymasters)
+	if err != nil {
+		logger.S().Warnf("subscribe UserOperationEvent error, fallback to polling: %v", err)
+		go s.pollUserOperationEvents(filterer, paymaster, interval)
+		return nil
+	}
+	sub.Unsubscribe()
+
+	logger.S().Infof("Watching UserOperationEvent of paymaster %s on entry point %s", paymaster, entryPoint)
+	sub = event.Resubscribe(interval, func(ctx context.Context) (event.Subscription, error) {
+		return filterer.WatchUserOperationEvent(&bind.WatchOpts{Context: ctx}, sink, nil, nil, paymasters)
+	})
+	go func() {
+		defer sub.Unsubscribe()
+		for ev := range sink {
+			s.settleEvent(ev)
+			err := (&models.SettlementCursor{ChainID: s.ChainID.Uint64(), Paymaster: strings.ToLower(paymaster.Hex()), Block: ev.Raw.BlockNumber}).
+				Advance(s.Container.GetRepository())
+			if err != nil {
+				logger.S().Errorf("save settlement cursor of paymaster %s error: %v", paymaster, err)
+			}
+		}
+	}()
+	// the events emitted while the service was down are settled once the subscription is running
+	go func() {
+		latest, err := s.Client.BlockNumber(context.Background())
+		if err != nil {
+			logger.S().Errorf("query block number error: %v", err)
+			return
+		}
+		from, err := s.settlementStart(paymaster, latest)
+		if err != nil {
+			logger.S().Errorf("query settlement cursor of paymaster %s error: %v", paymaster, err)
+			return
+		}
+		if err := s.settleBlocks(filterer, paymaster, from, latest); err != nil {
+			logger.S().Errorf("settle UserOperationEvent of paymaster %s error: %v", paymaster, err)
+		}
+	}()
+	return nil
+}
+
+func (s *Signer) pollUserOperationEvents(filterer *contracts.EntryPointFilterer, paymaster common.Address, interval time.Duration) {
+	var from uint64
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		latest, err := s.Client.BlockNumber(context.Background())
+		if err != nil {
+			logger.S().Errorf("query block number error: %v", err)
+			continue
+		}
+		if from == 0 {
+			from, err = s.settlementStart(paymaster, latest)
+			if err != nil {
+				logger.S().Errorf("query settlement cursor of paymaster %s error: %v", paymaster, err)
+				continue
+			}
+		}
+		if latest < from {
+			continue
+		}
+		if err := s.settleBlocks(filterer, paymaster, from, latest); err != nil {
+			logger.S().Errorf("settle UserOperationEvent of paymaster %s error: %v", paymaster, err)
+			continue
+		}
+		from = latest + 1
+	}
+}
+
+// settlementStart returns the block after the settlement cursor of paymaster, latest for a new paymaster.
+func (s *Signer) settlementStart(paymaster common.Address, latest uint64) (uint64, error) {
+	cursor, err := (&models.SettlementCursor{}).Find(s.Container.GetRepository(), s.ChainID.Uint64(), strings.ToLower(paymaster.Hex()))
+	if err != nil {
+		return 0, err
+	}
+	if cursor == nil {
+		return latest, nil
+	}
+	return cursor.Block + 1, nil
+}
+
+// settleBlocks settles the UserOperationEvents of paymaster in the blocks from to to, advancing the settlement
+// cursor after every range of settlementBlockRange blocks, the log range most nodes accept.
+func (s *Signer) settleBlocks(filterer *contracts.EntryPointFilterer, paymaster common.Address, from uint64, to uint64) error {
+	for start := from; start <= to; start += settlementBlockRange {
+		end := start + settlementBlockRange - 1
+		if end > to {
+			end = to
+		}
+		it, err := filterer.FilterUserOperationEvent(&bind.FilterOpts{Start: start, End: &end}, nil, nil, []common.Address{paymaster})
+		if err != nil {
+			return err
+		}
+		for it.Next() {
+			s.settleEvent(it.Event)
+		}
+		err = it.Error()
+		it.Close()
+		if err != nil {
+			return err
+		}
+		err = (&models.SettlementCursor{ChainID: s.ChainID.Uint64(), Paymaster: strings.ToLower(paymaster.Hex()), Block: end}).
+			Advance(s.Container.GetRepository())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Signer) settleEvent(ev *contracts.EntryPointUserOperationEvent) {
+	// a removed event is an inclusion reorged out of the chain, the operation may not be included again
+	if ev.Raw.Removed {
+		if err := s.reopenReservation(ev.UserOpHash); err != nil {
+			logger.S().Errorf("reopen reservation %s error: %v", common.Hash(ev.UserOpHash), err)
+		}
+		return
+	}
+	err := s.closeReservation(ev.UserOpHash, ev.ActualGasCost)
+	if err != nil {
+		logger.S().Errorf("settle reservation %s error: %v", common.Hash(ev.UserOpHash), err)
+	}
+}
+
+// findUserOperationEvent returns the UserOperationEvent of userOpHash on the watched entry points in the blocks
+// from to latest, nil if the operation was not included. The blocks are queried in ranges of settlementBlockRange.
+func (s *Signer) findUserOperationEvent(userOpHash common.Hash, from uint64, latest uint64) (*contracts.EntryPointUserOperationEvent, error) {
+	for paymaster, filterer := range s.eventFilterers {
+		for start := from; start <= latest; start += settlementBlockRange {
+			end := start + settlementBlockRange - 1
+			if end > latest {
+				end = latest
+			}
+			it, err := filterer.FilterUserOperationEvent(&bind.FilterOpts{Start: start, End: &end}, [][32]byte{userOpHash}, nil, []common.Address{paymaster})
+			if err != nil {
+				return nil, err
+			}
+			found := it.Next()
+			ev := it.Event
+			err = it.Error()
+			it.Close()
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				return ev, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// releaseExpiredReservations releases the reservations which expired without inclusion. A reservation whose
+// event was missed, e.g. while the subscription was reconnecting, is settled instead.
+func (s *Signer) releaseExpiredReservations(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reservations, err := (&models.Reservation{}).FindExpired(s.Container.GetRepository(), time.Now().Add(-releaseDelay))
+		if err != nil {
+			logger.S().Errorf("query expired reservations error: %v", err)
+			continue
+		}
+		if len(reservations) == 0 {
+			continue
+		}
+		latest, err := s.Client.BlockNumber(context.Background())
+		if err != nil {
+			logger.S().Errorf("query block number error: %v", err)
+			continue
+		}
+		for _, reservation := range reservations {
+			userOpHash := common.HexToHash(reservation.UserOpHash)
+			from := reservation.Block
+			// reservations made before their block was recorded are searched in the last range of blocks
+			if from == 0 && latest >= settlementBlockRange {
+				from = latest - settlementBlockRange + 1
+			}
+			ev, err := s.findUserOperationEvent(userOpHash, from, latest)
+			if err != nil {
+				logger.S().Errorf("query UserOperationEvent of reservation %s error: %v", reservation.UserOpHash, err)
+				continue
+			}
+			if ev != nil {
+				s.settleEvent(ev)
+				continue
+			}
+			err = s.closeReservation(userOpHash, nil)
+			if err != nil {
+				logger.S().Errorf("release reservation %s error: %v", reservation.UserOpHash, err)
+			}
+		}
+	}
+}
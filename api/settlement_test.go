@@ -0,0 +1,336 @@
+package api
+
+import (
+	"context"
+	stderrors "errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+func TestReserveGasConcurrentSponsorships(t *testing.T) {
+	rep := testRepository(t)
+	s := testSigner(t, rep, 1000)
+	apiKey := testApiKey(t, rep)
+	sender := common.BytesToAddress(randomBytes(t, 20))
+
+	const calls = 20
+	records := make([]*models.Sponsorship, calls)
+	for i := range records {
+		records[i] = testSponsorship(t, s, apiKey, sender, 100)
+	}
+	errs := make([]error, calls)
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = s.reserveGas(apiKey, records[i], 0)
+		}(i)
+	}
+	wg.Wait()
+
+	reserved := 0
+	for _, err := range errs {
+		if err == nil {
+			reserved++
+		} else if !strings.Contains(err.Error(), "insufficient gas") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if reserved != 10 {
+		t.Fatalf("%d sponsorships reserved, expected 10", reserved)
+	}
+	account, err := (&models.Account{}).FindByAddress(rep, s.ChainID.Uint64(), strings.ToLower(sender.Hex()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Remain().Sign() != 0 {
+		t.Fatalf("remain gas is %s, expected 0", account.Remain())
+	}
+	var count int64
+	if err := rep.Model(&models.Sponsorship{}).Where(`"chain_id" = ?`, s.ChainID.Uint64()).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 10 {
+		t.Fatalf("%d sponsorships recorded, expected 10", count)
+	}
+}
+
+func TestSettlementCursor(t *testing.T) {
+	rep := testRepository(t)
+	s := testSigner(t, rep, 1000)
+
+	from, err := s.settlementStart(s.Contract, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 100 {
+		t.Fatalf("settlement of a new paymaster starts at %d, expected the latest block 100", from)
+	}
+	for _, block := range []uint64{120, 110} {
+		err := (&models.SettlementCursor{ChainID: s.ChainID.Uint64(), Paymaster: strings.ToLower(s.Contract.Hex()), Block: block}).Advance(rep)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	from, err = s.settlementStart(s.Contract, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 121 {
+		t.Fatalf("settlement resumes at %d, expected 121", from)
+	}
+}
+
+func TestReserveGasDuplicateSponsorship(t *testing.T) {
+	rep := testRepository(t)
+	s := testSigner(t, rep, 1000)
+	apiKey := testApiKey(t, rep)
+	sender := common.BytesToAddress(randomBytes(t, 20))
+
+	record := testSponsorship(t, s, apiKey, sender, 100)
+	if existing, err := s.reserveGas(apiKey, record, 0); err != nil || existing != nil {
+		t.Fatalf("first sponsorship: %v, %v", existing, err)
+	}
+	again := *record
+	again.ID = 0
+	existing, err := s.reserveGas(apiKey, &again, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existing == nil || existing.ID != record.ID {
+		t.Fatalf("sponsorship signed again returns %v, expected the recorded one", existing)
+	}
+
+	other := testApiKey(t, rep)
+	again = *record
+	again.ID = 0
+	again.ApiKeyID = other.ID
+	if _, err := s.reserveGas(other, &again, 0); err == nil || !strings.Contains(err.Error(), "already sponsored") {
+		t.Fatalf("sponsorship of another api key returns %v, expected a duplicate error", err)
+	}
+
+	account, err := (&models.Account{}).FindByAddress(rep, s.ChainID.Uint64(), strings.ToLower(sender.Hex()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Remain().Int64() != 900 {
+		t.Fatalf("remain gas is %s, expected 900", account.Remain())
+	}
+}
+
+func TestReserveGasDisabledAccount(t *testing.T) {
+	rep := testRepository(t)
+	s := testSigner(t, rep, 1000)
+	apiKey := testApiKey(t, rep)
+	sender := strings.ToLower(common.BytesToAddress(randomBytes(t, 20)).Hex())
+
+	account := &models.Account{ChainID: s.ChainID.Uint64(), Address: sender, Enable: false, RemainGas: "1000", UsedGas: "0"}
+	if err := rep.Create(account).Error; err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.reserveGas(apiKey, testSponsorship(t, s, apiKey, common.HexToAddress(sender), 100), 0)
+	rpcErr, ok := err.(*errors.RPCError)
+	if !ok || rpcErr.Code() != errors.REJECTED_BY_PAYMASTER {
+		t.Fatalf("sponsorship of a disabled account returns %v, expected a rejection", err)
+	}
+	var count int64
+	if err := rep.Model(&models.Reservation{}).Where(`"account_id" = ?`, account.ID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("%d reservations of the disabled account, expected none", count)
+	}
+}
+
+func TestReserveGasStaticKey(t *testing.T) {
+	rep := testRepository(t)
+	s := testSigner(t, rep, 1000)
+	// the api_keys row sharing the id of the static key allows nothing
+	row := testApiKey(t, rep)
+	row.Limits.HardCap = "1"
+	if err := rep.Save(row).Error; err != nil {
+		t.Fatal(err)
+	}
+	static := &models.ApiKeys{Enable: true, Static: true, Limits: models.Limits{SenderMaxOps: 1}}
+	static.ID = row.ID
+	sender := common.BytesToAddress(randomBytes(t, 20))
+
+	if _, err := s.reserveGas(static, testSponsorship(t, s, static, sender, 100), 0); err != nil {
+		t.Fatalf("static key is limited by the api key row of its id: %v", err)
+	}
+	_, err := s.reserveGas(static, testSponsorship(t, s, static, sender, 100), 0)
+	if err == nil || !strings.Contains(err.Error(), "senderMaxOps") {
+		t.Fatalf("second sponsorship of the sender returns %v, expected the senderMaxOps of the static key", err)
+	}
+
+	if _, err := s.reserveGas(row, testSponsorship(t, s, row, common.BytesToAddress(randomBytes(t, 20)), 100), 0); err == nil {
+		t.Fatal("api key row is not limited by its hardCap")
+	}
+}
+
+func TestReserveGasConcurrentDepositCheck(t *testing.T) {
+	rep := testRepository(t)
+	s := testSigner(t, rep, 1000)
+	s.deposits = map[common.Address]*depositMonitor{
+		s.Contract: {
+			paymaster:  s.Contract,
+			entryPoint: s.EntryPoint,
+			info:       &contracts.IStakeManagerDepositInfo{Deposit: big.NewInt(1000)},
+		},
+	}
+	apiKey := testApiKey(t, rep)
+
+	// the senders differ so only the deposit check serializes the sponsorships
+	const calls = 20
+	records := make([]*models.Sponsorship, calls)
+	for i := range records {
+		records[i] = testSponsorship(t, s, apiKey, common.BytesToAddress(randomBytes(t, 20)), 100)
+	}
+	errs := make([]error, calls)
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = s.reserveGas(apiKey, records[i], 0)
+		}(i)
+	}
+	wg.Wait()
+
+	reserved := 0
+	for _, err := range errs {
+		if err == nil {
+			reserved++
+		} else if !strings.Contains(err.Error(), "Paymaster deposit is too low") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if reserved != 10 {
+		t.Fatalf("%d sponsorships reserved, expected 10", reserved)
+	}
+	outstanding, err := (&models.Reservation{}).SumPending(rep, s.ChainID.Uint64(), strings.ToLower(s.EntryPoint.Hex()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outstanding.Cmp(big.NewInt(1000)) != 0 {
+		t.Fatalf("pending reservations of %s, expected the deposit 1000", outstanding)
+	}
+}
+
+// rangeFilterer records the block ranges of the log queries and returns log from the range holding its block.
+type rangeFilterer struct {
+	ranges [][2]uint64
+	log    gethtypes.Log
+}
+
+func (f *rangeFilterer) FilterLogs(_ context.Context, query ethereum.FilterQuery) ([]gethtypes.Log, error) {
+	from, to := query.FromBlock.Uint64(), query.ToBlock.Uint64()
+	f.ranges = append(f.ranges, [2]uint64{from, to})
+	if from <= f.log.BlockNumber && f.log.BlockNumber <= to {
+		return []gethtypes.Log{f.log}, nil
+	}
+	return nil, nil
+}
+
+func (f *rangeFilterer) SubscribeFilterLogs(_ context.Context, _ ethereum.FilterQuery, _ chan<- gethtypes.Log) (ethereum.Subscription, error) {
+	return nil, stderrors.New("not supported")
+}
+
+func TestFindUserOperationEventRanges(t *testing.T) {
+	entryPointABI, err := contracts.EntryPointMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := entryPointABI.Events["UserOperationEvent"]
+	data, err := event.Inputs.NonIndexed().Pack(big.NewInt(0), true, big.NewInt(21000), big.NewInt(21000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryPoint := common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+	paymaster := common.BytesToAddress(randomBytes(t, 20))
+	userOpHash := common.BytesToHash(randomBytes(t, 32))
+	filterer := &rangeFilterer{log: gethtypes.Log{
+		Address:     entryPoint,
+		Topics:      []common.Hash{event.ID, userOpHash, common.BytesToHash(randomBytes(t, 20)), common.BytesToHash(paymaster.Bytes())},
+		Data:        data,
+		BlockNumber: 4200,
+	}}
+	entryPointFilterer, err := contracts.NewEntryPointFilterer(entryPoint, filterer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Signer{eventFilterers: map[common.Address]*contracts.EntryPointFilterer{paymaster: entryPointFilterer}}
+
+	ev, err := s.findUserOperationEvent(userOpHash, 100, 4500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev == nil || ev.ActualGasCost.Int64() != 21000 {
+		t.Fatalf("event %v, expected the event of block 4200", ev)
+	}
+	expected := [][2]uint64{{100, 2099}, {2100, 4099}, {4100, 4500}}
+	if !reflect.DeepEqual(filterer.ranges, expected) {
+		t.Fatalf("queried block ranges %v, expected %v", filterer.ranges, expected)
+	}
+
+	filterer.ranges = nil
+	if ev, err := s.findUserOperationEvent(userOpHash, 4300, 4500); err != nil || ev != nil {
+		t.Fatalf("event before the reservation block returns %v, %v", ev, err)
+	}
+	if !reflect.DeepEqual(filterer.ranges, [][2]uint64{{4300, 4500}}) {
+		t.Fatalf("queried block ranges %v, expected [4300 4500]", filterer.ranges)
+	}
+}
+
+func TestSettleRemovedEvent(t *testing.T) {
+	rep := testRepository(t)
+	s := testSigner(t, rep, 1000)
+	apiKey := testApiKey(t, rep)
+	sender := common.BytesToAddress(randomBytes(t, 20))
+	record := testSponsorship(t, s, apiKey, sender, 100)
+	if _, err := s.reserveGas(apiKey, record, 0); err != nil {
+		t.Fatal(err)
+	}
+	remain := func() int64 {
+		account, err := (&models.Account{}).FindByAddress(rep, s.ChainID.Uint64(), strings.ToLower(sender.Hex()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return account.Remain().Int64()
+	}
+	status := func() string {
+		reservations, err := (&models.Reservation{}).FindByHashes(rep, []string{record.UserOpHash})
+		if err != nil || len(reservations) != 1 {
+			t.Fatalf("reservations %v, %v", reservations, err)
+		}
+		return reservations[0].Status
+	}
+
+	ev := &contracts.EntryPointUserOperationEvent{UserOpHash: common.HexToHash(record.UserOpHash), ActualGasCost: big.NewInt(40)}
+	s.settleEvent(ev)
+	if status() != models.ReservationSettled || remain() != 960 {
+		t.Fatalf("settled reservation is %s with remain gas %d, expected settled with 960", status(), remain())
+	}
+	removed := *ev
+	removed.Raw.Removed = true
+	s.settleEvent(&removed)
+	if status() != models.ReservationPending || remain() != 900 {
+		t.Fatalf("reorged reservation is %s with remain gas %d, expected pending with 900", status(), remain())
+	}
+	s.settleEvent(ev)
+	if status() != models.ReservationSettled || remain() != 960 {
+		t.Fatalf("included again reservation is %s with remain gas %d, expected settled with 960", status(), remain())
+	}
+}
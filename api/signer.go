@@ -1,33 +1,38 @@
 package api
 
 import (
-	"crypto/ecdsa"
-	"errors"
+	"context"
+	"encoding/json"
+	"fmt"
 	"math/big"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
-	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 
 	"github.com/ququzone/verifying-paymaster-service/config"
 	"github.com/ququzone/verifying-paymaster-service/container"
 	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/gasprice"
 	"github.com/ququzone/verifying-paymaster-service/logger"
 	"github.com/ququzone/verifying-paymaster-service/models"
-	"github.com/ququzone/verifying-paymaster-service/types"
-	"github.com/ququzone/verifying-paymaster-service/utils"
+	"github.com/ququzone/verifying-paymaster-service/policy"
+	"github.com/ququzone/verifying-paymaster-service/quota"
+	"github.com/ququzone/verifying-paymaster-service/signer"
 )
 
 var (
-	// one day
-	validTimeDelay = new(big.Int).SetInt64(86400)
-	uint48Ty, _    = abi.NewType("uint256", "uint48", []abi.ArgumentMarshaling{})
-	timeRangeABI   = abi.Arguments{
+	uint48Ty, _  = abi.NewType("uint256", "uint48", []abi.ArgumentMarshaling{})
+	timeRangeABI = abi.Arguments{
 		{Name: "validUntil", Type: uint48Ty},
 		{Name: "validAfter", Type: uint48Ty},
 	}
@@ -53,164 +58,306 @@ type GasRemain struct {
 }
 
 type Signer struct {
-	Container  container.Container
-	Client     *ethclient.Client
-	Contract   common.Address
-	Paymaster  *contracts.VerifyingPaymaster
-	PrivateKey *ecdsa.PrivateKey
-	MaxGas     *big.Int
+	Container      container.Container
+	Client         *ethclient.Client
+	RPC            *rpc.Client
+	ChainID        *big.Int
+	Contract       common.Address
+	Paymaster      *contracts.VerifyingPaymaster
+	EntryPoint     common.Address
+	EntryPointV07  common.Address
+	ContractV07    common.Address
+	PaymasterV07   *bind.BoundContract
+	SimulationsV07 []byte
+	Keys           *signer.Keyring
+	MaxGas         *big.Int
+	Policies       *policy.Engine
+	Windows        *quota.Windows
+	// EntryPoints are the entry points accepted by pm_sponsorUserOperation.
+	EntryPoints []common.Address
+
+	hashCheck *hashChecker
+	validity  *validityPolicy
+	gasPrice  *gasPricePolicy
+	pvg       PVGStrategy
+	// paymasterVerificationGas and paymasterPostOpGas are the v0.7 paymaster gas limits.
+	paymasterVerificationGas *big.Int
+	paymasterPostOpGas       *big.Int
+	// gasPrices are the fees suggested by the fee history of the chain.
+	gasPrices *gasprice.Oracle
+	// deposits are the deposit monitors of the paymasters.
+	deposits map[common.Address]*depositMonitor
+	// eventFilterers filter the UserOperationEvents of the paymasters.
+	eventFilterers map[common.Address]*contracts.EntryPointFilterer
+	// verifyingSigners are the on-chain verifying signers of the paymasters.
+	verifyingSigners   map[common.Address]common.Address
+	verifyingSignersMu sync.RWMutex
 }
 
-func NewSigner(con container.Container) (*Signer, error) {
+// NewSigner creates the signer of chain signing with the keys of keys, policies and quota windows are shared by
+// the signers of all chains. It fails if a paymaster has a verifying signer without key.
+func NewSigner(con container.Container, chain *config.Chain, keys *signer.Keyring, policies *policy.Engine, windows *quota.Windows) (*Signer, error) {
 	conf := config.Config()
-	keyData, err := os.ReadFile(conf.Keystore)
+	rpcClient, client, chainID, err := dialChain(chain.RPC)
+	if err != nil {
+		return nil, err
+	}
+	if chain.ChainID != 0 && chainID.Uint64() != chain.ChainID {
+		return nil, fmt.Errorf("chain id of rpc is %d, expected %d", chainID, chain.ChainID)
+	}
+	logger.S().Infof("Chain %d VerifyingPaymaster contract: %s", chainID, chain.Contract)
+
+	contract := common.HexToAddress(chain.Contract)
+	paymaster, err := contracts.NewVerifyingPaymaster(contract, client)
 	if err != nil {
 		return nil, err
 	}
-	keystore, err := keystore.DecryptKey(keyData, conf.Passphrase)
+
+	entryPoint, err := paymaster.EntryPoint(nil)
+	if err != nil {
+		return nil, fmt.Errorf("query entry point of paymaster %s: %v", contract, err)
+	}
+	logger.S().Infof("Chain %d VerifyingPaymaster entry point: %s", chainID, entryPoint)
+
+	var simulationsV07 []byte
+	entryPointV07 := common.HexToAddress(chain.EntryPointV07)
+	if chain.ContractV07 != "" {
+		logger.S().Infof("Chain %d VerifyingPaymaster v0.7 contract: %s", chainID, chain.ContractV07)
+		paymasterV07, err := contracts.NewVerifyingPaymasterCaller(common.HexToAddress(chain.ContractV07), client)
+		if err != nil {
+			return nil, err
+		}
+		onChain, err := paymasterV07.EntryPoint(nil)
+		if err != nil {
+			return nil, fmt.Errorf("query entry point of paymaster %s: %v", chain.ContractV07, err)
+		}
+		if onChain != entryPointV07 {
+			return nil, fmt.Errorf("entry point of paymaster %s is %s, expected %s", chain.ContractV07, onChain, entryPointV07)
+		}
+		if chain.SimulationsV07 != "" {
+			code, err := os.ReadFile(chain.SimulationsV07)
+			if err != nil {
+				return nil, err
+			}
+			simulationsV07, err = hexutil.Decode(strings.TrimSpace(string(code)))
+			if err != nil {
+				return nil, fmt.Errorf("decode v0.7 simulations code: %v", err)
+			}
+		}
+	}
+
+	maxGas, ok := new(big.Int).SetString(chain.MaxGas, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid max gas %s", chain.MaxGas)
+	}
+
+	entryPoints, err := supportedEntryPoints(chain, entryPoint)
 	if err != nil {
 		return nil, err
 	}
-	logger.S().Infof("VerifyingPaymaster contract: %s", conf.Contract)
-	logger.S().Infof("VerifyingPaymaster signer: %s", keystore.Address.String())
 
-	rpc, err := ethclient.Dial(conf.RPC)
+	validity, err := newValidityPolicy(conf)
 	if err != nil {
 		return nil, err
 	}
 
-	contract := common.HexToAddress(conf.Contract)
-	paymaster, err := contracts.NewVerifyingPaymaster(contract, rpc)
+	gasPrice, err := newGasPricePolicy(chain)
+	if err != nil {
+		return nil, err
+	}
+	pvg, err := NewPVGStrategy(chain.PVGStrategy, client)
 	if err != nil {
 		return nil, err
 	}
+	logger.S().Infof("Chain %d preVerificationGas strategy: %s", chainID, chain.PVGStrategy)
 
-	maxGas, _ := new(big.Int).SetString(conf.MaxGas, 10)
+	s := &Signer{
+		Container:      con,
+		Client:         client,
+		RPC:            rpcClient,
+		ChainID:        chainID,
+		Contract:       contract,
+		Paymaster:      paymaster,
+		EntryPoint:     entryPoint,
+		EntryPointV07:  entryPointV07,
+		ContractV07:    common.HexToAddress(chain.ContractV07),
+		PaymasterV07:   bind.NewBoundContract(common.HexToAddress(chain.ContractV07), verifyingPaymasterV07ABI, client, client, client),
+		SimulationsV07: simulationsV07,
+		Keys:           keys,
+		MaxGas:         maxGas,
+		Policies:       policies,
+		Windows:        windows,
+		EntryPoints:    entryPoints,
+		hashCheck:      &hashChecker{interval: time.Duration(conf.HashCheckInterval) * time.Second},
+		validity:       validity,
+		gasPrice:       gasPrice,
+		pvg:            pvg,
 
-	return &Signer{
-		Container:  con,
-		Client:     rpc,
-		Contract:   contract,
-		Paymaster:  paymaster,
-		PrivateKey: keystore.PrivateKey,
-		MaxGas:     maxGas,
-	}, nil
+		paymasterVerificationGas: new(big.Int).SetUint64(*chain.PaymasterVerificationGas),
+		paymasterPostOpGas:       new(big.Int).SetUint64(*chain.PaymasterPostOpGas),
+
+		verifyingSigners: make(map[common.Address]common.Address),
+	}
+	if err := s.checkVerifyingSigners(true); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
+// dialChain connects to the first reachable url of urls.
+func dialChain(urls []string) (*rpc.Client, *ethclient.Client, *big.Int, error) {
+	err := fmt.Errorf("no rpc url")
+	for _, url := range urls {
+		var rpcClient *rpc.Client
+		rpcClient, err = rpc.Dial(url)
+		if err != nil {
+			logger.S().Warnf("dial rpc %s error: %v", url, err)
+			continue
+		}
+		client := ethclient.NewClient(rpcClient)
+		var chainID *big.Int
+		chainID, err = client.ChainID(context.Background())
+		if err != nil {
+			logger.S().Warnf("query chain id of rpc %s error: %v", url, err)
+			client.Close()
+			continue
+		}
+		return rpcClient, client, chainID, nil
+	}
+	return nil, nil, nil, err
+}
+
+// PaymasterResult holds paymasterAndData for EntryPoint v0.6 and
+// paymaster, paymasterData and the paymaster gas limits for EntryPoint v0.7.
 type PaymasterResult struct {
-	PaymasterAndData     string `json:"paymasterAndData"`
-	PreVerificationGas   string `json:"preVerificationGas"`
-	VerificationGasLimit string `json:"verificationGasLimit"`
-	CallGasLimit         string `json:"callGasLimit"`
+	PaymasterAndData              string `json:"paymasterAndData,omitempty"`
+	Paymaster                     string `json:"paymaster,omitempty"`
+	PaymasterData                 string `json:"paymasterData,omitempty"`
+	PaymasterVerificationGasLimit string `json:"paymasterVerificationGasLimit,omitempty"`
+	PaymasterPostOpGasLimit       string `json:"paymasterPostOpGasLimit,omitempty"`
+	PreVerificationGas            string `json:"preVerificationGas"`
+	VerificationGasLimit          string `json:"verificationGasLimit"`
+	CallGasLimit                  string `json:"callGasLimit"`
+	ValidUntil                    string `json:"validUntil"`
+	ValidAfter                    string `json:"validAfter"`
+	// Context echoes the context param of the sponsorship.
+	Context *SponsorContext `json:"context,omitempty"`
+	// MaxFeePerGas and MaxPriorityFeePerGas are the clamped fees the operation is signed with.
+	MaxFeePerGas         string `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas,omitempty"`
 }
 
-func (s *Signer) Pm_sponsorUserOperation(op map[string]any, entryPoint string, ctx interface{}) (*PaymasterResult, error) {
-	userOp, err := types.NewUserOperation(op)
+func (s *Signer) Pm_sponsorUserOperation(apiKey *models.ApiKeys, op map[string]any, entryPoint string, ctx interface{}) (*PaymasterResult, error) {
+	entryPointAddr, err := s.entryPointOf(entryPoint)
 	if err != nil {
 		return nil, err
 	}
-
-	account, err := (&models.Account{}).FindByAddress(s.Container.GetRepository(), strings.ToLower(userOp.Sender.String()))
-	if nil != err || account == nil {
-		account = &models.Account{
-			Address:     strings.ToLower(strings.ToLower(userOp.Sender.String())),
-			Enable:      true,
-			UsedGas:     "0",
-			RemainGas:   s.MaxGas.String(),
-			LastRequest: time.Now(),
-		}
-		err = s.Container.GetRepository().Save(account).Error
-		if nil != err {
-			logger.S().Errorf("save account error: %v", err)
-			return nil, err
-		}
+	sponsorCtx, err := parseContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	userOp, err := s.newSponsoredOp(op, entryPointAddr)
+	if err != nil {
+		return nil, err
+	}
+	requestHash, err := userOp.requestHash()
+	if err != nil {
+		return nil, err
+	}
+	// a retry of an operation still sponsored is answered without estimating and charging it again
+	existing, err := (&models.Sponsorship{}).FindValidByRequestHash(s.Container.GetRepository(), s.ChainID.Uint64(), apiKey.ID, requestHash.Hex(), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return recordedResult(existing)
+	}
+	clamped, err := s.checkGasPrice(userOp)
+	if err != nil {
+		return nil, err
+	}
+	validUntil, validAfter := s.validity.window(apiKey, sponsorCtx, time.Now())
+	err = userOp.estimate(validUntil, validAfter)
+	if err != nil {
+		return nil, err
 	}
 
-	tempOp, _ := types.NewUserOperation(op)
-	preVerificationGas, verificationGas, callGas, err := estimate(
-		s.Client,
-		s.PrivateKey,
-		s.Contract,
-		s.Paymaster,
-		common.HexToAddress(entryPoint),
-		tempOp,
-	)
+	err = s.Policies.Evaluate(apiKey.ID, sponsorCtx.PolicyID, userOp.policyOperation())
 	if err != nil {
 		return nil, err
 	}
 
-	remainGas, _ := new(big.Int).SetString(account.RemainGas, 10)
-	totalGas := new(big.Int).Add(preVerificationGas, verificationGas)
-	totalGas = new(big.Int).Add(totalGas, callGas)
-	totalGas = new(big.Int).Mul(totalGas, userOp.MaxFeePerGas)
-	if totalGas.Cmp(remainGas) > 0 {
-		if account.LastRequest.Unix()+86400 < time.Now().Unix() {
-			account.LastRequest = time.Now()
-			account.RemainGas = s.MaxGas.String()
-			err = s.Container.GetRepository().Save(account).Error
-			if nil != err {
-				logger.S().Errorf("save account error: %v", err)
-				return nil, err
-			}
-		}
+	result, err := userOp.sign(validUntil, validAfter)
+	if err != nil {
+		return nil, err
 	}
-	remainGas, _ = new(big.Int).SetString(account.RemainGas, 10)
-	if totalGas.Cmp(remainGas) > 0 {
-		return nil, errors.New("insufficient gas")
+	result.ValidUntil = hexutil.EncodeBig(validUntil)
+	result.ValidAfter = hexutil.EncodeBig(validAfter)
+	if clamped {
+		result.MaxFeePerGas = hexutil.EncodeBig(userOp.maxFeePerGas())
+		result.MaxPriorityFeePerGas = hexutil.EncodeBig(userOp.maxPriorityFeePerGas())
 	}
-	usedGas, _ := new(big.Int).SetString(account.UsedGas, 10)
-	account.UsedGas = new(big.Int).Add(usedGas, totalGas).String()
-	account.RemainGas = new(big.Int).Sub(remainGas, totalGas).String()
-	err = s.Container.GetRepository().Save(account).Error
-	if nil != err {
-		logger.S().Errorf("save account error: %v", err)
+	if !sponsorCtx.empty() {
+		result.Context = sponsorCtx
+	}
+	userOpHash, err := userOp.hash()
+	if err != nil {
 		return nil, err
 	}
-
-	// TODO: verify op rules:
-	//  1. normal gas
-	//  2. only for create
-	validAfter := new(big.Int).SetInt64(time.Now().Unix())
-	validUntil := new(big.Int).Add(validAfter, validTimeDelay)
-	timeRangeData, err := timeRangeABI.Pack(validUntil, validAfter)
+	resultData, err := json.Marshal(result)
 	if err != nil {
 		return nil, err
 	}
-	userOp.PaymasterAndData = append(append(s.Contract.Bytes(), timeRangeData...), emptySignature...)
-	userOp.Signature = []byte{}
 
-	hash, err := s.Paymaster.GetHash(nil, contracts.UserOperation{
-		Sender:               userOp.Sender,
-		Nonce:                userOp.Nonce,
-		InitCode:             userOp.InitCode,
-		CallData:             userOp.CallData,
-		CallGasLimit:         callGas,
-		VerificationGasLimit: verificationGas,
-		PreVerificationGas:   preVerificationGas,
-		MaxFeePerGas:         userOp.MaxFeePerGas,
-		MaxPriorityFeePerGas: userOp.MaxPriorityFeePerGas,
-		PaymasterAndData:     userOp.PaymasterAndData,
-		Signature:            userOp.Signature,
-	}, validUntil, validAfter)
+	record := userOp.record()
+	record.UserOpHash = userOpHash.Hex()
+	record.RequestHash = requestHash.Hex()
+	record.ChainID = s.ChainID.Uint64()
+	record.ApiKeyID = apiKey.ID
+	record.EntryPoint = strings.ToLower(entryPointAddr.Hex())
+	record.MaxCost = new(big.Int).Mul(userOp.gasLimit(), userOp.maxFeePerGas()).String()
+	record.ValidUntil = time.Unix(validUntil.Int64(), 0)
+	record.ValidAfter = time.Unix(validAfter.Int64(), 0)
+	record.Signer = strings.ToLower(userOp.signer().Address().Hex())
+	record.Result = string(resultData)
+	record.PolicyID = sponsorCtx.PolicyID
+	record.SponsorshipType = sponsorCtx.SponsorshipType
+	if result.Context != nil {
+		contextData, err := json.Marshal(result.Context)
+		if err != nil {
+			return nil, err
+		}
+		record.Context = string(contextData)
+	}
+	block, err := s.Client.BlockNumber(context.Background())
 	if err != nil {
 		return nil, err
 	}
-	signature, err := utils.SignMessage(s.PrivateKey, hash[:])
+	existing, err = s.reserveGas(apiKey, record, block)
 	if err != nil {
 		return nil, err
 	}
+	if existing != nil {
+		return recordedResult(existing)
+	}
+	return result, nil
+}
 
-	// TODO: set gas
-	return &PaymasterResult{
-		PaymasterAndData:     hexutil.Encode(append(append(s.Contract.Bytes(), timeRangeData...), signature...)),
-		PreVerificationGas:   hexutil.Encode(preVerificationGas.Bytes()),
-		VerificationGasLimit: hexutil.Encode(verificationGas.Bytes()),
-		CallGasLimit:         hexutil.Encode(callGas.Bytes()),
-	}, nil
+// recordedResult returns the result recorded with a sponsorship.
+func recordedResult(record *models.Sponsorship) (*PaymasterResult, error) {
+	var recorded PaymasterResult
+	if err := json.Unmarshal([]byte(record.Result), &recorded); err != nil {
+		return nil, err
+	}
+	return &recorded, nil
 }
 
-func (s *Signer) Pm_gasRemain(addr string) (*GasRemain, error) {
-	account, err := (&models.Account{}).FindByAddress(s.Container.GetRepository(), strings.ToLower(addr))
+func (s *Signer) Pm_gasRemain(apiKey *models.ApiKeys, addr string) (*GasRemain, error) {
+	window, err := s.Windows.Get(apiKey.QuotaWindow)
+	if err != nil {
+		return nil, err
+	}
+	account, err := (&models.Account{}).FindByAddress(s.Container.GetRepository(), s.ChainID.Uint64(), strings.ToLower(addr))
 	if nil != err {
 		logger.S().Errorf("Query account error: %v", err)
 		return nil, err
@@ -222,6 +369,12 @@ func (s *Signer) Pm_gasRemain(addr string) (*GasRemain, error) {
 			LastRequest: 0,
 		}, nil
 	}
+	// the remain gas the account would have once refilled, without saving it
+	_, err = window.Refill(s.Container.GetRepository(), account, s.MaxGas, time.Now())
+	if err != nil {
+		logger.S().Errorf("Refill account error: %v", err)
+		return nil, err
+	}
 	return &GasRemain{
 		Remain:      account.RemainGas,
 		Used:        account.UsedGas,
@@ -229,31 +382,38 @@ func (s *Signer) Pm_gasRemain(addr string) (*GasRemain, error) {
 	}, nil
 }
 
-func (s *Signer) Pm_requestGas(addr string) (bool, error) {
-	account, err := (&models.Account{}).FindByAddress(s.Container.GetRepository(), strings.ToLower(addr))
-	if nil != err {
-		logger.S().Errorf("Query account error: %v", err)
+func (s *Signer) Pm_requestGas(apiKey *models.ApiKeys, addr string) (bool, error) {
+	window, err := s.Windows.Get(apiKey.QuotaWindow)
+	if err != nil {
 		return false, err
 	}
-	if account != nil {
+	err = s.Container.GetRepository().Transaction(func(tx db.Repository) error {
+		account, created, err := (&models.Account{}).FindForUpdate(tx, s.ChainID.Uint64(), strings.ToLower(addr), s.MaxGas.String())
+		if nil != err {
+			logger.S().Errorf("Query account error: %v", err)
+			return err
+		}
+		if created {
+			return nil
+		}
 		if !account.Enable {
-			return false, errors.New("account disabled")
+			return errors.NewRPCError(errors.REJECTED_BY_PAYMASTER, "account disabled", nil)
 		}
-		if account.LastRequest.Unix()+86400 > time.Now().Unix() {
-			return false, errors.New("frequent requests")
+		refilled, err := window.Refill(tx, account, s.MaxGas, time.Now())
+		if nil != err {
+			logger.S().Errorf("Refill account error: %v", err)
+			return err
 		}
-	} else {
-		account = &models.Account{
-			Address: strings.ToLower(addr),
-			Enable:  true,
-			UsedGas: "0",
+		if !refilled {
+			return errors.NewRPCError(errors.REJECTED_BY_PAYMASTER, "frequent requests", nil)
 		}
-	}
-	account.RemainGas = s.MaxGas.String()
-	account.LastRequest = time.Now()
-	err = s.Container.GetRepository().Save(account).Error
-	if nil != err {
-		logger.S().Errorf("save account error: %v", err)
+		err = tx.Save(account).Error
+		if nil != err {
+			logger.S().Errorf("save account error: %v", err)
+		}
+		return err
+	})
+	if err != nil {
 		return false, err
 	}
 
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/ququzone/verifying-paymaster-service/config"
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/gasprice"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/policy"
+	"github.com/ququzone/verifying-paymaster-service/signer"
+)
+
+// fakeEth is the eth namespace of a node answering the calls of a v0.6 sponsorship: senderNonce is 0 and
+// simulateHandleOp returns its ExecutionResult as the result, as the node of the paymaster does.
+type fakeEth struct {
+	simulations int
+}
+
+func (f *fakeEth) BlockNumber() hexutil.Uint64 {
+	return 100
+}
+
+func (f *fakeEth) GetCode(_ common.Address, _ string) hexutil.Bytes {
+	return nil
+}
+
+func (f *fakeEth) Call(args map[string]interface{}, _ string) (hexutil.Bytes, error) {
+	input, _ := args["input"].(string)
+	if input == "" {
+		input, _ = args["data"].(string)
+	}
+	if strings.HasPrefix(input, hexutil.Encode(entryPointABIForTest.Methods["simulateHandleOp"].ID)) {
+		f.simulations++
+		result := executionResult()
+		data, err := result.Inputs.Pack(big.NewInt(50000), big.NewInt(0), big.NewInt(0), big.NewInt(0), true, []byte{})
+		if err != nil {
+			return nil, err
+		}
+		return append(append([]byte{}, result.ID[:4]...), data...), nil
+	}
+	return make([]byte, 32), nil
+}
+
+var entryPointABIForTest, _ = contracts.EntryPointMetaData.GetAbi()
+
+// testSponsorSigner returns a v0.6 signer of the chain answered by eth.
+func testSponsorSigner(t *testing.T, rep db.Repository, eth *fakeEth) *Signer {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", eth); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	client := ethclient.NewClient(rpc.DialInProc(server))
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := signer.NewLocal(key)
+	keys, err := signer.NewKeyring(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testSigner(t, rep, 1e18)
+	s.Client = client
+	s.Paymaster, err = contracts.NewVerifyingPaymaster(s.Contract, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Keys = keys
+	s.EntryPoints = []common.Address{s.EntryPoint}
+	s.Policies = policy.NewEngine(rep)
+	s.hashCheck = &hashChecker{}
+	s.validity = &validityPolicy{validFor: time.Hour, minValidFor: time.Minute, maxValidFor: 24 * time.Hour, maxValidAfter: time.Hour}
+	minFeeMultiplier := 0.0
+	s.gasPrice, err = newGasPricePolicy(&config.Chain{MaxFeeMultiplier: 3, MinFeeMultiplier: &minFeeMultiplier, FeeCapMode: feeCapReject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.gasPrices = gasprice.NewOracle(&fakeFeeHistory{history: &ethereum.FeeHistory{}, gasPrice: big.NewInt(1000)}, 1, time.Hour)
+	if err := s.gasPrices.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.pvg, err = NewPVGStrategy(PVGEvm, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.verifyingSigners = map[common.Address]common.Address{s.Contract: local.Address()}
+	return s
+}
+
+func TestSponsorUserOperationRetry(t *testing.T) {
+	rep := testRepository(t)
+	eth := &fakeEth{}
+	s := testSponsorSigner(t, rep, eth)
+	apiKey := testApiKey(t, rep)
+	sender := common.BytesToAddress(randomBytes(t, 20))
+	op := func() map[string]any {
+		return map[string]any{
+			"sender":               sender.Hex(),
+			"nonce":                "0x0",
+			"initCode":             "0x",
+			"callData":             "0xb61d27f6",
+			"callGasLimit":         "0x5208",
+			"verificationGasLimit": "0x10000",
+			"preVerificationGas":   "0xc350",
+			"maxFeePerGas":         "0x3e8",
+			"maxPriorityFeePerGas": "0x3e8",
+			"paymasterAndData":     "0x",
+			"signature":            "0x",
+		}
+	}
+
+	first, err := s.Pm_sponsorUserOperation(apiKey, op(), s.EntryPoint.Hex(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the retry is signed in a later second, with another validity window and paymaster signature
+	time.Sleep(time.Second)
+	second, err := s.Pm_sponsorUserOperation(apiKey, op(), s.EntryPoint.Hex(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *first != *second {
+		t.Fatalf("retry returns %+v, expected the first sponsorship %+v", second, first)
+	}
+	if eth.simulations != 1 {
+		t.Fatalf("operation simulated %d times, expected once", eth.simulations)
+	}
+
+	var reservations int64
+	err = rep.Model(&models.Reservation{}).
+		Joins(`JOIN "sponsorships" ON "sponsorships"."user_op_hash" = "reservations"."user_op_hash"`).
+		Where(`"sponsorships"."chain_id" = ?`, s.ChainID.Uint64()).
+		Count(&reservations).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reservations != 1 {
+		t.Fatalf("%d reservations of the operation, expected one", reservations)
+	}
+
+	// another nonce is another operation
+	other := op()
+	other["nonce"] = "0x1"
+	third, err := s.Pm_sponsorUserOperation(apiKey, other, s.EntryPoint.Hex(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.PaymasterAndData == first.PaymasterAndData {
+		t.Fatal("operation of another nonce returns the first sponsorship")
+	}
+}
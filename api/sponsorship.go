@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+const maxSponsorshipsLimit = 100
+
+// Sponsorship is the audit record of a signed operation with the state of its reservation.
+type Sponsorship struct {
+	UserOpHash                    string           `json:"userOpHash"`
+	Sender                        string           `json:"sender"`
+	Nonce                         string           `json:"nonce"`
+	EntryPoint                    string           `json:"entryPoint"`
+	CallGasLimit                  string           `json:"callGasLimit"`
+	VerificationGasLimit          string           `json:"verificationGasLimit"`
+	PreVerificationGas            string           `json:"preVerificationGas"`
+	PaymasterVerificationGasLimit string           `json:"paymasterVerificationGasLimit"`
+	PaymasterPostOpGasLimit       string           `json:"paymasterPostOpGasLimit"`
+	MaxFeePerGas                  string           `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas          string           `json:"maxPriorityFeePerGas"`
+	MaxCost                       string           `json:"maxCost"`
+	ValidUntil                    int64            `json:"validUntil"`
+	ValidAfter                    int64            `json:"validAfter"`
+	Signer                        string           `json:"signer"`
+	PaymasterAndData              string           `json:"paymasterAndData"`
+	Result                        *PaymasterResult `json:"result"`
+	PolicyID                      string           `json:"policyId"`
+	SponsorshipType               string           `json:"sponsorshipType"`
+	Context                       *SponsorContext  `json:"context"`
+	Status                        string           `json:"status"`
+	ActualGasCost                 string           `json:"actualGasCost"`
+	CreatedAt                     int64            `json:"createdAt"`
+}
+
+func (s *Signer) Pm_getSponsorship(apiKey *models.ApiKeys, userOpHash string) (*Sponsorship, error) {
+	record, err := (&models.Sponsorship{}).FindByHash(s.Container.GetRepository(), s.ChainID.Uint64(), apiKey.ID, strings.ToLower(userOpHash))
+	if err != nil {
+		logger.S().Errorf("Query sponsorship error: %v", err)
+		return nil, err
+	}
+	if record == nil {
+		return nil, nil
+	}
+	sponsorships, err := s.toSponsorships([]models.Sponsorship{*record})
+	if err != nil {
+		return nil, err
+	}
+	return sponsorships[0], nil
+}
+
+func (s *Signer) Pm_getSponsorshipsBySender(apiKey *models.ApiKeys, sender string, offset int, limit int) ([]*Sponsorship, error) {
+	if err := checkPage(offset, limit); err != nil {
+		return nil, err
+	}
+	records, err := (&models.Sponsorship{}).FindBySender(s.Container.GetRepository(), s.ChainID.Uint64(), apiKey.ID, strings.ToLower(sender), offset, limit)
+	if err != nil {
+		logger.S().Errorf("Query sponsorship error: %v", err)
+		return nil, err
+	}
+	return s.toSponsorships(records)
+}
+
+func (s *Signer) Pm_getSponsorshipsByKey(apiKey *models.ApiKeys, offset int, limit int) ([]*Sponsorship, error) {
+	if err := checkPage(offset, limit); err != nil {
+		return nil, err
+	}
+	records, err := (&models.Sponsorship{}).FindByKey(s.Container.GetRepository(), s.ChainID.Uint64(), apiKey.ID, offset, limit)
+	if err != nil {
+		logger.S().Errorf("Query sponsorship error: %v", err)
+		return nil, err
+	}
+	return s.toSponsorships(records)
+}
+
+func checkPage(offset int, limit int) error {
+	if offset < 0 || limit <= 0 || limit > maxSponsorshipsLimit {
+		return errors.NewRPCError(errors.INVALID_FIELDS, "invalid offset or limit", nil)
+	}
+	return nil
+}
+
+func (s *Signer) toSponsorships(records []models.Sponsorship) ([]*Sponsorship, error) {
+	hashes := make([]string, len(records))
+	for i, record := range records {
+		hashes[i] = record.UserOpHash
+	}
+	reservations := make(map[string]models.Reservation)
+	if len(hashes) > 0 {
+		recs, err := (&models.Reservation{}).FindByHashes(s.Container.GetRepository(), hashes)
+		if err != nil {
+			logger.S().Errorf("Query reservation error: %v", err)
+			return nil, err
+		}
+		for _, rec := range recs {
+			reservations[rec.UserOpHash] = rec
+		}
+	}
+
+	sponsorships := make([]*Sponsorship, len(records))
+	for i, record := range records {
+		var result PaymasterResult
+		if err := json.Unmarshal([]byte(record.Result), &result); err != nil {
+			return nil, err
+		}
+		var context *SponsorContext
+		if record.Context != "" {
+			context = &SponsorContext{}
+			if err := json.Unmarshal([]byte(record.Context), context); err != nil {
+				return nil, err
+			}
+		}
+		reservation := reservations[record.UserOpHash]
+		sponsorships[i] = &Sponsorship{
+			UserOpHash:                    record.UserOpHash,
+			Sender:                        record.Sender,
+			Nonce:                         record.Nonce,
+			EntryPoint:                    record.EntryPoint,
+			CallGasLimit:                  record.CallGasLimit,
+			VerificationGasLimit:          record.VerificationGasLimit,
+			PreVerificationGas:            record.PreVerificationGas,
+			PaymasterVerificationGasLimit: record.PaymasterVerificationGasLimit,
+			PaymasterPostOpGasLimit:       record.PaymasterPostOpGasLimit,
+			MaxFeePerGas:                  record.MaxFeePerGas,
+			MaxPriorityFeePerGas:          record.MaxPriorityFeePerGas,
+			MaxCost:                       record.MaxCost,
+			ValidUntil:                    record.ValidUntil.Unix(),
+			ValidAfter:                    record.ValidAfter.Unix(),
+			Signer:                        record.Signer,
+			PaymasterAndData:              record.PaymasterAndData,
+			Result:                        &result,
+			PolicyID:                      record.PolicyID,
+			SponsorshipType:               record.SponsorshipType,
+			Context:                       context,
+			Status:                        reservation.Status,
+			ActualGasCost:                 reservation.ActualGasCost,
+			CreatedAt:                     record.CreatedAt.Unix(),
+		}
+	}
+	return sponsorships, nil
+}
@@ -0,0 +1,395 @@
+package api
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/policy"
+	"github.com/ququzone/verifying-paymaster-service/signer"
+	"github.com/ququzone/verifying-paymaster-service/types"
+)
+
+// sponsoredOp is the entry point version specific part of a sponsorship.
+type sponsoredOp interface {
+	// estimate replaces the gas limits of the operation with the ones simulated for the validity window.
+	estimate(validUntil *big.Int, validAfter *big.Int) error
+	// sign returns the paymaster fields signed for the validity window.
+	sign(validUntil *big.Int, validAfter *big.Int) (*PaymasterResult, error)
+	// hash returns the entry point userOpHash of the signed operation.
+	hash() (common.Hash, error)
+	// requestHash returns the userOpHash of the operation as requested without its paymaster fields, the same
+	// for every retry of the operation. It is computed before the operation is estimated and signed.
+	requestHash() (common.Hash, error)
+	sender() common.Address
+	// gasLimit returns the sum of all gas limits of the operation.
+	gasLimit() *big.Int
+	maxFeePerGas() *big.Int
+	maxPriorityFeePerGas() *big.Int
+	// setFees replaces the fees of the operation, before it is estimated and signed.
+	setFees(maxFeePerGas *big.Int, maxPriorityFeePerGas *big.Int)
+	policyOperation() *policy.Operation
+	// record returns the audit record of the signed operation with the version specific fields filled.
+	record() *models.Sponsorship
+	// signer returns the verifying signer key signing the operation.
+	signer() signer.Signer
+}
+
+func (s *Signer) newSponsoredOp(op map[string]any, entryPoint common.Address) (sponsoredOp, error) {
+	if entryPoint == s.EntryPointV07 {
+		if s.ContractV07 == (common.Address{}) {
+			return nil, errors.NewRPCError(errors.INVALID_FIELDS, "Entry point v0.7 is not supported", nil)
+		}
+		key, err := s.keyOf(s.ContractV07)
+		if err != nil {
+			return nil, err
+		}
+		userOp, err := types.NewUserOperationV07(op)
+		if err != nil {
+			return nil, invalidUserOperation(err)
+		}
+		return &sponsoredOpV07{s: s, key: key, entryPoint: entryPoint, op: userOp}, nil
+	}
+
+	key, err := s.keyOf(s.Contract)
+	if err != nil {
+		return nil, err
+	}
+	userOp, err := types.NewUserOperation(op)
+	if err != nil {
+		return nil, invalidUserOperation(err)
+	}
+	return &sponsoredOpV06{s: s, key: key, entryPoint: entryPoint, op: userOp}, nil
+}
+
+func invalidUserOperation(err error) error {
+	return errors.NewRPCError(errors.INVALID_FIELDS, "Invalid user operation", err.Error())
+}
+
+type sponsoredOpV06 struct {
+	s           *Signer
+	key         signer.Signer
+	entryPoint  common.Address
+	op          *types.UserOperation
+	senderNonce *big.Int
+}
+
+// getSenderNonce loads VerifyingPaymaster.senderNonce once per sponsorship.
+func (o *sponsoredOpV06) getSenderNonce() (*big.Int, error) {
+	if o.senderNonce == nil {
+		nonce, err := o.s.Paymaster.SenderNonce(nil, o.op.Sender)
+		if err != nil {
+			return nil, err
+		}
+		o.senderNonce = nonce
+	}
+	return o.senderNonce, nil
+}
+
+func (o *sponsoredOpV06) estimate(validUntil *big.Int, validAfter *big.Int) error {
+	senderNonce, err := o.getSenderNonce()
+	if err != nil {
+		return err
+	}
+	tempOp := *o.op
+	preVerificationGas, verificationGas, callGas, err := estimate(
+		o.s.Client,
+		o.key,
+		o.s.pvg,
+		o.s.ChainID,
+		o.s.Contract,
+		senderNonce,
+		o.entryPoint,
+		&tempOp,
+		validUntil,
+		validAfter,
+	)
+	if err != nil {
+		return err
+	}
+	o.op.PreVerificationGas = preVerificationGas
+	o.op.VerificationGasLimit = verificationGas
+	o.op.CallGasLimit = callGas
+	return nil
+}
+
+func (o *sponsoredOpV06) sign(validUntil *big.Int, validAfter *big.Int) (*PaymasterResult, error) {
+	timeRangeData, err := timeRangeABI.Pack(validUntil, validAfter)
+	if err != nil {
+		return nil, err
+	}
+	o.op.PaymasterAndData = append(append(o.s.Contract.Bytes(), timeRangeData...), emptySignature...)
+	o.op.Signature = []byte{}
+
+	senderNonce, err := o.getSenderNonce()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := paymasterHashV06(o.op, o.s.ChainID, o.s.Contract, senderNonce, validUntil, validAfter)
+	if err != nil {
+		return nil, err
+	}
+	if o.s.hashCheck.due() {
+		if err := o.checkHash(hash, validUntil, validAfter); err != nil {
+			return nil, err
+		}
+	}
+	signature, err := o.key.SignMessage(hash[:])
+	if err != nil {
+		return nil, err
+	}
+	o.op.PaymasterAndData = append(append(o.s.Contract.Bytes(), timeRangeData...), signature...)
+
+	return &PaymasterResult{
+		PaymasterAndData:     hexutil.Encode(o.op.PaymasterAndData),
+		PreVerificationGas:   hexutil.Encode(o.op.PreVerificationGas.Bytes()),
+		VerificationGasLimit: hexutil.Encode(o.op.VerificationGasLimit.Bytes()),
+		CallGasLimit:         hexutil.Encode(o.op.CallGasLimit.Bytes()),
+	}, nil
+}
+
+// checkHash compares the local paymaster hash with VerifyingPaymaster.getHash.
+func (o *sponsoredOpV06) checkHash(hash common.Hash, validUntil *big.Int, validAfter *big.Int) error {
+	onchain, err := o.s.Paymaster.GetHash(nil, contracts.UserOperation{
+		Sender:               o.op.Sender,
+		Nonce:                o.op.Nonce,
+		InitCode:             o.op.InitCode,
+		CallData:             o.op.CallData,
+		CallGasLimit:         o.op.CallGasLimit,
+		VerificationGasLimit: o.op.VerificationGasLimit,
+		PreVerificationGas:   o.op.PreVerificationGas,
+		MaxFeePerGas:         o.op.MaxFeePerGas,
+		MaxPriorityFeePerGas: o.op.MaxPriorityFeePerGas,
+		PaymasterAndData:     o.op.PaymasterAndData,
+		Signature:            o.op.Signature,
+	}, validUntil, validAfter)
+	if err != nil {
+		return err
+	}
+	if hash != onchain {
+		logger.S().Errorf("paymaster hash mismatch, local: %s, onchain: %s", hash, common.Hash(onchain))
+		return errHashMismatch
+	}
+	return nil
+}
+
+func (o *sponsoredOpV06) hash() (common.Hash, error) {
+	return userOpHashV06(o.op, o.entryPoint, o.s.ChainID)
+}
+
+func (o *sponsoredOpV06) requestHash() (common.Hash, error) {
+	op := *o.op
+	op.PaymasterAndData = nil
+	return userOpHashV06(&op, o.entryPoint, o.s.ChainID)
+}
+
+func (o *sponsoredOpV06) sender() common.Address {
+	return o.op.Sender
+}
+
+func (o *sponsoredOpV06) gasLimit() *big.Int {
+	gas := new(big.Int).Add(o.op.PreVerificationGas, o.op.VerificationGasLimit)
+	return gas.Add(gas, o.op.CallGasLimit)
+}
+
+func (o *sponsoredOpV06) maxFeePerGas() *big.Int {
+	return o.op.MaxFeePerGas
+}
+
+func (o *sponsoredOpV06) maxPriorityFeePerGas() *big.Int {
+	return o.op.MaxPriorityFeePerGas
+}
+
+func (o *sponsoredOpV06) setFees(maxFeePerGas *big.Int, maxPriorityFeePerGas *big.Int) {
+	o.op.MaxFeePerGas = maxFeePerGas
+	o.op.MaxPriorityFeePerGas = maxPriorityFeePerGas
+}
+
+func (o *sponsoredOpV06) policyOperation() *policy.Operation {
+	return &policy.Operation{
+		Sender:       o.op.Sender,
+		Factory:      o.op.GetFactory(),
+		CallData:     o.op.CallData,
+		TotalGas:     o.gasLimit(),
+		MaxFeePerGas: o.op.MaxFeePerGas,
+	}
+}
+
+func (o *sponsoredOpV06) record() *models.Sponsorship {
+	return &models.Sponsorship{
+		Sender:                        strings.ToLower(o.op.Sender.Hex()),
+		Nonce:                         o.op.Nonce.String(),
+		CallGasLimit:                  o.op.CallGasLimit.String(),
+		VerificationGasLimit:          o.op.VerificationGasLimit.String(),
+		PreVerificationGas:            o.op.PreVerificationGas.String(),
+		PaymasterVerificationGasLimit: "0",
+		PaymasterPostOpGasLimit:       "0",
+		MaxFeePerGas:                  o.op.MaxFeePerGas.String(),
+		MaxPriorityFeePerGas:          o.op.MaxPriorityFeePerGas.String(),
+		PaymasterAndData:              hexutil.Encode(o.op.PaymasterAndData),
+	}
+}
+
+func (o *sponsoredOpV06) signer() signer.Signer {
+	return o.key
+}
+
+type sponsoredOpV07 struct {
+	s          *Signer
+	key        signer.Signer
+	entryPoint common.Address
+	op         *types.UserOperationV07
+}
+
+func (o *sponsoredOpV07) estimate(validUntil *big.Int, validAfter *big.Int) error {
+	tempOp := *o.op
+	preVerificationGas, verificationGas, callGas, err := estimateV07(
+		o.s.Client,
+		o.s.RPC,
+		o.key,
+		o.s.pvg,
+		o.s.ChainID,
+		o.s.ContractV07,
+		o.s.paymasterVerificationGas,
+		o.s.paymasterPostOpGas,
+		o.entryPoint,
+		o.s.SimulationsV07,
+		&tempOp,
+		validUntil,
+		validAfter,
+	)
+	if err != nil {
+		return err
+	}
+	o.op.PreVerificationGas = preVerificationGas
+	o.op.VerificationGasLimit = verificationGas
+	o.op.CallGasLimit = callGas
+	o.op.Paymaster = o.s.ContractV07
+	o.op.PaymasterVerificationGasLimit = o.s.paymasterVerificationGas
+	o.op.PaymasterPostOpGasLimit = o.s.paymasterPostOpGas
+	return nil
+}
+
+func (o *sponsoredOpV07) sign(validUntil *big.Int, validAfter *big.Int) (*PaymasterResult, error) {
+	timeRangeData, err := timeRangeABI.Pack(validUntil, validAfter)
+	if err != nil {
+		return nil, err
+	}
+	o.op.PaymasterData = append(timeRangeData, emptySignature...)
+
+	hash, err := paymasterHashV07(o.op, o.s.ChainID, o.s.ContractV07, validUntil, validAfter)
+	if err != nil {
+		return nil, err
+	}
+	if o.s.hashCheck.due() {
+		if err := o.checkHash(hash, validUntil, validAfter); err != nil {
+			return nil, err
+		}
+	}
+	signature, err := o.key.SignMessage(hash[:])
+	if err != nil {
+		return nil, err
+	}
+	o.op.PaymasterData = append(timeRangeData, signature...)
+
+	return &PaymasterResult{
+		Paymaster:                     o.op.Paymaster.Hex(),
+		PaymasterData:                 hexutil.Encode(o.op.PaymasterData),
+		PaymasterVerificationGasLimit: hexutil.EncodeBig(o.op.PaymasterVerificationGasLimit),
+		PaymasterPostOpGasLimit:       hexutil.EncodeBig(o.op.PaymasterPostOpGasLimit),
+		PreVerificationGas:            hexutil.EncodeBig(o.op.PreVerificationGas),
+		VerificationGasLimit:          hexutil.EncodeBig(o.op.VerificationGasLimit),
+		CallGasLimit:                  hexutil.EncodeBig(o.op.CallGasLimit),
+	}, nil
+}
+
+// checkHash compares the local paymaster hash with VerifyingPaymaster.getHash.
+func (o *sponsoredOpV07) checkHash(hash common.Hash, validUntil *big.Int, validAfter *big.Int) error {
+	var out []interface{}
+	if err := o.s.PaymasterV07.Call(nil, &out, "getHash", o.op.Packed(), validUntil, validAfter); err != nil {
+		return err
+	}
+	onchain := *abi.ConvertType(out[0], new([32]byte)).(*[32]byte)
+	if hash != onchain {
+		logger.S().Errorf("paymaster hash mismatch, local: %s, onchain: %s", hash, common.Hash(onchain))
+		return errHashMismatch
+	}
+	return nil
+}
+
+func (o *sponsoredOpV07) hash() (common.Hash, error) {
+	return userOpHashV07(o.op, o.entryPoint, o.s.ChainID)
+}
+
+func (o *sponsoredOpV07) requestHash() (common.Hash, error) {
+	op := *o.op
+	op.Paymaster = common.Address{}
+	return userOpHashV07(&op, o.entryPoint, o.s.ChainID)
+}
+
+func (o *sponsoredOpV07) sender() common.Address {
+	return o.op.Sender
+}
+
+func (o *sponsoredOpV07) gasLimit() *big.Int {
+	gas := new(big.Int).Add(o.op.PreVerificationGas, o.op.VerificationGasLimit)
+	gas.Add(gas, o.op.CallGasLimit)
+	gas.Add(gas, bigOrZero(o.op.PaymasterVerificationGasLimit))
+	return gas.Add(gas, bigOrZero(o.op.PaymasterPostOpGasLimit))
+}
+
+func (o *sponsoredOpV07) maxFeePerGas() *big.Int {
+	return o.op.MaxFeePerGas
+}
+
+func (o *sponsoredOpV07) maxPriorityFeePerGas() *big.Int {
+	return o.op.MaxPriorityFeePerGas
+}
+
+func (o *sponsoredOpV07) setFees(maxFeePerGas *big.Int, maxPriorityFeePerGas *big.Int) {
+	o.op.MaxFeePerGas = maxFeePerGas
+	o.op.MaxPriorityFeePerGas = maxPriorityFeePerGas
+}
+
+func (o *sponsoredOpV07) policyOperation() *policy.Operation {
+	return &policy.Operation{
+		Sender:       o.op.Sender,
+		Factory:      o.op.GetFactory(),
+		CallData:     o.op.CallData,
+		TotalGas:     o.gasLimit(),
+		MaxFeePerGas: o.op.MaxFeePerGas,
+	}
+}
+
+func (o *sponsoredOpV07) record() *models.Sponsorship {
+	return &models.Sponsorship{
+		Sender:                        strings.ToLower(o.op.Sender.Hex()),
+		Nonce:                         o.op.Nonce.String(),
+		CallGasLimit:                  o.op.CallGasLimit.String(),
+		VerificationGasLimit:          o.op.VerificationGasLimit.String(),
+		PreVerificationGas:            o.op.PreVerificationGas.String(),
+		PaymasterVerificationGasLimit: bigOrZero(o.op.PaymasterVerificationGasLimit).String(),
+		PaymasterPostOpGasLimit:       bigOrZero(o.op.PaymasterPostOpGasLimit).String(),
+		MaxFeePerGas:                  o.op.MaxFeePerGas.String(),
+		MaxPriorityFeePerGas:          o.op.MaxPriorityFeePerGas.String(),
+		PaymasterAndData:              hexutil.Encode(o.op.PaymasterAndData()),
+	}
+}
+
+func (o *sponsoredOpV07) signer() signer.Signer {
+	return o.key
+}
+
+func bigOrZero(n *big.Int) *big.Int {
+	if n == nil {
+		return new(big.Int)
+	}
+	return n
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/config"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// validityPolicy chooses the validity window of sponsorships.
+type validityPolicy struct {
+	validFor      time.Duration
+	minValidFor   time.Duration
+	maxValidFor   time.Duration
+	maxValidAfter time.Duration
+}
+
+func newValidityPolicy(conf *config.Values) (*validityPolicy, error) {
+	p := &validityPolicy{
+		validFor:      time.Duration(conf.ValidFor) * time.Second,
+		minValidFor:   time.Duration(conf.ValidForMin) * time.Second,
+		maxValidFor:   time.Duration(conf.ValidForMax) * time.Second,
+		maxValidAfter: time.Duration(conf.ValidAfterMax) * time.Second,
+	}
+	if p.minValidFor <= 0 || p.minValidFor > p.maxValidFor {
+		return nil, fmt.Errorf("invalid validity bounds [%d, %d]", conf.ValidForMin, conf.ValidForMax)
+	}
+	return p, nil
+}
+
+// window returns validUntil and validAfter of a sponsorship signed at now. The validity is requested
+// by ctx, or else set by the api key, or else the default, and clamped to the bounds. A validAfter
+// in the future schedules the operation, at most maxValidAfter ahead. The validity is compared in
+// seconds, the requested one may not fit a time.Duration.
+func (p *validityPolicy) window(apiKey *models.ApiKeys, ctx *SponsorContext, now time.Time) (validUntil *big.Int, validAfter *big.Int) {
+	validFor := uint64(p.validFor / time.Second)
+	if apiKey.ValidFor > 0 {
+		validFor = uint64(apiKey.ValidFor)
+	}
+	if ctx.ValidForSeconds > 0 {
+		validFor = ctx.ValidForSeconds
+	}
+	if bound := uint64(p.minValidFor / time.Second); validFor < bound {
+		validFor = bound
+	}
+	if bound := uint64(p.maxValidFor / time.Second); validFor > bound {
+		validFor = bound
+	}
+
+	after := uint64(now.Unix())
+	if ctx.ValidAfter > after {
+		after = ctx.ValidAfter
+		if latest := uint64(now.Add(p.maxValidAfter).Unix()); after > latest {
+			after = latest
+		}
+	}
+	validAfter = new(big.Int).SetUint64(after)
+	validUntil = new(big.Int).SetUint64(after + validFor)
+	return validUntil, validAfter
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+var (
+	// ErrNoKey is returned when the request carries no api key.
+	ErrNoKey = errors.New("no api key")
+	// ErrInvalidKey is returned when the api key is unknown, disabled or its signature does not match.
+	ErrInvalidKey = errors.New("invalid api key")
+)
+
+// Principal is the caller of a request.
+type Principal struct {
+	ApiKey *models.ApiKeys
+	// User is the owner of the api key, nil if the key has no user.
+	User *models.User
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return p.ApiKey.HasScope(scope)
+}
+
+// Authenticator resolves the caller of a request.
+type Authenticator interface {
+	// Authenticate returns the principal of the request, ErrNoKey or ErrInvalidKey
+	// if it can not be authenticated, or another error on failure.
+	Authenticate(c *gin.Context) (*Principal, error)
+}
+
+// HeaderApiKey carries the api key as an alternative to "Authorization: Bearer <key>".
+const HeaderApiKey = "X-Api-Key"
+
+// keyOf returns the api key of the request from the Authorization or X-Api-Key header,
+// or from the request path.
+func keyOf(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if key := c.GetHeader(HeaderApiKey); key != "" {
+		return key
+	}
+	return c.Param("key")
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of ctx, nil if the request was not authenticated.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// DBAuthenticator authenticates the api key of the request against the key hashes of the api_keys table.
+type DBAuthenticator struct {
+	rep db.Repository
+}
+
+func NewDBAuthenticator(rep db.Repository) *DBAuthenticator {
+	return &DBAuthenticator{rep: rep}
+}
+
+func (a *DBAuthenticator) Authenticate(c *gin.Context) (*Principal, error) {
+	key := keyOf(c)
+	if key == "" {
+		return nil, ErrNoKey
+	}
+	apiKey, err := (&models.ApiKeys{}).FindByKey(a.rep, key)
+	if err != nil {
+		logger.S().Errorf("Query api error: %v", err)
+		return nil, err
+	}
+	if apiKey == nil || !apiKey.Enable {
+		return nil, ErrInvalidKey
+	}
+	return principalOf(a.rep, apiKey)
+}
+
+// principalOf loads the user of apiKey.
+func principalOf(rep db.Repository, apiKey *models.ApiKeys) (*Principal, error) {
+	p := &Principal{ApiKey: apiKey}
+	if apiKey.UserID == 0 {
+		return p, nil
+	}
+	user, err := (&models.User{}).FindByID(rep, apiKey.UserID)
+	if err != nil {
+		logger.S().Errorf("Query user error: %v", err)
+		return nil, err
+	}
+	p.User = user
+	return p, nil
+}
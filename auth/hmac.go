@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+const (
+	HeaderKeyID     = "X-Api-Key-Id"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderSignature = "X-Signature"
+)
+
+// HMACAuthenticator authenticates requests signed with the signing secret of the api key, so neither
+// the key nor the secret is sent. The request carries the id of the api key, a unix timestamp and
+// the Sign signature of the timestamp and the body.
+type HMACAuthenticator struct {
+	// find returns the principal of the enabled api key id, nil if there is none.
+	find   func(id uint) (*Principal, error)
+	window time.Duration
+	now    func() time.Time
+	// secrets decrypts the signing secrets, nil when they are stored in plain.
+	secrets *models.SecretKey
+}
+
+// NewHMACAuthenticator accepts signatures whose timestamp is at most window away from now,
+// the signing secrets are decrypted with secrets.
+func NewHMACAuthenticator(rep db.Repository, window time.Duration, secrets *models.SecretKey) *HMACAuthenticator {
+	return &HMACAuthenticator{
+		find: func(id uint) (*Principal, error) {
+			apiKey, err := (&models.ApiKeys{}).FindByID(rep, id)
+			if err != nil {
+				logger.S().Errorf("Query api error: %v", err)
+				return nil, err
+			}
+			if apiKey == nil || !apiKey.Enable {
+				return nil, nil
+			}
+			return principalOf(rep, apiKey)
+		},
+		window:  window,
+		now:     time.Now,
+		secrets: secrets,
+	}
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>" keyed by secret.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *HMACAuthenticator) Authenticate(c *gin.Context) (*Principal, error) {
+	keyID := c.GetHeader(HeaderKeyID)
+	if keyID == "" {
+		return nil, ErrNoKey
+	}
+	id, err := strconv.ParseUint(keyID, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	timestamp := c.GetHeader(HeaderTimestamp)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	if d := a.now().Sub(time.Unix(ts, 0)); d > a.window || d < -a.window {
+		return nil, ErrInvalidKey
+	}
+	signature, err := hex.DecodeString(c.GetHeader(HeaderSignature))
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	principal, err := a.find(uint(id))
+	if err != nil {
+		return nil, err
+	}
+	// keys created before signing secrets have to be rotated to sign requests
+	if principal == nil || principal.ApiKey.SigningSecret == "" {
+		return nil, ErrInvalidKey
+	}
+	secret, err := principal.ApiKey.GetSigningSecret(a.secrets)
+	if err != nil {
+		return nil, fmt.Errorf("api key %d: %v", id, err)
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	expected, _ := hex.DecodeString(Sign(secret, timestamp, body))
+	if !hmac.Equal(expected, signature) {
+		return nil, ErrInvalidKey
+	}
+	return principal, nil
+}
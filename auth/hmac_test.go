@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+const testSecret = "signing-secret"
+
+func testHMACAuthenticator(now time.Time) *HMACAuthenticator {
+	keys := map[uint]*models.ApiKeys{
+		1: {KeyHash: models.HashKey("key"), SigningSecret: testSecret, Enable: true},
+		// a key created before signing secrets
+		2: {KeyHash: models.HashKey("legacy"), Enable: true},
+	}
+	return &HMACAuthenticator{
+		find: func(id uint) (*Principal, error) {
+			apiKey, ok := keys[id]
+			if !ok {
+				return nil, nil
+			}
+			return &Principal{ApiKey: apiKey}, nil
+		},
+		window: 5 * time.Minute,
+		now:    func() time.Time { return now },
+	}
+}
+
+func signedContext(keyID string, timestamp string, signature string, body string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(body))
+	if keyID != "" {
+		c.Request.Header.Set(HeaderKeyID, keyID)
+	}
+	c.Request.Header.Set(HeaderTimestamp, timestamp)
+	c.Request.Header.Set(HeaderSignature, signature)
+	return c
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.{}' | openssl dgst -sha256 -hmac signing-secret
+	if signature := Sign(testSecret, "1700000000", []byte("{}")); signature != "c9bc082220a5d4868468c40cacc351b4152b44775388b129015b891c876ccdd9" {
+		t.Fatalf("signature %s", signature)
+	}
+}
+
+func TestHMACAuthenticate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := testHMACAuthenticator(now)
+	body := `{"jsonrpc":"2.0","method":"pm_supportedEntryPoints","id":1}`
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	for _, tc := range []struct {
+		name      string
+		keyID     string
+		timestamp string
+		signature string
+		body      string
+		err       error
+	}{
+		{"valid", "1", ts, Sign(testSecret, ts, []byte(body)), body, nil},
+		{"uppercase signature", "1", ts, strings.ToUpper(Sign(testSecret, ts, []byte(body))), body, nil},
+		{"no key id", "", ts, Sign(testSecret, ts, []byte(body)), body, ErrNoKey},
+		{"invalid key id", "a", ts, Sign(testSecret, ts, []byte(body)), body, ErrInvalidKey},
+		{"unknown key", "3", ts, Sign(testSecret, ts, []byte(body)), body, ErrInvalidKey},
+		{"key without secret", "2", ts, Sign("", ts, []byte(body)), body, ErrInvalidKey},
+		{"signed with the key hash", "1", ts, Sign(models.HashKey("key"), ts, []byte(body)), body, ErrInvalidKey},
+		{"other body", "1", ts, Sign(testSecret, ts, []byte(body)), `{}`, ErrInvalidKey},
+		{"other timestamp", "1", ts, Sign(testSecret, "1700000001", []byte(body)), body, ErrInvalidKey},
+		{"invalid signature", "1", ts, "zz", body, ErrInvalidKey},
+		{"invalid timestamp", "1", "now", Sign(testSecret, "now", []byte(body)), body, ErrInvalidKey},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := signedContext(tc.keyID, tc.timestamp, tc.signature, tc.body)
+			principal, err := a.Authenticate(c)
+			if err != tc.err {
+				t.Fatalf("error %v, expected %v", err, tc.err)
+			}
+			if err == nil && principal.ApiKey.SigningSecret != testSecret {
+				t.Fatalf("principal of another key")
+			}
+		})
+	}
+}
+
+func TestHMACAuthenticateBodyIsKept(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	body := `{"jsonrpc":"2.0","method":"rpc_modules","id":1}`
+	c := signedContext("1", ts, Sign(testSecret, ts, []byte(body)), body)
+	if _, err := testHMACAuthenticator(now).Authenticate(c); err != nil {
+		t.Fatal(err)
+	}
+	if read, err := io.ReadAll(c.Request.Body); err != nil || string(read) != body {
+		t.Fatalf("body %q after authentication", read)
+	}
+}
+
+func TestHMACAuthenticateTimestampSkew(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := testHMACAuthenticator(now)
+	body := `{}`
+	for _, tc := range []struct {
+		skew time.Duration
+		err  error
+	}{
+		{0, nil},
+		{-5 * time.Minute, nil},
+		{5 * time.Minute, nil},
+		{-5*time.Minute - time.Second, ErrInvalidKey},
+		{5*time.Minute + time.Second, ErrInvalidKey},
+		{-time.Hour, ErrInvalidKey},
+		{time.Hour, ErrInvalidKey},
+	} {
+		ts := strconv.FormatInt(now.Add(tc.skew).Unix(), 10)
+		c := signedContext("1", ts, Sign(testSecret, ts, []byte(body)), body)
+		if _, err := a.Authenticate(c); err != tc.err {
+			t.Errorf("skew %v: error %v, expected %v", tc.skew, err, tc.err)
+		}
+	}
+}
+
+func TestHMACAuthenticateSealedSecret(t *testing.T) {
+	key, err := models.NewSecretKey(strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := &models.ApiKeys{Enable: true}
+	if err := sealed.SetSigningSecret(key, testSecret); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed.SigningSecret, testSecret) {
+		t.Fatalf("signing secret %s is stored in plain", sealed.SigningSecret)
+	}
+
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	body := `{}`
+	a := testHMACAuthenticator(now)
+	a.find = func(id uint) (*Principal, error) { return &Principal{ApiKey: sealed}, nil }
+	a.secrets = key
+	if _, err := a.Authenticate(signedContext("1", ts, Sign(testSecret, ts, []byte(body)), body)); err != nil {
+		t.Fatalf("request signed with the sealed secret is rejected: %v", err)
+	}
+	if _, err := a.Authenticate(signedContext("1", ts, Sign(sealed.SigningSecret, ts, []byte(body)), body)); err != ErrInvalidKey {
+		t.Fatalf("request signed with the stored secret returns %v", err)
+	}
+
+	other, err := models.NewSecretKey(strings.Repeat("cd", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.secrets = other
+	if _, err := a.Authenticate(signedContext("1", ts, Sign(testSecret, ts, []byte(body)), body)); err == nil {
+		t.Fatal("signing secret is opened with another key")
+	}
+}
+
+func TestNewSecretKey(t *testing.T) {
+	if key, err := models.NewSecretKey(""); key != nil || err != nil {
+		t.Fatalf("empty key returns %v, %v", key, err)
+	}
+	for _, key := range []string{"zz", strings.Repeat("ab", 16)} {
+		if _, err := models.NewSecretKey(key); err == nil {
+			t.Errorf("key %s is accepted", key)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// staticKey is an api key of the keys file.
+type staticKey struct {
+	ID          uint   `json:"id"`
+	Key         string `json:"key"`
+	Description string `json:"description"`
+	Scopes      string `json:"scopes"`
+	QuotaWindow string `json:"quotaWindow"`
+	ValidFor    uint   `json:"validFor"`
+	Limits      struct {
+		DailyBudget     string `json:"dailyBudget"`
+		MonthlyBudget   string `json:"monthlyBudget"`
+		SenderAllowance string `json:"senderAllowance"`
+		SenderMaxOps    uint   `json:"senderMaxOps"`
+		HardCap         string `json:"hardCap"`
+	} `json:"limits"`
+}
+
+// StaticAuthenticator authenticates the api key of the request against the keys of a JSON file,
+// for deployments without key management in the database. The ids of the keys scope policies and audit records,
+// the quota of a key is enforced from the limits of the file.
+type StaticAuthenticator struct {
+	// keys by key hash
+	keys map[string]*models.ApiKeys
+}
+
+// NewStaticAuthenticator loads the keys file, a JSON array of {"id", "key", "description", "scopes", "quotaWindow", "validFor", "limits"}.
+func NewStaticAuthenticator(path string) (*StaticAuthenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var recs []staticKey
+	if err := json.Unmarshal(data, &recs); err != nil {
+		return nil, fmt.Errorf("parse keys file %s: %v", path, err)
+	}
+
+	keys := make(map[string]*models.ApiKeys, len(recs))
+	for _, rec := range recs {
+		if rec.ID == 0 || rec.Key == "" {
+			return nil, fmt.Errorf("keys file %s: key without id or key", path)
+		}
+		hash := models.HashKey(rec.Key)
+		if _, ok := keys[hash]; ok {
+			return nil, fmt.Errorf("keys file %s: duplicated key of id %d", path, rec.ID)
+		}
+		limits := models.Limits{
+			DailyBudget:     rec.Limits.DailyBudget,
+			MonthlyBudget:   rec.Limits.MonthlyBudget,
+			SenderAllowance: rec.Limits.SenderAllowance,
+			SenderMaxOps:    rec.Limits.SenderMaxOps,
+			HardCap:         rec.Limits.HardCap,
+		}
+		for _, budget := range []string{limits.DailyBudget, limits.MonthlyBudget, limits.SenderAllowance, limits.HardCap} {
+			if n, ok := new(big.Int).SetString(budget, 10); budget != "" && (!ok || n.Sign() < 0) {
+				return nil, fmt.Errorf("keys file %s: invalid limit %q of id %d", path, budget, rec.ID)
+			}
+		}
+		apiKey := &models.ApiKeys{
+			Enable:      true,
+			Description: rec.Description,
+			Limits:      limits,
+			Scopes:      rec.Scopes,
+			QuotaWindow: rec.QuotaWindow,
+			ValidFor:    rec.ValidFor,
+			Static:      true,
+		}
+		apiKey.ID = rec.ID
+		apiKey.SetKey(rec.Key)
+		keys[hash] = apiKey
+	}
+	return &StaticAuthenticator{keys: keys}, nil
+}
+
+func (a *StaticAuthenticator) Authenticate(c *gin.Context) (*Principal, error) {
+	key := keyOf(c)
+	if key == "" {
+		return nil, ErrNoKey
+	}
+	apiKey, ok := a.keys[models.HashKey(key)]
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	return &Principal{ApiKey: apiKey}, nil
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func writeKeysFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStaticAuthenticatorLimits(t *testing.T) {
+	a, err := NewStaticAuthenticator(writeKeysFile(t, `[
+		{"id": 1, "key": "key", "limits": {"dailyBudget": "1000", "senderMaxOps": 3}},
+		{"id": 2, "key": "unlimited"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate := func(key string) *Principal {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/rpc", nil)
+		c.Request.Header.Set("Authorization", "Bearer "+key)
+		p, err := a.Authenticate(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	key := authenticate("key").ApiKey
+	if !key.Static || key.ID != 1 {
+		t.Fatalf("key %+v is not the static key 1", key)
+	}
+	if key.Limits.GetDailyBudget().Int64() != 1000 || key.Limits.SenderMaxOps != 3 || key.Limits.GetHardCap().Sign() != 0 {
+		t.Errorf("unexpected limits %+v", key.Limits)
+	}
+	if limits := authenticate("unlimited").ApiKey.Limits; limits.GetDailyBudget().Sign() != 0 || limits.SenderMaxOps != 0 {
+		t.Errorf("unexpected limits %+v", limits)
+	}
+
+	for _, content := range []string{
+		`[{"id": 1, "key": "key", "limits": {"dailyBudget": "-1"}}]`,
+		`[{"id": 1, "key": "key", "limits": {"hardCap": "1e18"}}]`,
+	} {
+		if _, err := NewStaticAuthenticator(writeKeysFile(t, content)); err == nil {
+			t.Errorf("keys file %s is accepted", content)
+		}
+	}
+}
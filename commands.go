@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"github.com/ququzone/verifying-paymaster-service/config"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/treasury"
+)
+
+const commandTimeout = 5 * time.Minute
+
+// treasuryCommands are the subcommands managing the deposit and stake of a paymaster with the owner key.
+var treasuryCommands = map[string]func(t *treasury.Treasury, owner *bind.TransactOpts, args []string) error{
+	"status":   statusCommand,
+	"deposit":  depositCommand,
+	"stake":    stakeCommand,
+	"unlock":   unlockCommand,
+	"withdraw": withdrawCommand,
+}
+
+const paymasterUsage = "usage: paymaster deposit|stake|unlock|withdraw|status [-chain <id>] [-v07] [args]"
+
+// runCommand runs the "paymaster <command>" subcommand of args on the paymaster of a chain,
+// it reports false if args is not a subcommand.
+func runCommand(conf *config.Values, args []string) (bool, error) {
+	if len(args) == 0 || args[0] != "paymaster" {
+		return false, nil
+	}
+	if len(args) < 2 {
+		return true, errors.New(paymasterUsage)
+	}
+	command, ok := treasuryCommands[args[1]]
+	if !ok {
+		return true, fmt.Errorf("unknown paymaster command %s, %s", args[1], paymasterUsage)
+	}
+	args = args[1:]
+
+	fs := flag.NewFlagSet("paymaster "+args[0], flag.ContinueOnError)
+	chainID := fs.Uint64("chain", 0, "chain id of the paymaster, the first chain by default")
+	v07 := fs.Bool("v07", false, "use the EntryPoint v0.7 paymaster")
+	if err := fs.Parse(args[1:]); err != nil {
+		return true, err
+	}
+
+	chain, err := findChain(conf, *chainID)
+	if err != nil {
+		return true, err
+	}
+	address := chain.Contract
+	if *v07 {
+		address = chain.ContractV07
+	}
+	if !common.IsHexAddress(address) {
+		return true, errors.New("paymaster contract is not configured")
+	}
+	client, chainIDOfRPC, err := dialClient(chain.RPC)
+	if err != nil {
+		return true, err
+	}
+	defer client.Close()
+
+	t, err := treasury.New(client, common.HexToAddress(address))
+	if err != nil {
+		return true, err
+	}
+	var owner *bind.TransactOpts
+	if args[0] != "status" {
+		owner, err = treasury.KeystoreTransactor(conf.OwnerKeystore, conf.OwnerPassphrase, chainIDOfRPC)
+		if err != nil {
+			return true, fmt.Errorf("load owner key: %v", err)
+		}
+	}
+	return true, command(t, owner, fs.Args())
+}
+
+func findChain(conf *config.Values, chainID uint64) (*config.Chain, error) {
+	if chainID == 0 {
+		return conf.Chains[0], nil
+	}
+	for _, chain := range conf.Chains {
+		if chain.ChainID == chainID {
+			return chain, nil
+		}
+	}
+	return nil, fmt.Errorf("chain %d is not configured", chainID)
+}
+
+func dialClient(urls []string) (*ethclient.Client, *big.Int, error) {
+	err := errors.New("no rpc url")
+	for _, url := range urls {
+		var client *ethclient.Client
+		client, err = ethclient.Dial(url)
+		if err != nil {
+			continue
+		}
+		var chainID *big.Int
+		chainID, err = client.ChainID(context.Background())
+		if err != nil {
+			client.Close()
+			continue
+		}
+		return client, chainID, nil
+	}
+	return nil, nil, err
+}
+
+func statusCommand(t *treasury.Treasury, _ *bind.TransactOpts, _ []string) error {
+	status, err := t.Status()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// depositCommand deposits <amount> wei.
+func depositCommand(t *treasury.Treasury, owner *bind.TransactOpts, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: deposit <amount>")
+	}
+	amount, err := parseWei(args[0])
+	if err != nil {
+		return err
+	}
+	return send(t, owner, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.Deposit(opts, amount)
+	})
+}
+
+// stakeCommand stakes <amount> wei with <unstakeDelaySec>.
+func stakeCommand(t *treasury.Treasury, owner *bind.TransactOpts, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: stake <amount> <unstakeDelaySec>")
+	}
+	amount, err := parseWei(args[0])
+	if err != nil {
+		return err
+	}
+	var delay uint32
+	if _, err := fmt.Sscan(args[1], &delay); err != nil {
+		return fmt.Errorf("invalid unstake delay %s", args[1])
+	}
+	return send(t, owner, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.AddStake(opts, amount, delay)
+	})
+}
+
+func unlockCommand(t *treasury.Treasury, owner *bind.TransactOpts, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: unlock")
+	}
+	return send(t, owner, t.UnlockStake)
+}
+
+// withdrawCommand withdraws <amount> wei of the deposit, or the unlocked stake with "stake", to <to>.
+func withdrawCommand(t *treasury.Treasury, owner *bind.TransactOpts, args []string) error {
+	if len(args) != 2 || !common.IsHexAddress(args[0]) {
+		return errors.New("usage: withdraw <to> <amount|stake>")
+	}
+	to := common.HexToAddress(args[0])
+	if args[1] == "stake" {
+		return send(t, owner, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+			return t.WithdrawStake(opts, to)
+		})
+	}
+	amount, err := parseWei(args[1])
+	if err != nil {
+		return err
+	}
+	return send(t, owner, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.WithdrawTo(opts, to, amount)
+	})
+}
+
+// send sends the transaction of transact and waits until it is mined.
+func send(t *treasury.Treasury, owner *bind.TransactOpts, transact func(opts *bind.TransactOpts) (*types.Transaction, error)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	opts := *owner
+	opts.Context = ctx
+	tx, err := transact(&opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("sent transaction %s\n", tx.Hash())
+	receipt, err := t.Wait(ctx, tx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("mined in block %s\n", receipt.BlockNumber)
+	return statusCommand(t, nil, nil)
+}
+
+func parseWei(value string) (*big.Int, error) {
+	amount, ok := new(big.Int).SetString(value, 10)
+	if !ok || amount.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid amount %s", value)
+	}
+	return amount, nil
+}
+
+// startTopUps starts the deposit top up of the paymasters of chain when it has a threshold.
+func startTopUps(conf *config.Values, chain *config.Chain, client *ethclient.Client, chainID *big.Int, paymasters []common.Address) error {
+	if chain.TopUpThreshold == "" {
+		return nil
+	}
+	threshold, err := parseWei(chain.TopUpThreshold)
+	if err != nil {
+		return fmt.Errorf("top up threshold: %v", err)
+	}
+	amount, err := parseWei(chain.TopUpAmount)
+	if err != nil {
+		return fmt.Errorf("top up amount: %v", err)
+	}
+	funding, err := treasury.KeystoreTransactor(conf.FundingKeystore, conf.FundingPassphrase, chainID)
+	if err != nil {
+		return fmt.Errorf("load funding key: %v", err)
+	}
+	// the paymasters are topped up from the same wallet
+	funder := treasury.NewFunder(funding)
+	for _, paymaster := range paymasters {
+		t, err := treasury.New(client, paymaster)
+		if err != nil {
+			return err
+		}
+		logger.S().Infof("Chain %s paymaster %s deposit is topped up by %s below %s", chainID, paymaster, amount, threshold)
+		go treasury.NewTopUp(t, funder, threshold, amount).Run(time.Duration(conf.DepositCheckInterval) * time.Second)
+	}
+	return nil
+}
+
+func exitCommand(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ququzone/verifying-paymaster-service/config"
+	"github.com/ququzone/verifying-paymaster-service/treasury"
+)
+
+// minedBackend mines every transaction when it is sent.
+type minedBackend struct {
+	*backends.SimulatedBackend
+}
+
+func (b minedBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if err := b.SimulatedBackend.SendTransaction(ctx, tx); err != nil {
+		return err
+	}
+	b.Commit()
+	return nil
+}
+
+// newTreasury deploys the EntryPoint v0.6 and a VerifyingPaymaster owned by the returned owner.
+func newTreasury(t *testing.T) (*treasury.Treasury, *bind.TransactOpts, minedBackend) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance := new(big.Int).Exp(big.NewInt(10), big.NewInt(20), nil)
+	backend := minedBackend{backends.NewSimulatedBackend(core.GenesisAlloc{owner.From: {Balance: balance}}, 30000000)}
+	t.Cleanup(func() { backend.Close() })
+
+	entryPoint := deployFixture(t, backend, owner, "EntryPointV06")
+	tr, err := treasury.New(backend, deployFixture(t, backend, owner, "VerifyingPaymaster", entryPoint, owner.From))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr, owner, backend
+}
+
+func deployFixture(t *testing.T, backend minedBackend, auth *bind.TransactOpts, name string, args ...common.Address) common.Address {
+	code, err := os.ReadFile("contracts/testdata/" + name + ".bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytecode := hexutil.MustDecode("0x" + strings.TrimSpace(string(code)))
+	for _, arg := range args {
+		bytecode = append(bytecode, common.LeftPadBytes(arg.Bytes(), 32)...)
+	}
+	address, _, _, err := bind.DeployContract(auth, abi.ABI{}, bytecode, backend)
+	if err != nil {
+		t.Fatalf("deploy %s: %v", name, err)
+	}
+	return address
+}
+
+func TestRunCommandRouting(t *testing.T) {
+	conf := &config.Values{Chains: []*config.Chain{{}}}
+	for _, args := range [][]string{nil, {}, {"status"}, {"deposit", "1"}} {
+		if ok, _ := runCommand(conf, args); ok {
+			t.Errorf("%v is run as a paymaster command", args)
+		}
+	}
+	for _, args := range [][]string{{"paymaster"}, {"paymaster", "topup"}, {"paymaster", "status", "-unknown"}, {"paymaster", "status", "-chain", "5"}} {
+		ok, err := runCommand(conf, args)
+		if !ok || err == nil {
+			t.Errorf("%v is run: %v, error %v", args, ok, err)
+		}
+	}
+	// the paymaster contract is checked before dialing the chain
+	if _, err := runCommand(conf, []string{"paymaster", "status", "-v07"}); err == nil || err.Error() != "paymaster contract is not configured" {
+		t.Errorf("status of an unconfigured paymaster: %v", err)
+	}
+}
+
+func TestPaymasterCommands(t *testing.T) {
+	tr, owner, backend := newTreasury(t)
+	to := common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+
+	for _, tc := range []struct {
+		command string
+		args    []string
+	}{
+		{"deposit", []string{"3000"}},
+		{"stake", []string{"1000", "1"}},
+		{"withdraw", []string{to.Hex(), "1000"}},
+		{"unlock", nil},
+		{"withdraw", []string{to.Hex(), "stake"}},
+		{"status", nil},
+	} {
+		if err := treasuryCommands[tc.command](tr, owner, tc.args); err != nil {
+			t.Fatalf("%s %v: %v", tc.command, tc.args, err)
+		}
+		if tc.command == "unlock" {
+			// pass the unstake delay
+			if err := backend.AdjustTime(2 * time.Second); err != nil {
+				t.Fatal(err)
+			}
+			backend.Commit()
+		}
+	}
+	status, err := tr.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Deposit != "2000" || status.Staked || status.Stake != "0" {
+		t.Fatalf("status %+v", status)
+	}
+}
+
+func TestPaymasterCommandsUsage(t *testing.T) {
+	tr, owner, _ := newTreasury(t)
+	for _, tc := range []struct {
+		command string
+		args    []string
+	}{
+		{"deposit", nil},
+		{"deposit", []string{"0"}},
+		{"deposit", []string{"1e18"}},
+		{"stake", []string{"1000"}},
+		{"stake", []string{"1000", "a"}},
+		{"unlock", []string{"now"}},
+		{"withdraw", []string{"1000"}},
+		{"withdraw", []string{"0x1", "1000"}},
+		{"withdraw", []string{"0x000000000000000000000000000000000000dEaD", "-1"}},
+	} {
+		if err := treasuryCommands[tc.command](tr, owner, tc.args); err == nil {
+			t.Errorf("%s %v is accepted", tc.command, tc.args)
+		}
+	}
+}
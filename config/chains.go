@@ -0,0 +1,181 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Chain is the configuration of a network served by the deployment.
+type Chain struct {
+	// ChainID is checked against the chain id of the RPC, 0 takes the chain id of the RPC.
+	ChainID uint64 `json:"chainId"`
+	// RPC are the node urls, tried in order until one is reachable.
+	RPC            []string `json:"rpc"`
+	Contract       string   `json:"contract"`
+	EntryPointV07  string   `json:"entryPointV07"`
+	ContractV07    string   `json:"contractV07"`
+	SimulationsV07 string   `json:"simulationsV07"`
+	EntryPoints    []string `json:"entryPoints"`
+	MaxGas         string   `json:"maxGas"`
+	// TopUpThreshold and TopUpAmount enable the deposit top up of the paymasters, in wei.
+	TopUpThreshold string `json:"topUpThreshold"`
+	TopUpAmount    string `json:"topUpAmount"`
+	// MaxFeeMultiplier, MinFeeMultiplier and FeeCapMode are the gas price policy of the chain,
+	// MinFeeMultiplier is a pointer since 0 disables the min fee.
+	MaxFeeMultiplier float64  `json:"maxFeeMultiplier"`
+	MinFeeMultiplier *float64 `json:"minFeeMultiplier"`
+	FeeCapMode       string   `json:"feeCapMode"`
+	// PVGStrategy calculates the preVerificationGas on the chain, evm, optimism or arbitrum.
+	PVGStrategy string `json:"pvgStrategy"`
+	// PaymasterVerificationGas and PaymasterPostOpGas are the v0.7 paymaster gas limits of sponsored operations.
+	PaymasterVerificationGas *uint64 `json:"paymasterVerificationGas"`
+	PaymasterPostOpGas       *uint64 `json:"paymasterPostOpGas"`
+	// Key is the verifying signer key, Keys are more keys held for paymasters whose verifying
+	// signer is rotated to them.
+	Key
+	Keys []Key `json:"keys"`
+}
+
+// Key is the configuration of a verifying signer key.
+type Key struct {
+	Keystore      string `json:"keystore"`
+	Passphrase    string `json:"passphrase"`
+	Signer        string `json:"signer"`
+	SignerURL     string `json:"signerUrl"`
+	SignerMethod  string `json:"signerMethod"`
+	SignerAddress string `json:"signerAddress"`
+
+	// PKCS11Module is the library of the token holding the key labeled PKCS11KeyLabel of the pkcs11 signer.
+	PKCS11Module     string `json:"pkcs11Module"`
+	PKCS11TokenLabel string `json:"pkcs11TokenLabel"`
+	PKCS11PIN        string `json:"pkcs11Pin"`
+	PKCS11KeyLabel   string `json:"pkcs11KeyLabel"`
+}
+
+// loadChains reads the chains of the CHAINS_FILE JSON array, or builds the single chain of the
+// RPC and CONTRACT values without it. Omitted chain values default to the global values.
+func loadChains(v *Values) ([]*Chain, error) {
+	if v.ChainsFile == "" {
+		return []*Chain{{
+			RPC:                      []string{v.RPC},
+			Contract:                 v.Contract,
+			EntryPointV07:            v.EntryPointV07,
+			ContractV07:              v.ContractV07,
+			SimulationsV07:           v.SimulationsV07,
+			EntryPoints:              v.EntryPoints,
+			MaxGas:                   v.MaxGas,
+			TopUpThreshold:           v.TopUpThreshold,
+			TopUpAmount:              v.TopUpAmount,
+			MaxFeeMultiplier:         v.MaxFeeMultiplier,
+			MinFeeMultiplier:         &v.MinFeeMultiplier,
+			FeeCapMode:               v.FeeCapMode,
+			PVGStrategy:              v.PVGStrategy,
+			PaymasterVerificationGas: &v.PaymasterVerificationGas,
+			PaymasterPostOpGas:       &v.PaymasterPostOpGas,
+			Key: Key{
+				Keystore:      v.Keystore,
+				Passphrase:    v.Passphrase,
+				Signer:        v.Signer,
+				SignerURL:     v.SignerURL,
+				SignerMethod:  v.SignerMethod,
+				SignerAddress: v.SignerAddress,
+
+				PKCS11Module:     v.PKCS11Module,
+				PKCS11TokenLabel: v.PKCS11TokenLabel,
+				PKCS11PIN:        v.PKCS11PIN,
+				PKCS11KeyLabel:   v.PKCS11KeyLabel,
+			},
+		}}, nil
+	}
+
+	data, err := os.ReadFile(v.ChainsFile)
+	if err != nil {
+		return nil, err
+	}
+	var chains []*Chain
+	if err := json.Unmarshal(data, &chains); err != nil {
+		return nil, fmt.Errorf("parse chains file %s: %v", v.ChainsFile, err)
+	}
+	if len(chains) == 0 {
+		return nil, fmt.Errorf("chains file %s has no chain", v.ChainsFile)
+	}
+	for i, chain := range chains {
+		if len(chain.RPC) == 0 || chain.Contract == "" {
+			return nil, fmt.Errorf("chains file %s: chain %d without rpc or contract", v.ChainsFile, i)
+		}
+		if chain.EntryPointV07 == "" {
+			chain.EntryPointV07 = v.EntryPointV07
+		}
+		if chain.EntryPoints == nil {
+			chain.EntryPoints = v.EntryPoints
+		}
+		if chain.Keystore == "" {
+			chain.Keystore = v.Keystore
+			chain.Passphrase = v.Passphrase
+		}
+		if chain.Signer == "" {
+			chain.Signer = v.Signer
+			chain.SignerURL = v.SignerURL
+			chain.SignerMethod = v.SignerMethod
+			chain.SignerAddress = v.SignerAddress
+			chain.PKCS11TokenLabel = v.PKCS11TokenLabel
+			chain.PKCS11PIN = v.PKCS11PIN
+			chain.PKCS11KeyLabel = v.PKCS11KeyLabel
+		}
+		if chain.SignerMethod == "" {
+			chain.SignerMethod = v.SignerMethod
+		}
+		if chain.PKCS11Module == "" {
+			chain.PKCS11Module = v.PKCS11Module
+		}
+		for j := range chain.Keys {
+			if chain.Keys[j].Signer == "" {
+				chain.Keys[j].Signer = v.Signer
+			}
+			if chain.Keys[j].SignerMethod == "" {
+				chain.Keys[j].SignerMethod = v.SignerMethod
+			}
+			if chain.Keys[j].PKCS11Module == "" {
+				chain.Keys[j].PKCS11Module = v.PKCS11Module
+			}
+		}
+		if chain.MaxGas == "" {
+			chain.MaxGas = v.MaxGas
+		}
+		if chain.TopUpThreshold == "" {
+			chain.TopUpThreshold = v.TopUpThreshold
+			chain.TopUpAmount = v.TopUpAmount
+		}
+		if chain.MaxFeeMultiplier == 0 {
+			chain.MaxFeeMultiplier = v.MaxFeeMultiplier
+		}
+		if chain.MinFeeMultiplier == nil {
+			chain.MinFeeMultiplier = &v.MinFeeMultiplier
+		}
+		if chain.FeeCapMode == "" {
+			chain.FeeCapMode = v.FeeCapMode
+		}
+		if chain.PVGStrategy == "" {
+			chain.PVGStrategy = v.PVGStrategy
+		}
+		if chain.PaymasterVerificationGas == nil {
+			chain.PaymasterVerificationGas = &v.PaymasterVerificationGas
+		}
+		if chain.PaymasterPostOpGas == nil {
+			chain.PaymasterPostOpGas = &v.PaymasterPostOpGas
+		}
+	}
+	return chains, nil
+}
+
+// checkSimulations requires the EntryPointSimulations code of the chains with a v0.7 paymaster,
+// v0.7 operations can not be estimated without it.
+func checkSimulations(chains []*Chain) error {
+	for i, chain := range chains {
+		if chain.ContractV07 != "" && chain.SimulationsV07 == "" {
+			return fmt.Errorf("chain %d with the v0.7 contract %s has no SIMULATIONS_V07 (simulationsV07)", i, chain.ContractV07)
+		}
+	}
+	return nil
+}
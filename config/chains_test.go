@@ -0,0 +1,87 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadChainsMinFeeMultiplier(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "chains.json")
+	data := `[
+		{"rpc": ["http://localhost:8545"], "contract": "0x1", "minFeeMultiplier": 0},
+		{"rpc": ["http://localhost:8546"], "contract": "0x2", "minFeeMultiplier": 0.5},
+		{"rpc": ["http://localhost:8547"], "contract": "0x3"}
+	]`
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	chains, err := loadChains(&Values{ChainsFile: file, MinFeeMultiplier: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []float64{0, 0.5, 1} {
+		if min := chains[i].MinFeeMultiplier; min == nil || *min != expected {
+			t.Errorf("chain %d min fee multiplier %v, expected %v", i, min, expected)
+		}
+	}
+
+	chains, err = loadChains(&Values{RPC: "http://localhost:8545", Contract: "0x1", MinFeeMultiplier: 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if min := chains[0].MinFeeMultiplier; min == nil || *min != 1.5 {
+		t.Errorf("min fee multiplier %v of the single chain, expected 1.5", min)
+	}
+}
+
+func TestLoadChainsPKCS11(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "chains.json")
+	data := `[
+		{"rpc": ["http://localhost:8545"], "contract": "0x1",
+		 "keys": [{"signer": "pkcs11", "pkcs11TokenLabel": "next", "pkcs11Pin": "5678", "pkcs11KeyLabel": "next"}]},
+		{"rpc": ["http://localhost:8546"], "contract": "0x2", "signer": "pkcs11", "pkcs11Module": "/opt/hsm.so",
+		 "pkcs11TokenLabel": "other", "pkcs11Pin": "0000", "pkcs11KeyLabel": "other"}
+	]`
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v := &Values{
+		ChainsFile:       file,
+		MinFeeMultiplier: 1,
+		Signer:           "pkcs11",
+		PKCS11Module:     "/usr/lib/softhsm/libsofthsm2.so",
+		PKCS11TokenLabel: "paymaster",
+		PKCS11PIN:        "1234",
+		PKCS11KeyLabel:   "verifying",
+	}
+	chains, err := loadChains(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Key{
+		{Signer: "pkcs11", PKCS11Module: v.PKCS11Module, PKCS11TokenLabel: "paymaster", PKCS11PIN: "1234", PKCS11KeyLabel: "verifying"},
+		{Signer: "pkcs11", PKCS11Module: v.PKCS11Module, PKCS11TokenLabel: "next", PKCS11PIN: "5678", PKCS11KeyLabel: "next"},
+		{Signer: "pkcs11", PKCS11Module: "/opt/hsm.so", PKCS11TokenLabel: "other", PKCS11PIN: "0000", PKCS11KeyLabel: "other"},
+	}
+	for i, key := range []Key{chains[0].Key, chains[0].Keys[0], chains[1].Key} {
+		if key != expected[i] {
+			t.Errorf("key %d is %+v, expected %+v", i, key, expected[i])
+		}
+	}
+}
+
+func TestCheckSimulations(t *testing.T) {
+	chains := []*Chain{
+		{Contract: "0x1"},
+		{Contract: "0x2", ContractV07: "0x3", SimulationsV07: "simulations.hex"},
+	}
+	if err := checkSimulations(chains); err != nil {
+		t.Fatal(err)
+	}
+	chains = append(chains, &Chain{Contract: "0x4", ContractV07: "0x5"})
+	if err := checkSimulations(chains); err == nil || !strings.Contains(err.Error(), "0x5") {
+		t.Fatalf("v0.7 contract without simulations returns %v", err)
+	}
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
@@ -24,12 +26,117 @@ type Values struct {
 	RPC        string
 	Contract   string
 	MaxGas     string
+
+	// Signer selects the backend of the verifying signer key, keystore, remote or pkcs11.
+	Signer        string
+	SignerURL     string
+	SignerMethod  string
+	SignerAddress string
+
+	PKCS11Module     string
+	PKCS11TokenLabel string
+	PKCS11PIN        string
+	PKCS11KeyLabel   string
+
+	EntryPointV07  string
+	ContractV07    string
+	SimulationsV07 string
+	// EntryPoints restricts the entry points accepted by pm_sponsorUserOperation, empty accepts
+	// the entry points of all configured paymasters.
+	EntryPoints []string
+
+	PolicyReloadInterval int
+	HashCheckInterval    int
+	SettlementInterval   int
+	// DepositCheckInterval is how often the paymaster deposits are polled, in seconds.
+	DepositCheckInterval int
+	// SignerCheckInterval is how often the verifying signers of the paymasters are polled, in seconds.
+	SignerCheckInterval int
+
+	QuotaWindow    string
+	QuotaResetTime string
+
+	// validity of sponsorships in seconds
+	ValidFor      int
+	ValidForMin   int
+	ValidForMax   int
+	ValidAfterMax int
+
+	AdminToken string
+
+	// MaxFeeMultiplier caps the fees of sponsored operations to a multiple of the market fees,
+	// MinFeeMultiplier rejects max fees below a multiple of the base fee. FeeCapMode is reject or clamp.
+	MaxFeeMultiplier float64
+	MinFeeMultiplier float64
+	FeeCapMode       string
+	// GasPriceInterval is how often the fee history of the last GasPriceBlocks blocks is sampled, in seconds.
+	GasPriceInterval int
+	GasPriceBlocks   uint64
+	// PVGStrategy calculates the preVerificationGas, evm or the L1 data fee of optimism or arbitrum.
+	PVGStrategy string
+	// PaymasterVerificationGas and PaymasterPostOpGas are the v0.7 paymaster gas limits of sponsored operations.
+	PaymasterVerificationGas uint64
+	PaymasterPostOpGas       uint64
+
+	// OwnerKeystore is the key of the paymaster owner signing the treasury commands.
+	OwnerKeystore   string
+	OwnerPassphrase string
+	// FundingKeystore is the wallet depositing when the deposit of a paymaster drops below TopUpThreshold.
+	FundingKeystore   string
+	FundingPassphrase string
+	TopUpThreshold    string
+	TopUpAmount       string
+	// Metrics serves the metrics in the Prometheus format at /metrics.
+	Metrics bool
+
+	AuthMode       string
+	AuthKeysFile   string
+	AuthHMACWindow int
+	AuthPathKey    bool
+	// SigningSecretKey is the hex encoded AES-256 key encrypting the signing secrets of api keys at rest.
+	SigningSecretKey string
+
+	TrustedProxies      []string
+	RateLimitStore      string
+	RateLimitKeySponsor string
+	RateLimitKeyRead    string
+	RateLimitIPSponsor  string
+	RateLimitIPRead     string
+
+	// ChainsFile lists the chains served, the first one is the default chain.
+	ChainsFile string
+	Chains     []*Chain
 }
 
 func InitValues() error {
 	viper.SetDefault("port", 8888)
 	viper.SetDefault("gin_mode", gin.ReleaseMode)
 	viper.SetDefault("MAX_GAS", "10000000000000000000")
+	viper.SetDefault("POLICY_RELOAD_INTERVAL", 60)
+	viper.SetDefault("SETTLEMENT_INTERVAL", 15)
+	viper.SetDefault("SIGNER_CHECK_INTERVAL", 60)
+	viper.SetDefault("DEPOSIT_CHECK_INTERVAL", 60)
+	viper.SetDefault("QUOTA_WINDOW", "fixed")
+	viper.SetDefault("QUOTA_RESET_TIME", "00:00")
+	viper.SetDefault("VALID_FOR", 86400)
+	viper.SetDefault("VALID_FOR_MIN", 60)
+	viper.SetDefault("VALID_FOR_MAX", 86400)
+	viper.SetDefault("VALID_AFTER_MAX", 86400)
+	viper.SetDefault("MAX_FEE_MULTIPLIER", 3)
+	viper.SetDefault("MIN_FEE_MULTIPLIER", 1)
+	viper.SetDefault("FEE_CAP_MODE", "reject")
+	viper.SetDefault("GAS_PRICE_INTERVAL", 12)
+	viper.SetDefault("GAS_PRICE_BLOCKS", 20)
+	viper.SetDefault("PVG_STRATEGY", "evm")
+	viper.SetDefault("PAYMASTER_VERIFICATION_GAS", 100000)
+	viper.SetDefault("PAYMASTER_POST_OP_GAS", 0)
+	viper.SetDefault("AUTH_MODE", "db")
+	viper.SetDefault("AUTH_HMAC_WINDOW", 300)
+	viper.SetDefault("AUTH_PATH_KEY", true)
+	viper.SetDefault("RATE_LIMIT_STORE", "memory")
+	viper.SetDefault("SIGNER", "keystore")
+	viper.SetDefault("SIGNER_METHOD", "eth_sign")
+	viper.SetDefault("ENTRY_POINT_V07", "0x0000000071727De22E5E9d8BAf0edAc6f37da032")
 
 	viper.SetConfigName(".env")
 	viper.SetConfigType("env")
@@ -52,6 +159,57 @@ func InitValues() error {
 	_ = viper.BindEnv("RPC")
 	_ = viper.BindEnv("CONTRACT")
 	_ = viper.BindEnv("MAX_GAS")
+	_ = viper.BindEnv("POLICY_RELOAD_INTERVAL")
+	_ = viper.BindEnv("HASH_CHECK_INTERVAL")
+	_ = viper.BindEnv("SETTLEMENT_INTERVAL")
+	_ = viper.BindEnv("QUOTA_WINDOW")
+	_ = viper.BindEnv("QUOTA_RESET_TIME")
+	_ = viper.BindEnv("ENTRY_POINT_V07")
+	_ = viper.BindEnv("CONTRACT_V07")
+	_ = viper.BindEnv("SIMULATIONS_V07")
+	_ = viper.BindEnv("ADMIN_TOKEN")
+	_ = viper.BindEnv("AUTH_MODE")
+	_ = viper.BindEnv("AUTH_KEYS_FILE")
+	_ = viper.BindEnv("AUTH_HMAC_WINDOW")
+	_ = viper.BindEnv("AUTH_PATH_KEY")
+	_ = viper.BindEnv("SIGNING_SECRET_KEY")
+	_ = viper.BindEnv("TRUSTED_PROXIES")
+	_ = viper.BindEnv("RATE_LIMIT_STORE")
+	_ = viper.BindEnv("RATE_LIMIT_KEY_SPONSOR")
+	_ = viper.BindEnv("RATE_LIMIT_KEY_READ")
+	_ = viper.BindEnv("RATE_LIMIT_IP_SPONSOR")
+	_ = viper.BindEnv("RATE_LIMIT_IP_READ")
+	_ = viper.BindEnv("CHAINS_FILE")
+	_ = viper.BindEnv("ENTRY_POINTS")
+	_ = viper.BindEnv("SIGNER")
+	_ = viper.BindEnv("SIGNER_URL")
+	_ = viper.BindEnv("SIGNER_METHOD")
+	_ = viper.BindEnv("SIGNER_ADDRESS")
+	_ = viper.BindEnv("PKCS11_MODULE")
+	_ = viper.BindEnv("PKCS11_TOKEN_LABEL")
+	_ = viper.BindEnv("PKCS11_PIN")
+	_ = viper.BindEnv("PKCS11_KEY_LABEL")
+	_ = viper.BindEnv("SIGNER_CHECK_INTERVAL")
+	_ = viper.BindEnv("DEPOSIT_CHECK_INTERVAL")
+	_ = viper.BindEnv("METRICS")
+	_ = viper.BindEnv("OWNER_KEYSTORE")
+	_ = viper.BindEnv("OWNER_PASSPHRASE")
+	_ = viper.BindEnv("FUNDING_KEYSTORE")
+	_ = viper.BindEnv("FUNDING_PASSPHRASE")
+	_ = viper.BindEnv("TOPUP_THRESHOLD")
+	_ = viper.BindEnv("TOPUP_AMOUNT")
+	_ = viper.BindEnv("VALID_FOR")
+	_ = viper.BindEnv("VALID_FOR_MIN")
+	_ = viper.BindEnv("VALID_FOR_MAX")
+	_ = viper.BindEnv("VALID_AFTER_MAX")
+	_ = viper.BindEnv("MAX_FEE_MULTIPLIER")
+	_ = viper.BindEnv("MIN_FEE_MULTIPLIER")
+	_ = viper.BindEnv("FEE_CAP_MODE")
+	_ = viper.BindEnv("GAS_PRICE_INTERVAL")
+	_ = viper.BindEnv("GAS_PRICE_BLOCKS")
+	_ = viper.BindEnv("PVG_STRATEGY")
+	_ = viper.BindEnv("PAYMASTER_VERIFICATION_GAS")
+	_ = viper.BindEnv("PAYMASTER_POST_OP_GAS")
 
 	values = &Values{
 		DbHost:     viper.GetString("DB_HOST"),
@@ -66,7 +224,84 @@ func InitValues() error {
 		RPC:        viper.GetString("RPC"),
 		Contract:   viper.GetString("CONTRACT"),
 		MaxGas:     viper.GetString("MAX_GAS"),
+
+		Signer:        viper.GetString("SIGNER"),
+		SignerURL:     viper.GetString("SIGNER_URL"),
+		SignerMethod:  viper.GetString("SIGNER_METHOD"),
+		SignerAddress: viper.GetString("SIGNER_ADDRESS"),
+
+		PKCS11Module:     viper.GetString("PKCS11_MODULE"),
+		PKCS11TokenLabel: viper.GetString("PKCS11_TOKEN_LABEL"),
+		PKCS11PIN:        viper.GetString("PKCS11_PIN"),
+		PKCS11KeyLabel:   viper.GetString("PKCS11_KEY_LABEL"),
+
+		EntryPointV07:  viper.GetString("ENTRY_POINT_V07"),
+		ContractV07:    viper.GetString("CONTRACT_V07"),
+		SimulationsV07: viper.GetString("SIMULATIONS_V07"),
+		EntryPoints:    splitList(viper.GetString("ENTRY_POINTS")),
+
+		PolicyReloadInterval: viper.GetInt("POLICY_RELOAD_INTERVAL"),
+		HashCheckInterval:    viper.GetInt("HASH_CHECK_INTERVAL"),
+		SettlementInterval:   viper.GetInt("SETTLEMENT_INTERVAL"),
+		SignerCheckInterval:  viper.GetInt("SIGNER_CHECK_INTERVAL"),
+		DepositCheckInterval: viper.GetInt("DEPOSIT_CHECK_INTERVAL"),
+
+		QuotaWindow:    viper.GetString("QUOTA_WINDOW"),
+		QuotaResetTime: viper.GetString("QUOTA_RESET_TIME"),
+
+		ValidFor:      viper.GetInt("VALID_FOR"),
+		ValidForMin:   viper.GetInt("VALID_FOR_MIN"),
+		ValidForMax:   viper.GetInt("VALID_FOR_MAX"),
+		ValidAfterMax: viper.GetInt("VALID_AFTER_MAX"),
+
+		MaxFeeMultiplier: viper.GetFloat64("MAX_FEE_MULTIPLIER"),
+		MinFeeMultiplier: viper.GetFloat64("MIN_FEE_MULTIPLIER"),
+		FeeCapMode:       viper.GetString("FEE_CAP_MODE"),
+		GasPriceInterval: viper.GetInt("GAS_PRICE_INTERVAL"),
+		GasPriceBlocks:   viper.GetUint64("GAS_PRICE_BLOCKS"),
+		PVGStrategy:      viper.GetString("PVG_STRATEGY"),
+
+		PaymasterVerificationGas: viper.GetUint64("PAYMASTER_VERIFICATION_GAS"),
+		PaymasterPostOpGas:       viper.GetUint64("PAYMASTER_POST_OP_GAS"),
+
+		AdminToken: viper.GetString("ADMIN_TOKEN"),
+		Metrics:    viper.GetBool("METRICS"),
+
+		OwnerKeystore:     viper.GetString("OWNER_KEYSTORE"),
+		OwnerPassphrase:   viper.GetString("OWNER_PASSPHRASE"),
+		FundingKeystore:   viper.GetString("FUNDING_KEYSTORE"),
+		FundingPassphrase: viper.GetString("FUNDING_PASSPHRASE"),
+		TopUpThreshold:    viper.GetString("TOPUP_THRESHOLD"),
+		TopUpAmount:       viper.GetString("TOPUP_AMOUNT"),
+
+		AuthMode:       viper.GetString("AUTH_MODE"),
+		AuthKeysFile:   viper.GetString("AUTH_KEYS_FILE"),
+		AuthHMACWindow: viper.GetInt("AUTH_HMAC_WINDOW"),
+		AuthPathKey:    viper.GetBool("AUTH_PATH_KEY"),
+
+		SigningSecretKey: viper.GetString("SIGNING_SECRET_KEY"),
+
+		TrustedProxies:      splitList(viper.GetString("TRUSTED_PROXIES")),
+		RateLimitStore:      viper.GetString("RATE_LIMIT_STORE"),
+		RateLimitKeySponsor: viper.GetString("RATE_LIMIT_KEY_SPONSOR"),
+		RateLimitKeyRead:    viper.GetString("RATE_LIMIT_KEY_READ"),
+		RateLimitIPSponsor:  viper.GetString("RATE_LIMIT_IP_SPONSOR"),
+		RateLimitIPRead:     viper.GetString("RATE_LIMIT_IP_READ"),
+
+		ChainsFile: viper.GetString("CHAINS_FILE"),
+	}
+
+	if err := checkIntervals(values); err != nil {
+		return err
+	}
+	chains, err := loadChains(values)
+	if err != nil {
+		return err
+	}
+	if err := checkSimulations(chains); err != nil {
+		return err
 	}
+	values.Chains = chains
 	return nil
 }
 
@@ -76,3 +311,32 @@ func Config() *Values {
 	}
 	return values
 }
+
+// checkIntervals requires the intervals of the background loops to be positive, their tickers panic otherwise.
+func checkIntervals(v *Values) error {
+	for _, interval := range []struct {
+		name  string
+		value int
+	}{
+		{"POLICY_RELOAD_INTERVAL", v.PolicyReloadInterval},
+		{"SETTLEMENT_INTERVAL", v.SettlementInterval},
+		{"DEPOSIT_CHECK_INTERVAL", v.DepositCheckInterval},
+		{"GAS_PRICE_INTERVAL", v.GasPriceInterval},
+	} {
+		if interval.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %d", interval.name, interval.value)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma separated value, empty for an empty value.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
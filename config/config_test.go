@@ -0,0 +1,25 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckIntervals(t *testing.T) {
+	valid := Values{PolicyReloadInterval: 60, SettlementInterval: 15, DepositCheckInterval: 60, GasPriceInterval: 12}
+	if err := checkIntervals(&valid); err != nil {
+		t.Fatal(err)
+	}
+	for name, update := range map[string]func(v *Values){
+		"POLICY_RELOAD_INTERVAL": func(v *Values) { v.PolicyReloadInterval = 0 },
+		"SETTLEMENT_INTERVAL":    func(v *Values) { v.SettlementInterval = -1 },
+		"DEPOSIT_CHECK_INTERVAL": func(v *Values) { v.DepositCheckInterval = 0 },
+		"GAS_PRICE_INTERVAL":     func(v *Values) { v.GasPriceInterval = 0 },
+	} {
+		v := valid
+		update(&v)
+		if err := checkIntervals(&v); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("invalid %s returns %v", name, err)
+		}
+	}
+}
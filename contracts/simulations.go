@@ -0,0 +1,46 @@
+package contracts
+
+// EntryPointSimulationsABI is the part of the EntryPoint v0.7 EntryPointSimulations ABI used for estimation.
+// The contract is not deployed, its code replaces the entry point code in a state override eth_call.
+const EntryPointSimulationsABI = `[
+	{
+		"type": "function",
+		"name": "simulateHandleOp",
+		"stateMutability": "nonpayable",
+		"inputs": [
+			{
+				"name": "op",
+				"type": "tuple",
+				"internalType": "struct PackedUserOperation",
+				"components": [
+					{"name": "sender", "type": "address", "internalType": "address"},
+					{"name": "nonce", "type": "uint256", "internalType": "uint256"},
+					{"name": "initCode", "type": "bytes", "internalType": "bytes"},
+					{"name": "callData", "type": "bytes", "internalType": "bytes"},
+					{"name": "accountGasLimits", "type": "bytes32", "internalType": "bytes32"},
+					{"name": "preVerificationGas", "type": "uint256", "internalType": "uint256"},
+					{"name": "gasFees", "type": "bytes32", "internalType": "bytes32"},
+					{"name": "paymasterAndData", "type": "bytes", "internalType": "bytes"},
+					{"name": "signature", "type": "bytes", "internalType": "bytes"}
+				]
+			},
+			{"name": "target", "type": "address", "internalType": "address"},
+			{"name": "targetCallData", "type": "bytes", "internalType": "bytes"}
+		],
+		"outputs": [
+			{
+				"name": "",
+				"type": "tuple",
+				"internalType": "struct IEntryPointSimulations.ExecutionResult",
+				"components": [
+					{"name": "preOpGas", "type": "uint256", "internalType": "uint256"},
+					{"name": "paid", "type": "uint256", "internalType": "uint256"},
+					{"name": "accountValidationData", "type": "uint256", "internalType": "uint256"},
+					{"name": "paymasterValidationData", "type": "uint256", "internalType": "uint256"},
+					{"name": "targetSuccess", "type": "bool", "internalType": "bool"},
+					{"name": "targetResult", "type": "bytes", "internalType": "bytes"}
+				]
+			}
+		]
+	}
+]`
@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: GPL-3.0
+pragma solidity ^0.8.12;
+
+// The owner, deposit, stake and getHash functions of the eth-infinitism VerifyingPaymaster
+// samples of EntryPoint v0.6 and v0.7, without the validation the tests do not use.
+// The .bin files are compiled with solc 0.8.21, optimizer 200 runs and evm version paris.
+// EntryPointV06.bin is the bytecode of the EntryPoint v0.6.0 release.
+
+struct UserOperation {
+    address sender;
+    uint256 nonce;
+    bytes initCode;
+    bytes callData;
+    uint256 callGasLimit;
+    uint256 verificationGasLimit;
+    uint256 preVerificationGas;
+    uint256 maxFeePerGas;
+    uint256 maxPriorityFeePerGas;
+    bytes paymasterAndData;
+    bytes signature;
+}
+
+struct PackedUserOperation {
+    address sender;
+    uint256 nonce;
+    bytes initCode;
+    bytes callData;
+    bytes32 accountGasLimits;
+    uint256 preVerificationGas;
+    bytes32 gasFees;
+    bytes paymasterAndData;
+    bytes signature;
+}
+
+interface IStakeManager {
+    function balanceOf(address account) external view returns (uint256);
+    function depositTo(address account) external payable;
+    function addStake(uint32 unstakeDelaySec) external payable;
+    function unlockStake() external;
+    function withdrawStake(address payable withdrawAddress) external;
+    function withdrawTo(address payable withdrawAddress, uint256 withdrawAmount) external;
+}
+
+abstract contract BasePaymaster {
+    IStakeManager public immutable entryPoint;
+    address public owner;
+
+    constructor(IStakeManager _entryPoint) {
+        entryPoint = _entryPoint;
+        owner = msg.sender;
+    }
+
+    modifier onlyOwner() {
+        require(owner == msg.sender, "Ownable: caller is not the owner");
+        _;
+    }
+
+    function transferOwnership(address newOwner) public onlyOwner {
+        owner = newOwner;
+    }
+
+    function deposit() public payable {
+        entryPoint.depositTo{value: msg.value}(address(this));
+    }
+
+    function withdrawTo(address payable withdrawAddress, uint256 amount) public onlyOwner {
+        entryPoint.withdrawTo(withdrawAddress, amount);
+    }
+
+    function addStake(uint32 unstakeDelaySec) external payable onlyOwner {
+        entryPoint.addStake{value: msg.value}(unstakeDelaySec);
+    }
+
+    function getDeposit() public view returns (uint256) {
+        return entryPoint.balanceOf(address(this));
+    }
+
+    function unlockStake() external onlyOwner {
+        entryPoint.unlockStake();
+    }
+
+    function withdrawStake(address payable withdrawAddress) external onlyOwner {
+        entryPoint.withdrawStake(withdrawAddress);
+    }
+}
+
+contract VerifyingPaymaster is BasePaymaster {
+    address public immutable verifyingSigner;
+
+    mapping(address => uint256) public senderNonce;
+
+    constructor(IStakeManager _entryPoint, address _verifyingSigner) BasePaymaster(_entryPoint) {
+        verifyingSigner = _verifyingSigner;
+    }
+
+    // setSenderNonce stands for the nonce increments of validatePaymasterUserOp.
+    function setSenderNonce(address sender, uint256 nonce) external {
+        senderNonce[sender] = nonce;
+    }
+
+    function getHash(UserOperation calldata userOp, uint48 validUntil, uint48 validAfter)
+    public view returns (bytes32) {
+        address sender = userOp.sender;
+        // the encoding of static values is the concatenation of their words, split against stack too deep
+        return keccak256(bytes.concat(
+            abi.encode(
+                sender,
+                userOp.nonce,
+                keccak256(userOp.initCode),
+                keccak256(userOp.callData),
+                userOp.callGasLimit,
+                userOp.verificationGasLimit,
+                userOp.preVerificationGas
+            ),
+            abi.encode(
+                userOp.maxFeePerGas,
+                userOp.maxPriorityFeePerGas,
+                block.chainid,
+                address(this),
+                senderNonce[sender],
+                validUntil,
+                validAfter
+            )
+        ));
+    }
+}
+
+contract VerifyingPaymasterV07 is BasePaymaster {
+    uint256 private constant PAYMASTER_VALIDATION_GAS_OFFSET = 20;
+    uint256 private constant PAYMASTER_DATA_OFFSET = 52;
+
+    address public immutable verifyingSigner;
+
+    constructor(IStakeManager _entryPoint, address _verifyingSigner) BasePaymaster(_entryPoint) {
+        verifyingSigner = _verifyingSigner;
+    }
+
+    function getHash(PackedUserOperation calldata userOp, uint48 validUntil, uint48 validAfter)
+    public view returns (bytes32) {
+        address sender = userOp.sender;
+        return keccak256(bytes.concat(
+            abi.encode(
+                sender,
+                userOp.nonce,
+                keccak256(userOp.initCode),
+                keccak256(userOp.callData),
+                userOp.accountGasLimits
+            ),
+            abi.encode(
+                uint256(bytes32(userOp.paymasterAndData[PAYMASTER_VALIDATION_GAS_OFFSET : PAYMASTER_DATA_OFFSET])),
+                userOp.preVerificationGas,
+                userOp.gasFees,
+                block.chainid,
+                address(this),
+                validUntil,
+                validAfter
+            )
+        ));
+    }
+}
@@ -7,6 +7,7 @@ import (
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/ququzone/verifying-paymaster-service/config"
 	"github.com/ququzone/verifying-paymaster-service/logger"
@@ -27,6 +28,7 @@ type Repository interface {
 	Where(query interface{}, args ...interface{}) *gorm.DB
 	Preload(column string, conditions ...interface{}) *gorm.DB
 	Scopes(funcs ...func(*gorm.DB) *gorm.DB) *gorm.DB
+	Clauses(conds ...clause.Expression) *gorm.DB
 	ScanRows(rows *sql.Rows, result interface{}) error
 	Transaction(fc func(tx Repository) error) (err error)
 	Close() error
@@ -40,25 +42,29 @@ type repository struct {
 
 func NewRepository() Repository {
 	logger.S().Infof("Try database connection...")
-	db, err := connectDatabase()
+	rep, err := Open(fmt.Sprintf(
+		"host=%s port=%d user=%s dbname=%s password=%s sslmode=disable",
+		config.Config().DbHost,
+		config.Config().DbPort,
+		config.Config().DbUser,
+		config.Config().DbName,
+		config.Config().DbPassword,
+	))
 	if err != nil {
 		logger.S().Errorf("Failure database connection")
 		os.Exit(1)
 	}
 	logger.S().Infof("Success database connection, %s:%d", config.Config().DbHost, config.Config().DbPort)
-	return &repository{db: db}
+	return rep
 }
 
-func connectDatabase() (*gorm.DB, error) {
-	dsn := fmt.Sprintf(
-		"host=%s port=%d user=%s dbname=%s password=%s sslmode=disable",
-		config.Config().DbHost,
-		config.Config().DbPort,
-		config.Config().DbUser,
-		config.Config().DbName,
-		config.Config().DbPassword,
-	)
-	return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// Open connects to the postgres database of dsn.
+func Open(dsn string) (Repository, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return &repository{db: db}, nil
 }
 
 // Model specify the model you would like to run db operations
@@ -126,6 +132,11 @@ func (rep *repository) Scopes(funcs ...func(*gorm.DB) *gorm.DB) *gorm.DB {
 	return rep.db.Scopes(funcs...)
 }
 
+// Clauses add clauses, e.g. row locking or conflict handling
+func (rep *repository) Clauses(conds ...clause.Expression) *gorm.DB {
+	return rep.db.Clauses(conds...)
+}
+
 // ScanRows scan `*sql.Rows` to give struct
 func (rep *repository) ScanRows(rows *sql.Rows, result interface{}) error {
 	return rep.db.ScanRows(rows, result)
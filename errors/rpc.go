@@ -1,7 +1,11 @@
 package errors
 
 var (
-	REJECTED_BY_TYPE = -32500
+	INVALID_FIELDS        = -32602
+	REJECTED_BY_TYPE      = -32500
+	REJECTED_BY_PAYMASTER = -32501
+	UNAUTHORIZED          = -32001
+	LIMIT_EXCEEDED        = -32005
 )
 
 type RPCError struct {
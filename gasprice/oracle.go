@@ -0,0 +1,160 @@
+package gasprice
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+// Backend is the chain access of the oracle, an ethclient.Client.
+type Backend interface {
+	FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+}
+
+// level is a suggestion speed, its priority fee is the percentile of the tips paid in the sampled
+// blocks and its max fee covers baseFeePercent of the next base fee on top of it.
+type level struct {
+	percentile     float64
+	baseFeePercent int64
+}
+
+var (
+	slow     = level{percentile: 10, baseFeePercent: 110}
+	standard = level{percentile: 50, baseFeePercent: 125}
+	fast     = level{percentile: 90, baseFeePercent: 200}
+
+	percentiles = []float64{slow.percentile, standard.percentile, fast.percentile}
+)
+
+// Fees are the fees of a user operation.
+type Fees struct {
+	MaxFeePerGas         *big.Int
+	MaxPriorityFeePerGas *big.Int
+}
+
+// Suggestions are the fees suggested for the slow, standard and fast inclusion of a user operation.
+type Suggestions struct {
+	Slow     Fees
+	Standard Fees
+	Fast     Fees
+	// BaseFee is the base fee of the next block.
+	BaseFee   *big.Int
+	UpdatedAt time.Time
+}
+
+// Oracle samples the fee history of the chain and caches the fees suggested by it.
+type Oracle struct {
+	backend Backend
+	blocks  uint64
+	maxAge  time.Duration
+
+	mu          sync.RWMutex
+	suggestions *Suggestions
+}
+
+// NewOracle creates an oracle sampling the last blocks, its suggestions expire after maxAge without sample.
+func NewOracle(backend Backend, blocks uint64, maxAge time.Duration) *Oracle {
+	return &Oracle{
+		backend: backend,
+		blocks:  blocks,
+		maxAge:  maxAge,
+	}
+}
+
+// Update samples the fee history once. Chains without fee history suggest the legacy gas price for all levels.
+func (o *Oracle) Update(ctx context.Context) error {
+	history, err := o.backend.FeeHistory(ctx, o.blocks, nil, percentiles)
+	if err != nil || len(history.BaseFee) == 0 || history.BaseFee[len(history.BaseFee)-1].Sign() == 0 {
+		if err != nil {
+			logger.S().Debugf("query fee history error, fall back to gas price: %v", err)
+		}
+		gasPrice, err := o.backend.SuggestGasPrice(ctx)
+		if err != nil {
+			return err
+		}
+		legacy := Fees{MaxFeePerGas: gasPrice, MaxPriorityFeePerGas: gasPrice}
+		o.set(&Suggestions{Slow: legacy, Standard: legacy, Fast: legacy, BaseFee: new(big.Int), UpdatedAt: time.Now()})
+		return nil
+	}
+
+	// the last base fee is the one of the next block
+	baseFee := history.BaseFee[len(history.BaseFee)-1]
+	tips := make([][]*big.Int, len(percentiles))
+	for i, rewards := range history.Reward {
+		// empty blocks report zero tips
+		if i < len(history.GasUsedRatio) && history.GasUsedRatio[i] == 0 {
+			continue
+		}
+		for j := range percentiles {
+			if j < len(rewards) {
+				tips[j] = append(tips[j], rewards[j])
+			}
+		}
+	}
+	o.set(&Suggestions{
+		Slow:      fees(baseFee, median(tips[0]), slow),
+		Standard:  fees(baseFee, median(tips[1]), standard),
+		Fast:      fees(baseFee, median(tips[2]), fast),
+		BaseFee:   baseFee,
+		UpdatedAt: time.Now(),
+	})
+	return nil
+}
+
+// Run samples the fee history every interval.
+func (o *Oracle) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := o.Update(ctx); err != nil {
+			logger.S().Errorf("update gas price error: %v", err)
+		}
+		cancel()
+	}
+}
+
+// Suggestions returns the last sampled suggestions, an error if there is none younger than maxAge.
+func (o *Oracle) Suggestions() (*Suggestions, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if o.suggestions == nil || time.Since(o.suggestions.UpdatedAt) > o.maxAge {
+		return nil, errors.New("gas price is not available")
+	}
+	return o.suggestions, nil
+}
+
+func (o *Oracle) set(suggestions *Suggestions) {
+	o.mu.Lock()
+	o.suggestions = suggestions
+	o.mu.Unlock()
+}
+
+func fees(baseFee *big.Int, tip *big.Int, l level) Fees {
+	maxFee := new(big.Int).Mul(baseFee, big.NewInt(l.baseFeePercent))
+	maxFee.Div(maxFee, big.NewInt(100))
+	return Fees{
+		MaxFeePerGas:         maxFee.Add(maxFee, tip),
+		MaxPriorityFeePerGas: tip,
+	}
+}
+
+func median(values []*big.Int) *big.Int {
+	if len(values) == 0 {
+		return new(big.Int)
+	}
+	sorted := make([]*big.Int, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Cmp(sorted[j]) < 0
+	})
+	return new(big.Int).Set(sorted[len(sorted)/2])
+}
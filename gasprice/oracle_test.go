@@ -0,0 +1,147 @@
+package gasprice
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger()
+	os.Exit(m.Run())
+}
+
+// cannedBackend returns a fixed fee history, or err, and the legacy gas price.
+type cannedBackend struct {
+	history  *ethereum.FeeHistory
+	err      error
+	gasPrice *big.Int
+}
+
+func (b *cannedBackend) FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error) {
+	return b.history, b.err
+}
+
+func (b *cannedBackend) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return b.gasPrice, nil
+}
+
+func bigs(values ...int64) []*big.Int {
+	result := make([]*big.Int, len(values))
+	for i, v := range values {
+		result[i] = big.NewInt(v)
+	}
+	return result
+}
+
+func checkFees(t *testing.T, name string, fees Fees, maxFee int64, tip int64) {
+	t.Helper()
+	if fees.MaxFeePerGas.Int64() != maxFee || fees.MaxPriorityFeePerGas.Int64() != tip {
+		t.Errorf("%s fees %s/%s, expected %d/%d", name, fees.MaxFeePerGas, fees.MaxPriorityFeePerGas, maxFee, tip)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	backend := &cannedBackend{history: &ethereum.FeeHistory{
+		Reward: [][]*big.Int{
+			bigs(1, 5, 9),
+			bigs(3, 7, 20),
+			// empty blocks report zero tips
+			bigs(0, 0, 0),
+			bigs(2, 6, 10),
+		},
+		BaseFee:      bigs(100, 100, 100, 100, 1000),
+		GasUsedRatio: []float64{0.5, 0.9, 0, 0.3},
+	}}
+	oracle := NewOracle(backend, 4, time.Minute)
+	if err := oracle.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	suggestions, err := oracle.Suggestions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if suggestions.BaseFee.Int64() != 1000 {
+		t.Errorf("base fee %s, expected the next base fee 1000", suggestions.BaseFee)
+	}
+	checkFees(t, "slow", suggestions.Slow, 1100+2, 2)
+	checkFees(t, "standard", suggestions.Standard, 1250+6, 6)
+	checkFees(t, "fast", suggestions.Fast, 2000+10, 10)
+}
+
+func TestUpdateEmptyRewards(t *testing.T) {
+	for name, reward := range map[string][][]*big.Int{
+		"no reward":     nil,
+		"empty rewards": {{}, {}},
+		"short rewards": {bigs(4), bigs(8)},
+	} {
+		backend := &cannedBackend{history: &ethereum.FeeHistory{
+			Reward:       reward,
+			BaseFee:      bigs(100, 100, 200),
+			GasUsedRatio: []float64{0.5, 0.5},
+		}}
+		oracle := NewOracle(backend, 2, time.Minute)
+		if err := oracle.Update(context.Background()); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		suggestions, err := oracle.Suggestions()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		slowTip := int64(0)
+		if name == "short rewards" {
+			slowTip = 8
+		}
+		checkFees(t, name+" slow", suggestions.Slow, 220+slowTip, slowTip)
+		checkFees(t, name+" standard", suggestions.Standard, 250, 0)
+		checkFees(t, name+" fast", suggestions.Fast, 400, 0)
+	}
+}
+
+func TestUpdateLegacyFallback(t *testing.T) {
+	for name, backend := range map[string]*cannedBackend{
+		"error":         {err: errors.New("the method eth_feeHistory does not exist")},
+		"no base fee":   {history: &ethereum.FeeHistory{}},
+		"zero base fee": {history: &ethereum.FeeHistory{BaseFee: bigs(0, 0), GasUsedRatio: []float64{0.5}}},
+	} {
+		backend.gasPrice = big.NewInt(500)
+		oracle := NewOracle(backend, 1, time.Minute)
+		if err := oracle.Update(context.Background()); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		suggestions, err := oracle.Suggestions()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if suggestions.BaseFee.Sign() != 0 {
+			t.Errorf("%s: base fee %s, expected none", name, suggestions.BaseFee)
+		}
+		checkFees(t, name+" slow", suggestions.Slow, 500, 500)
+		checkFees(t, name+" standard", suggestions.Standard, 500, 500)
+		checkFees(t, name+" fast", suggestions.Fast, 500, 500)
+	}
+}
+
+func TestSuggestionsExpire(t *testing.T) {
+	oracle := NewOracle(&cannedBackend{history: &ethereum.FeeHistory{BaseFee: bigs(100, 100), GasUsedRatio: []float64{0.5}}}, 1, time.Minute)
+	if _, err := oracle.Suggestions(); err == nil {
+		t.Fatal("suggestions before the first sample")
+	}
+	if err := oracle.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oracle.Suggestions(); err != nil {
+		t.Fatal(err)
+	}
+	oracle.suggestions.UpdatedAt = time.Now().Add(-2 * time.Minute)
+	if _, err := oracle.Suggestions(); err == nil {
+		t.Fatal("suggestions older than max age")
+	}
+}
@@ -0,0 +1,33 @@
+package jsonrpc
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/auth"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+// Authenticate resolves the caller with authenticator and places the principal in the request context,
+// rejecting unauthenticated requests with a JSON-RPC error.
+func Authenticate(authenticator auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := authenticator.Authenticate(c)
+		switch {
+		case err == auth.ErrNoKey:
+			c.AbortWithStatusJSON(http.StatusOK, jsonrpcError(errors.UNAUTHORIZED, "Key error", "No key", nil))
+			return
+		case err == auth.ErrInvalidKey:
+			c.AbortWithStatusJSON(http.StatusOK, jsonrpcError(errors.UNAUTHORIZED, "Key error", "Apikey error", nil))
+			return
+		case err != nil:
+			logger.S().Errorf("authenticate error: %v", err)
+			c.AbortWithStatusJSON(http.StatusOK, jsonrpcError(internalError, "Internal error", "Authenticate error", nil))
+			return
+		}
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), principal))
+		c.Next()
+	}
+}
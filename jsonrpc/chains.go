@@ -0,0 +1,139 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+const (
+	chainProcessKey  = "jsonrpc.chainProcess"
+	chainRegistryKey = "jsonrpc.chainRegistry"
+)
+
+// Chains dispatches requests to the registry of the chain selected by the :chainId path param,
+// requests without it go to the default chain.
+type Chains struct {
+	processes    map[uint64]gin.HandlerFunc
+	registries   map[uint64]*Registry
+	defaultChain uint64
+	pathKey      bool
+}
+
+// NewChains creates the chains router, pathKey treats a /rpc/:chainId segment which is not a served
+// chain id as the api key of a request to the default chain.
+func NewChains(defaultChain uint64, pathKey bool) *Chains {
+	return &Chains{
+		processes:    make(map[uint64]gin.HandlerFunc),
+		registries:   make(map[uint64]*Registry),
+		defaultChain: defaultChain,
+		pathKey:      pathKey,
+	}
+}
+
+// Add serves the methods of registry on chainID.
+func (ch *Chains) Add(chainID uint64, registry *Registry) {
+	ch.processes[chainID] = Process(registry)
+	ch.registries[chainID] = registry
+}
+
+// Route selects the chain of the request, it must run before Authenticate since it
+// moves a legacy /rpc/:key path param to the key param.
+func (ch *Chains) Route() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chainID := ch.defaultChain
+		if segment := c.Param("chainId"); segment != "" {
+			id, err := strconv.ParseUint(segment, 10, 64)
+			_, served := ch.processes[id]
+			switch {
+			case err == nil && served:
+				chainID = id
+			case ch.pathKey && c.Param("key") == "":
+				c.Params = append(c.Params, gin.Param{Key: "key", Value: segment})
+			default:
+				c.AbortWithStatusJSON(http.StatusNotFound, jsonrpcError(invalidRequest, "Invalid Request", "Unknown chain", nil))
+				return
+			}
+		}
+		c.Set(chainProcessKey, ch.processes[chainID])
+		c.Set(chainRegistryKey, ch.registries[chainID])
+		c.Next()
+	}
+}
+
+// Process handles the request with the registry of the chain selected by Route.
+func (ch *Chains) Process() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.MustGet(chainProcessKey).(gin.HandlerFunc)(c)
+	}
+}
+
+// LimitIP rejects the requests of a client ip over the limit of a rate class of the called methods
+// before Authenticate, so unauthenticated clients don't reach the api key lookup. It must run after Route.
+func (ch *Chains) LimitIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		registry := c.MustGet(chainRegistryKey).(*Registry)
+		if registry.limiter == nil || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusOK, jsonrpcError(parseError, "Parse error", "Error while reading request body", nil))
+			return
+		}
+		// the body is read again by Authenticate and Process
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		checked := make(map[string]bool)
+		for _, name := range calledMethods(body) {
+			method := registry.Get(name)
+			if method == nil || method.RateClass == "" || checked[method.RateClass] {
+				continue
+			}
+			checked[method.RateClass] = true
+			if err := registry.limiter.CheckIP(c.Request.Context(), c.ClientIP(), method.RateClass); err != nil {
+				if rpcErr, ok := err.(rpcError); ok {
+					c.AbortWithStatusJSON(http.StatusOK, jsonrpcError(rpcErr.Code(), rpcErr.Error(), rpcErr.Data(), nil))
+					return
+				}
+				logger.S().Errorf("check client ip limit error: %v", err)
+				c.AbortWithStatusJSON(http.StatusOK, jsonrpcError(internalError, "Internal error", nil, nil))
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// calledMethods returns the methods of the request or batch body, invalid requests are left to Process.
+func calledMethods(body []byte) []string {
+	var call struct {
+		Method string `json:"method"`
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		if json.Unmarshal(body, &call) != nil {
+			return nil
+		}
+		return []string{call.Method}
+	}
+	var batch []json.RawMessage
+	if json.Unmarshal(body, &batch) != nil {
+		return nil
+	}
+	methods := make([]string, 0, len(batch))
+	for _, raw := range batch {
+		call.Method = ""
+		if json.Unmarshal(raw, &call) == nil {
+			methods = append(methods, call.Method)
+		}
+	}
+	return methods
+}
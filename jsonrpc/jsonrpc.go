@@ -1,25 +1,57 @@
 package jsonrpc
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
-	"reflect"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
 
-	"github.com/ququzone/verifying-paymaster-service/api"
+	"github.com/ququzone/verifying-paymaster-service/auth"
 	"github.com/ququzone/verifying-paymaster-service/errors"
 	"github.com/ququzone/verifying-paymaster-service/logger"
-	"github.com/ququzone/verifying-paymaster-service/models"
 )
 
-func jsonrpcError(c *gin.Context, code int, message string, data any, id *float64) {
-	c.JSON(http.StatusOK, map[string]interface{}{
-		"result":  nil,
+const (
+	parseError     = -32700
+	invalidRequest = -32600
+	methodNotFound = -32601
+	invalidParams  = -32602
+	internalError  = -32603
+)
+
+// rpcError is an error returned to the client with its code, message and data,
+// other errors are answered as internal errors.
+type rpcError interface {
+	error
+	Code() int
+	Data() any
+}
+
+type request struct {
+	method string
+	params json.RawMessage
+	// id is nil for notifications
+	id json.RawMessage
+}
+
+var nullID = json.RawMessage("null")
+
+type clientIPKey struct{}
+
+// ClientIP returns the ip of the client of the request served by Process.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+func jsonrpcError(code int, message string, data any, id json.RawMessage) map[string]interface{} {
+	if id == nil {
+		id = nullID
+	}
+	return map[string]interface{}{
 		"jsonrpc": "2.0",
 		"error": map[string]interface{}{
 			"code":    code,
@@ -27,317 +59,172 @@ func jsonrpcError(c *gin.Context, code int, message string, data any, id *float6
 			"data":    data,
 		},
 		"id": id,
-	})
+	}
+}
+
+func jsonrpcResult(result any, id json.RawMessage) map[string]interface{} {
+	return map[string]interface{}{
+		"jsonrpc": "2.0",
+		"result":  result,
+		"id":      id,
+	}
 }
 
-func Process(service interface{}) gin.HandlerFunc {
+// Process serves the methods of registry, the caller is the principal placed in the request context by Authenticate.
+func Process(registry *Registry) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if c.Request.Method != "POST" {
-			jsonrpcError(c, -32700, "Parse error", "POST method excepted", nil)
+			c.JSON(http.StatusOK, jsonrpcError(parseError, "Parse error", "POST method excepted", nil))
 			return
 		}
 
 		if nil == c.Request.Body {
-			jsonrpcError(c, -32700, "Parse error", "No POST data", nil)
+			c.JSON(http.StatusOK, jsonrpcError(parseError, "Parse error", "No POST data", nil))
 			return
 		}
 
-		key := c.Param("key")
-		if key == "" {
-			jsonrpcError(c, -32700, "Key error", "No key", nil)
-			return
-		}
-		apiKey := &models.ApiKeys{}
-		apiKey, err := apiKey.FindByKey(service.(*api.Signer).Container.GetRepository(), key)
-		if nil != err {
-			logger.S().Errorf("Query api error: %v", err)
-			jsonrpcError(c, -32700, "Database error", "Query apikey error", nil)
-			return
-		}
-		if apiKey == nil || !apiKey.Enable {
-			jsonrpcError(c, -32700, "Key error", "Apikey error", nil)
-			return
-		}
+		ctx := context.WithValue(c.Request.Context(), clientIPKey{}, c.ClientIP())
 
 		// reading POST data
 		body, err := io.ReadAll(c.Request.Body)
 		if nil != err {
-			jsonrpcError(c, -32700, "Parse error", "Error while reading request body", nil)
+			c.JSON(http.StatusOK, jsonrpcError(parseError, "Parse error", "Error while reading request body", nil))
 			return
 		}
-
-		// try to decode JSON
-		data := make(map[string]interface{})
-		err = json.Unmarshal(body, &data)
-		if nil != err {
-			jsonrpcError(c, -32700, "Parse error", "Error parsing json request", nil)
-			return
-		}
-
-		id, ok := data["id"].(float64)
-		if !ok {
-			jsonrpcError(c, -32600, "Invalid Request", "No or invalid 'id' in request", nil)
+		body = bytes.TrimSpace(body)
+
+		// a batch is answered with the array of the responses of its requests, except notifications
+		if len(body) > 0 && body[0] == '[' {
+			var batch []json.RawMessage
+			if err := json.Unmarshal(body, &batch); err != nil {
+				c.JSON(http.StatusOK, jsonrpcError(parseError, "Parse error", "Error parsing json request", nil))
+				return
+			}
+			if len(batch) == 0 {
+				c.JSON(http.StatusOK, jsonrpcError(invalidRequest, "Invalid Request", "Empty batch", nil))
+				return
+			}
+			responses := make([]map[string]interface{}, 0, len(batch))
+			for _, raw := range batch {
+				if res := handle(ctx, registry, raw); res != nil {
+					responses = append(responses, res)
+				}
+			}
+			if len(responses) == 0 {
+				c.Status(http.StatusNoContent)
+				return
+			}
+			c.JSON(http.StatusOK, responses)
 			return
 		}
 
-		if data["jsonrpc"] != "2.0" {
-			jsonrpcError(c, -32600, "Invalid Request", "Version of jsonrpc is not 2.0", &id)
+		if !json.Valid(body) {
+			c.JSON(http.StatusOK, jsonrpcError(parseError, "Parse error", "Error parsing json request", nil))
 			return
 		}
-
-		method, ok := data["method"].(string)
-		if !ok {
-			jsonrpcError(c, -32600, "Invalid Request", "No or invalid 'method' in request", &id)
+		res := handle(ctx, registry, body)
+		if res == nil {
+			c.Status(http.StatusNoContent)
 			return
 		}
+		c.JSON(http.StatusOK, res)
+	}
+}
 
-		params, ok := data["params"].([]interface{})
-		if !ok {
-			jsonrpcError(c, -32602, "Invalid params", "No or invalid 'params' in request", &id)
-			return
+// handle processes a single request, returning nil for notifications.
+func handle(ctx context.Context, registry *Registry, raw json.RawMessage) map[string]interface{} {
+	req, res := parseRequest(raw)
+	if res != nil {
+		return res
+	}
+	result, err := dispatch(ctx, registry, req)
+	rpcErr, isRPCErr := err.(rpcError)
+	if err != nil && !isRPCErr {
+		// the details of unexpected errors stay in the server log
+		logger.S().Errorf("jsonrpc method %s error: %v", req.method, err)
+	}
+	if req.id == nil {
+		return nil
+	}
+	if err != nil {
+		if isRPCErr {
+			return jsonrpcError(rpcErr.Code(), rpcErr.Error(), rpcErr.Data(), req.id)
 		}
+		return jsonrpcError(internalError, "Internal error", nil, req.id)
+	}
+	return jsonrpcResult(result, req.id)
+}
 
-		call := reflect.ValueOf(service).MethodByName(cases.Title(language.Und, cases.NoLower).String(method))
-		if !call.IsValid() {
-			jsonrpcError(c, -32601, "Method not found", "Method not found", &id)
-			return
-		}
+func parseRequest(raw json.RawMessage) (*request, map[string]interface{}) {
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, jsonrpcError(invalidRequest, "Invalid Request", "Request is not an object", nil)
+	}
 
-		// validating and converting params
-		if call.Type().NumIn() != len(params) {
-			jsonrpcError(c, -32602, "Invalid params", "Invalid number of params", &id)
-			return
+	req := &request{}
+	if id, ok := data["id"]; ok {
+		if !validID(id) {
+			return nil, jsonrpcError(invalidRequest, "Invalid Request", "Invalid 'id' in request", nil)
 		}
+		req.id = id
+	}
 
-		args := make([]reflect.Value, len(params))
-		for i, arg := range params {
-			switch call.Type().In(i).Kind() {
-			case reflect.Float32:
-				val, ok := arg.(float32)
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Float64:
-				val, ok := arg.(float64)
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Int:
-				val, ok := arg.(int)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = int(fval)
-					}
-				}
-
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Int8:
-				val, ok := arg.(int8)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = int8(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Int16:
-				val, ok := arg.(int16)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = int16(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Int32:
-				val, ok := arg.(int32)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = int32(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Int64:
-				val, ok := arg.(int64)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = int64(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Interface:
-				args[i] = reflect.ValueOf(arg)
-
-			case reflect.Map:
-				val, ok := arg.(map[string]any)
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Slice:
-				val, ok := arg.([]interface{})
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.String:
-				val, ok := arg.(string)
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Uint:
-				val, ok := arg.(uint)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = uint(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Uint8:
-				val, ok := arg.(uint8)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = uint8(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Uint16:
-				val, ok := arg.(uint16)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = uint16(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Uint32:
-				val, ok := arg.(uint32)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = uint32(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
-
-			case reflect.Uint64:
-				val, ok := arg.(uint64)
-				if !ok {
-					var fval float64
-					fval, ok = arg.(float64)
-					if ok {
-						val = uint64(fval)
-					}
-				}
-				if !ok {
-					jsonrpcError(c, -32602, "Invalid params", fmt.Sprintf("Param [%d] can't be converted to %v", i, call.Type().In(i).String()), &id)
-					return
-				}
-				args[i] = reflect.ValueOf(val)
+	var version string
+	if err := json.Unmarshal(data["jsonrpc"], &version); err != nil || version != "2.0" {
+		return nil, jsonrpcError(invalidRequest, "Invalid Request", "Version of jsonrpc is not 2.0", req.id)
+	}
 
-			default:
-				if !ok {
-					jsonrpcError(c, -32603, "Internal error", "Invalid method defination", &id)
-					return
-				}
-			}
+	if err := json.Unmarshal(data["method"], &req.method); err != nil || req.method == "" {
+		return nil, jsonrpcError(invalidRequest, "Invalid Request", "No or invalid 'method' in request", req.id)
+	}
 
+	if params, ok := data["params"]; ok {
+		params = bytes.TrimSpace(params)
+		if len(params) == 0 || (params[0] != '[' && params[0] != '{') {
+			return nil, jsonrpcError(invalidRequest, "Invalid Request", "Invalid 'params' in request", req.id)
 		}
+		req.params = params
+	}
+	return req, nil
+}
 
-		c.Set("json-rpc-request", data)
-		result := call.Call(args)
+// validID reports whether id is a string, a number or null.
+func validID(id json.RawMessage) bool {
+	var v interface{}
+	if err := json.Unmarshal(id, &v); err != nil {
+		return false
+	}
+	switch v.(type) {
+	case nil, string, float64:
+		return true
+	}
+	return false
+}
 
-		if err, ok := result[len(result)-1].Interface().(error); ok && err != nil {
-			rpcErr, ok := err.(*errors.RPCError)
+// dispatch calls the registered method of req, recovering from panics as internal errors.
+func dispatch(ctx context.Context, registry *Registry, req *request) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.S().Errorf("jsonrpc method %s panic: %v", req.method, r)
+			result, err = nil, errors.NewRPCError(internalError, "Internal error", nil)
+		}
+	}()
 
-			if ok {
-				jsonrpcError(c, rpcErr.Code(), rpcErr.Error(), rpcErr.Data(), &id)
-			} else {
-				jsonrpcError(c, -32601, err.Error(), err.Error(), &id)
-			}
-		} else if len(result) > 0 {
-			c.JSON(http.StatusOK, map[string]interface{}{
-				"result":  result[0].Interface(),
-				"jsonrpc": "2.0",
-				"id":      id,
-			})
-		} else {
-			c.JSON(http.StatusOK, map[string]interface{}{
-				"result":  nil,
-				"jsonrpc": "2.0",
-				"id":      id,
-			})
+	method := registry.Get(req.method)
+	if method == nil {
+		return nil, errors.NewRPCError(methodNotFound, "Method not found", "Method not found")
+	}
+	if err := method.allowed(auth.FromContext(ctx)); err != nil {
+		return nil, err
+	}
+	if registry.limiter != nil {
+		if err := registry.limiter.Allow(ctx, ClientIP(ctx), method.RateClass); err != nil {
+			return nil, err
 		}
 	}
+	params, err := method.decode(req.params)
+	if err != nil {
+		return nil, err
+	}
+	return method.call(ctx, params)
 }
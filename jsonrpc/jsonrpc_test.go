@@ -0,0 +1,283 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ququzone/verifying-paymaster-service/auth"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.InitLogger(); err != nil {
+		panic(err)
+	}
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+type echoParams struct {
+	Name  string `json:"name"`
+	Count int    `json:"count,omitempty"`
+}
+
+type limiter struct{}
+
+func (limiter) CheckIP(_ context.Context, ip string, class string) error {
+	if ip == "192.0.2.1" {
+		return errors.NewRPCError(errors.LIMIT_EXCEEDED, "Limit exceeded", class)
+	}
+	return nil
+}
+
+func (limiter) Allow(_ context.Context, _ string, class string) error {
+	if class == "limited" {
+		return errors.NewRPCError(errors.LIMIT_EXCEEDED, "Rate limit exceeded", nil)
+	}
+	return nil
+}
+
+type countingAuthenticator struct {
+	calls int
+}
+
+func (a *countingAuthenticator) Authenticate(_ *gin.Context) (*auth.Principal, error) {
+	a.calls++
+	return &auth.Principal{ApiKey: &models.ApiKeys{Scopes: models.ScopeRead}}, nil
+}
+
+// testServer serves a registry of test methods to a caller with the read scope.
+func testServer() http.Handler {
+	router := gin.New()
+	router.Any("/rpc", func(c *gin.Context) {
+		principal := &auth.Principal{ApiKey: &models.ApiKeys{Scopes: models.ScopeRead}}
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), principal))
+	}, Process(testRegistry()))
+	return router
+}
+
+func testRegistry() *Registry {
+	registry := NewRegistry()
+	registry.SetRateLimiter(limiter{})
+	Register(registry, "test_echo", MethodOptions{}, func(_ context.Context, p *echoParams) (*echoParams, error) {
+		return p, nil
+	})
+	Register(registry, "test_reject", MethodOptions{}, func(_ context.Context, _ *struct{}) (bool, error) {
+		return false, errors.NewRPCError(errors.REJECTED_BY_PAYMASTER, "Rejected", map[string]string{"reason": "test"})
+	})
+	Register(registry, "test_fail", MethodOptions{}, func(_ context.Context, _ *struct{}) (bool, error) {
+		return false, stderrors.New("dial tcp 10.0.0.1:5432: connection refused")
+	})
+	Register(registry, "test_panic", MethodOptions{}, func(_ context.Context, _ *struct{}) (bool, error) {
+		panic("boom")
+	})
+	Register(registry, "test_sponsor", MethodOptions{Scopes: []string{models.ScopeSponsor}}, func(_ context.Context, _ *struct{}) (bool, error) {
+		return true, nil
+	})
+	Register(registry, "test_limited", MethodOptions{RateClass: "limited"}, func(_ context.Context, _ *struct{}) (bool, error) {
+		return true, nil
+	})
+	return registry
+}
+
+func call(t *testing.T, method string, body string) (int, interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	testServer().ServeHTTP(rec, httptest.NewRequest(method, "/rpc", strings.NewReader(body)))
+	if rec.Body.Len() == 0 {
+		return rec.Code, nil
+	}
+	var res interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid response %s: %v", rec.Body, err)
+	}
+	return rec.Code, res
+}
+
+func decode(t *testing.T, data string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestResults(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+		want string
+	}{
+		{"positional params", `{"jsonrpc":"2.0","method":"test_echo","params":["a",2],"id":1}`,
+			`{"jsonrpc":"2.0","result":{"name":"a","count":2},"id":1}`},
+		{"named params", `{"jsonrpc":"2.0","method":"test_echo","params":{"count":2,"name":"a"},"id":1}`,
+			`{"jsonrpc":"2.0","result":{"name":"a","count":2},"id":1}`},
+		{"optional param omitted", `{"jsonrpc":"2.0","method":"test_echo","params":["a"],"id":1}`,
+			`{"jsonrpc":"2.0","result":{"name":"a"},"id":1}`},
+		{"string id", `{"jsonrpc":"2.0","method":"test_echo","params":["a"],"id":"abc"}`,
+			`{"jsonrpc":"2.0","result":{"name":"a"},"id":"abc"}`},
+		{"null id", `{"jsonrpc":"2.0","method":"test_echo","params":["a"],"id":null}`,
+			`{"jsonrpc":"2.0","result":{"name":"a"},"id":null}`},
+		{"fractional id", `{"jsonrpc":"2.0","method":"test_echo","params":["a"],"id":1.5}`,
+			`{"jsonrpc":"2.0","result":{"name":"a"},"id":1.5}`},
+		{"no params", `{"jsonrpc":"2.0","method":"test_sponsor","id":1}`,
+			`{"jsonrpc":"2.0","error":{"code":-32001,"message":"Unauthorized","data":"Api key has no sponsor scope"},"id":1}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, res := call(t, http.MethodPost, tc.body)
+			if status != http.StatusOK {
+				t.Fatalf("status %d", status)
+			}
+			if want := decode(t, tc.want); !reflect.DeepEqual(res, want) {
+				t.Fatalf("response %v, expected %v", res, want)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		method  string
+		body    string
+		code    float64
+		message string
+		id      interface{}
+	}{
+		{"get", http.MethodGet, ``, -32700, "Parse error", nil},
+		{"invalid json", http.MethodPost, `{"jsonrpc":"2.0",`, -32700, "Parse error", nil},
+		{"invalid batch json", http.MethodPost, `[{"jsonrpc":"2.0"},`, -32700, "Parse error", nil},
+		{"empty batch", http.MethodPost, `[]`, -32600, "Invalid Request", nil},
+		{"not an object", http.MethodPost, `"test_echo"`, -32600, "Invalid Request", nil},
+		{"object id", http.MethodPost, `{"jsonrpc":"2.0","method":"test_echo","params":["a"],"id":{}}`, -32600, "Invalid Request", nil},
+		{"wrong version", http.MethodPost, `{"jsonrpc":"1.0","method":"test_echo","params":["a"],"id":1}`, -32600, "Invalid Request", 1.0},
+		{"no method", http.MethodPost, `{"jsonrpc":"2.0","params":["a"],"id":1}`, -32600, "Invalid Request", 1.0},
+		{"scalar params", http.MethodPost, `{"jsonrpc":"2.0","method":"test_echo","params":"a","id":1}`, -32600, "Invalid Request", 1.0},
+		{"unknown method", http.MethodPost, `{"jsonrpc":"2.0","method":"test_unknown","id":1}`, -32601, "Method not found", 1.0},
+		{"missing param", http.MethodPost, `{"jsonrpc":"2.0","method":"test_echo","params":[],"id":1}`, -32602, "Invalid params", 1.0},
+		{"too many params", http.MethodPost, `{"jsonrpc":"2.0","method":"test_echo","params":["a",1,2],"id":1}`, -32602, "Invalid params", 1.0},
+		{"wrong param type", http.MethodPost, `{"jsonrpc":"2.0","method":"test_echo","params":[1],"id":1}`, -32602, "Invalid params", 1.0},
+		{"unknown named param", http.MethodPost, `{"jsonrpc":"2.0","method":"test_echo","params":{"name":"a","other":1},"id":1}`, -32602, "Invalid params", 1.0},
+		{"internal error", http.MethodPost, `{"jsonrpc":"2.0","method":"test_fail","id":1}`, -32603, "Internal error", 1.0},
+		{"panic", http.MethodPost, `{"jsonrpc":"2.0","method":"test_panic","id":1}`, -32603, "Internal error", 1.0},
+		{"unauthorized", http.MethodPost, `{"jsonrpc":"2.0","method":"test_sponsor","id":"x"}`, -32001, "Unauthorized", "x"},
+		{"rate limited", http.MethodPost, `{"jsonrpc":"2.0","method":"test_limited","id":1}`, -32005, "Rate limit exceeded", 1.0},
+		{"rejected", http.MethodPost, `{"jsonrpc":"2.0","method":"test_reject","id":1}`, -32501, "Rejected", 1.0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, res := call(t, tc.method, tc.body)
+			if status != http.StatusOK {
+				t.Fatalf("status %d", status)
+			}
+			obj, _ := res.(map[string]interface{})
+			rpcErr, _ := obj["error"].(map[string]interface{})
+			if obj["jsonrpc"] != "2.0" || rpcErr == nil {
+				t.Fatalf("response %v is not an error", res)
+			}
+			if rpcErr["code"] != tc.code || rpcErr["message"] != tc.message {
+				t.Fatalf("error %v, expected %v %s", rpcErr, tc.code, tc.message)
+			}
+			if !reflect.DeepEqual(obj["id"], tc.id) {
+				t.Fatalf("id %v, expected %v", obj["id"], tc.id)
+			}
+		})
+	}
+}
+
+func TestInternalErrorIsNotLeaked(t *testing.T) {
+	_, res := call(t, http.MethodPost, `{"jsonrpc":"2.0","method":"test_fail","id":1}`)
+	data, _ := json.Marshal(res)
+	if strings.Contains(string(data), "connection refused") {
+		t.Fatalf("response %s leaks the internal error", data)
+	}
+}
+
+func TestRejectionData(t *testing.T) {
+	_, res := call(t, http.MethodPost, `{"jsonrpc":"2.0","method":"test_reject","id":1}`)
+	want := decode(t, `{"jsonrpc":"2.0","error":{"code":-32501,"message":"Rejected","data":{"reason":"test"}},"id":1}`)
+	if !reflect.DeepEqual(res, want) {
+		t.Fatalf("response %v, expected %v", res, want)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","method":"test_echo","params":["a"]}`,
+		`{"jsonrpc":"2.0","method":"test_fail"}`,
+		`{"jsonrpc":"2.0","method":"test_unknown"}`,
+		`[{"jsonrpc":"2.0","method":"test_echo","params":["a"]},{"jsonrpc":"2.0","method":"test_reject"}]`,
+	} {
+		status, res := call(t, http.MethodPost, body)
+		if status != http.StatusNoContent || res != nil {
+			t.Errorf("notification %s answered with %d %v", body, status, res)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	status, res := call(t, http.MethodPost, `[
+		{"jsonrpc":"2.0","method":"test_echo","params":["a"],"id":1},
+		{"jsonrpc":"2.0","method":"test_echo","params":["b"]},
+		{"jsonrpc":"2.0","method":"test_unknown","id":"2"},
+		1,
+		{"jsonrpc":"2.0","method":"test_fail","id":3}
+	]`)
+	if status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	want := decode(t, `[
+		{"jsonrpc":"2.0","result":{"name":"a"},"id":1},
+		{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found","data":"Method not found"},"id":"2"},
+		{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request","data":"Request is not an object"},"id":null},
+		{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error","data":null},"id":3}
+	]`)
+	if !reflect.DeepEqual(res, want) {
+		t.Fatalf("response %v, expected %v", res, want)
+	}
+}
+
+func TestLimitIPBeforeAuthentication(t *testing.T) {
+	authenticator := &countingAuthenticator{}
+	chains := NewChains(1, false)
+	chains.Add(1, testRegistry())
+	router := gin.New()
+	router.POST("/rpc", chains.Route(), chains.LimitIP(), Authenticate(authenticator), chains.Process())
+
+	for _, tc := range []struct {
+		ip    string
+		body  string
+		calls int
+		want  string
+	}{
+		{"192.0.2.1", `{"jsonrpc":"2.0","method":"test_limited","id":1}`, 0,
+			`{"jsonrpc":"2.0","error":{"code":-32005,"message":"Limit exceeded","data":"limited"},"id":null}`},
+		{"192.0.2.1", `[{"jsonrpc":"2.0","method":"test_echo","params":["a"],"id":1},{"jsonrpc":"2.0","method":"test_limited","id":2}]`, 0,
+			`{"jsonrpc":"2.0","error":{"code":-32005,"message":"Limit exceeded","data":"limited"},"id":null}`},
+		{"192.0.2.2", `{"jsonrpc":"2.0","method":"test_echo","params":["a"],"id":1}`, 1,
+			`{"jsonrpc":"2.0","result":{"name":"a"},"id":1}`},
+	} {
+		authenticator.calls = 0
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(tc.body))
+		req.RemoteAddr = tc.ip + ":1234"
+		router.ServeHTTP(rec, req)
+		if authenticator.calls != tc.calls {
+			t.Errorf("%s: authenticated %d times, expected %d", tc.body, authenticator.calls, tc.calls)
+		}
+		if res, want := decode(t, rec.Body.String()), decode(t, tc.want); !reflect.DeepEqual(res, want) {
+			t.Errorf("%s: response %v, expected %v", tc.body, res, want)
+		}
+	}
+}
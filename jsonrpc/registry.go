@@ -0,0 +1,221 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ququzone/verifying-paymaster-service/auth"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+)
+
+// Rate limit classes of methods.
+const (
+	RateSponsor = "sponsor"
+	RateRead    = "read"
+)
+
+// MethodOptions are the access requirements of a method.
+type MethodOptions struct {
+	// Scopes are the api key scopes required to call the method.
+	Scopes []string
+	// RateClass is the rate limit class the method counts against.
+	RateClass string
+}
+
+// Method is a registered RPC method.
+type Method struct {
+	Name      string
+	Params    []string
+	Scopes    []string
+	RateClass string
+
+	params reflect.Type
+	call   func(ctx context.Context, params any) (any, error)
+}
+
+// RateLimiter limits the calls of a rate class by the caller of ctx and its client ip.
+type RateLimiter interface {
+	// CheckIP returns an error if the client ip is over its limit, without counting a call.
+	// It is checked before the caller is authenticated.
+	CheckIP(ctx context.Context, ip string, class string) error
+	// Allow returns an error if the call is over a limit, otherwise it counts the call.
+	Allow(ctx context.Context, ip string, class string) error
+}
+
+// Registry holds the RPC methods exposed by Process.
+type Registry struct {
+	methods map[string]*Method
+	limiter RateLimiter
+}
+
+// NewRegistry creates a registry with the rpc_modules and rpc_methods introspection methods.
+func NewRegistry() *Registry {
+	r := &Registry{methods: make(map[string]*Method)}
+	Register(r, "rpc_modules", MethodOptions{RateClass: RateRead}, func(ctx context.Context, _ *struct{}) (map[string]string, error) {
+		return r.modules(), nil
+	})
+	Register(r, "rpc_methods", MethodOptions{RateClass: RateRead}, func(ctx context.Context, _ *struct{}) ([]*MethodInfo, error) {
+		return r.list(auth.FromContext(ctx)), nil
+	})
+	return r
+}
+
+// Register adds method name calling handler with the params decoded into P, a struct whose
+// fields are the params in positional order. Fields tagged `json:",omitempty"` are optional.
+// The handler gets the request context, which carries the caller principal.
+func Register[P any, R any](r *Registry, name string, opts MethodOptions, handler func(ctx context.Context, params *P) (R, error)) {
+	typ := reflect.TypeOf((*P)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("jsonrpc: params of %s is not a struct", name))
+	}
+	if _, ok := r.methods[name]; ok {
+		panic(fmt.Sprintf("jsonrpc: method %s registered twice", name))
+	}
+	params := make([]string, 0, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		params = append(params, paramName(typ.Field(i)))
+	}
+	r.methods[name] = &Method{
+		Name:      name,
+		Params:    params,
+		Scopes:    opts.Scopes,
+		RateClass: opts.RateClass,
+		params:    typ,
+		call: func(ctx context.Context, params any) (any, error) {
+			return handler(ctx, params.(*P))
+		},
+	}
+}
+
+// SetRateLimiter limits the calls of the methods by their rate class.
+func (r *Registry) SetRateLimiter(limiter RateLimiter) {
+	r.limiter = limiter
+}
+
+// Get returns the method of name, nil if it is not registered.
+func (r *Registry) Get(name string) *Method {
+	return r.methods[name]
+}
+
+// MethodInfo describes a method in rpc_methods.
+type MethodInfo struct {
+	Name      string   `json:"name"`
+	Params    []string `json:"params"`
+	Scopes    []string `json:"scopes"`
+	RateClass string   `json:"rateClass"`
+}
+
+// list returns the methods callable by principal sorted by name.
+func (r *Registry) list(principal *auth.Principal) []*MethodInfo {
+	methods := make([]*MethodInfo, 0, len(r.methods))
+	for _, m := range r.methods {
+		if m.allowed(principal) != nil {
+			continue
+		}
+		scopes := m.Scopes
+		if scopes == nil {
+			scopes = []string{}
+		}
+		methods = append(methods, &MethodInfo{
+			Name:      m.Name,
+			Params:    m.Params,
+			Scopes:    scopes,
+			RateClass: m.RateClass,
+		})
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].Name < methods[j].Name
+	})
+	return methods
+}
+
+// modules returns the namespaces of the registered methods with their version.
+func (r *Registry) modules() map[string]string {
+	modules := make(map[string]string)
+	for name := range r.methods {
+		if i := strings.Index(name, "_"); i > 0 {
+			modules[name[:i]] = "1.0"
+		}
+	}
+	return modules
+}
+
+func (m *Method) allowed(principal *auth.Principal) error {
+	if principal == nil {
+		return errors.NewRPCError(errors.UNAUTHORIZED, "Unauthorized", "Request is not authenticated")
+	}
+	for _, scope := range m.Scopes {
+		if !principal.HasScope(scope) {
+			return errors.NewRPCError(errors.UNAUTHORIZED, "Unauthorized", fmt.Sprintf("Api key has no %s scope", scope))
+		}
+	}
+	return nil
+}
+
+// decode decodes positional or named params into a new params struct of the method.
+func (m *Method) decode(raw json.RawMessage) (any, error) {
+	params := reflect.New(m.params)
+	fields := params.Elem()
+	if raw == nil {
+		raw = json.RawMessage("[]")
+	}
+
+	values := make([]json.RawMessage, fields.NumField())
+	if raw[0] == '[' {
+		var positional []json.RawMessage
+		if err := json.Unmarshal(raw, &positional); err != nil {
+			return nil, invalidParamsError("No or invalid 'params' in request")
+		}
+		if len(positional) > len(values) {
+			return nil, invalidParamsError("Invalid number of params")
+		}
+		copy(values, positional)
+	} else {
+		var named map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &named); err != nil {
+			return nil, invalidParamsError("No or invalid 'params' in request")
+		}
+		for i, name := range m.Params {
+			values[i] = named[name]
+			delete(named, name)
+		}
+		if len(named) > 0 {
+			return nil, invalidParamsError("Unknown params in request")
+		}
+	}
+
+	for i, value := range values {
+		field := m.params.Field(i)
+		if value == nil {
+			if !optional(field) {
+				return nil, invalidParamsError(fmt.Sprintf("Missing param %s", m.Params[i]))
+			}
+			continue
+		}
+		if err := json.Unmarshal(value, fields.Field(i).Addr().Interface()); err != nil {
+			return nil, invalidParamsError(fmt.Sprintf("Param %s can't be converted to %v", m.Params[i], field.Type))
+		}
+	}
+	return params.Interface(), nil
+}
+
+func paramName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func optional(field reflect.StructField) bool {
+	_, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return strings.Contains(opts, "omitempty")
+}
+
+func invalidParamsError(data string) error {
+	return errors.NewRPCError(invalidParams, "Invalid params", data)
+}
@@ -4,17 +4,28 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/metrics/prometheus"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
+	"github.com/ququzone/verifying-paymaster-service/admin"
 	"github.com/ququzone/verifying-paymaster-service/api"
+	"github.com/ququzone/verifying-paymaster-service/auth"
 	"github.com/ququzone/verifying-paymaster-service/config"
 	"github.com/ququzone/verifying-paymaster-service/container"
 	"github.com/ququzone/verifying-paymaster-service/db"
 	"github.com/ququzone/verifying-paymaster-service/jsonrpc"
 	"github.com/ququzone/verifying-paymaster-service/logger"
 	"github.com/ququzone/verifying-paymaster-service/models"
+	"github.com/ququzone/verifying-paymaster-service/policy"
+	"github.com/ququzone/verifying-paymaster-service/quota"
+	"github.com/ququzone/verifying-paymaster-service/ratelimit"
+	"github.com/ququzone/verifying-paymaster-service/signer"
 )
 
 func main() {
@@ -26,22 +37,89 @@ func main() {
 	if err != nil {
 		log.Fatalf("init config error: %v", err)
 	}
+	if ok, err := runCommand(config.Config(), os.Args[1:]); ok {
+		exitCommand(err)
+	}
 
 	repository := db.NewRepository()
-	err = repository.AutoMigrate(&models.User{}, &models.ApiKeys{}, &models.Account{})
+	err = repository.AutoMigrate(&models.User{}, &models.ApiKeys{}, &models.Account{}, &models.PolicyRule{}, &models.Reservation{}, &models.Sponsorship{}, &models.RateBucket{}, &models.SettlementCursor{})
 	if err != nil {
 		logger.S().Fatalf("database migrate error: %v", err)
 	}
-
-	signerApi, err := api.NewSigner(container.NewContainer(repository))
+	err = models.HashLegacyKeys(repository)
 	if err != nil {
-		logger.S().Fatalf("instance signer error: %v", err)
+		logger.S().Fatalf("hash api keys error: %v", err)
 	}
 
 	conf := config.Config()
+	secrets, err := models.NewSecretKey(conf.SigningSecretKey)
+	if err != nil {
+		logger.S().Fatalf("instance signing secret key error: %v", err)
+	}
+	if secrets == nil {
+		logger.S().Warn("SIGNING_SECRET_KEY is not set, the signing secrets of api keys are stored in plain")
+	}
+	err = models.SealSigningSecrets(repository, secrets)
+	if err != nil {
+		logger.S().Fatalf("encrypt signing secrets error: %v", err)
+	}
+	// gauges are no-ops unless metrics are enabled before they are created
+	metrics.Enabled = conf.Metrics
+	windows, err := quota.NewWindows(conf.QuotaWindow, conf.QuotaResetTime)
+	if err != nil {
+		logger.S().Fatalf("instance quota windows error: %v", err)
+	}
+	policies := policy.NewEngine(repository)
+	if err := policies.Reload(); err != nil {
+		logger.S().Fatalf("load policies error: %v", err)
+	}
+	go policies.Watch(time.Duration(conf.PolicyReloadInterval)*time.Second, nil)
+
+	signers := make([]*api.Signer, len(conf.Chains))
+	for i, chain := range conf.Chains {
+		keys, err := newKeyring(chain)
+		if err != nil {
+			logger.S().Fatalf("instance verifying signers of chain %d error: %v", chain.ChainID, err)
+		}
+		signers[i], err = api.NewSigner(container.NewContainer(repository), chain, keys, policies, windows)
+		if err != nil {
+			logger.S().Fatalf("instance signer of chain %d error: %v", chain.ChainID, err)
+		}
+	}
+	defaultChain := signers[0].ChainID.Uint64()
+	err = models.AssignChain(repository, defaultChain)
+	if err != nil {
+		logger.S().Fatalf("assign accounts to chain error: %v", err)
+	}
+	for i, signerApi := range signers {
+		err = signerApi.StartSettlement(time.Duration(conf.SettlementInterval) * time.Second)
+		if err != nil {
+			logger.S().Fatalf("start settlement of chain %s error: %v", signerApi.ChainID, err)
+		}
+		err = signerApi.StartDepositMonitor(time.Duration(conf.DepositCheckInterval) * time.Second)
+		if err != nil {
+			logger.S().Fatalf("start deposit monitor of chain %s error: %v", signerApi.ChainID, err)
+		}
+		err = signerApi.StartGasPriceOracle(time.Duration(conf.GasPriceInterval)*time.Second, conf.GasPriceBlocks)
+		if err != nil {
+			logger.S().Fatalf("start gas price oracle of chain %s error: %v", signerApi.ChainID, err)
+		}
+		if conf.SignerCheckInterval > 0 {
+			go signerApi.WatchVerifyingSigners(time.Duration(conf.SignerCheckInterval) * time.Second)
+		}
+		paymasters := []common.Address{signerApi.Contract}
+		if signerApi.ContractV07 != (common.Address{}) {
+			paymasters = append(paymasters, signerApi.ContractV07)
+		}
+		err = startTopUps(conf, conf.Chains[i], signerApi.Client, signerApi.ChainID, paymasters)
+		if err != nil {
+			logger.S().Fatalf("start deposit top up of chain %s error: %v", signerApi.ChainID, err)
+		}
+	}
+
 	gin.SetMode(conf.GinMode)
 	r := gin.New()
-	if err := r.SetTrustedProxies(nil); err != nil {
+	if err := r.SetTrustedProxies(conf.TrustedProxies); err != nil {
 		logger.S().Fatalf("gin set trusted proxies error: %v", err)
 	}
 	r.Use(
@@ -51,12 +129,125 @@ func main() {
 	r.GET("/ping", func(g *gin.Context) {
 		g.String(http.StatusOK, "ok")
 	})
+	if conf.Metrics {
+		r.GET("/metrics", gin.WrapH(prometheus.Handler(metrics.DefaultRegistry)))
+	}
+	authenticator, err := newAuthenticator(conf, repository, secrets)
+	if err != nil {
+		logger.S().Fatalf("instance authenticator error: %v", err)
+	}
+	throttle, err := newThrottle(conf, repository)
+	if err != nil {
+		logger.S().Fatalf("instance rate limiter error: %v", err)
+	}
+	chains := jsonrpc.NewChains(defaultChain, conf.AuthPathKey)
+	for _, signerApi := range signers {
+		registry := jsonrpc.NewRegistry()
+		registry.SetRateLimiter(throttle)
+		signerApi.Register(registry)
+		chains.Add(signerApi.ChainID.Uint64(), registry)
+	}
 	handlers := []gin.HandlerFunc{
-		jsonrpc.Process(signerApi),
+		chains.Route(),
+		chains.LimitIP(),
+		jsonrpc.Authenticate(authenticator),
+		chains.Process(),
+	}
+	r.POST("/rpc", handlers...)
+	r.POST("/rpc/:chainId", handlers...)
+	if conf.AuthPathKey {
+		r.POST("/rpc/:chainId/:key", handlers...)
+	}
+
+	if conf.AdminToken != "" {
+		admin.NewHandler(repository, defaultChain, secrets).Register(r.Group("/admin"), conf.AdminToken)
 	}
-	r.POST("/rpc/:key", handlers...)
 
 	if err := r.Run(fmt.Sprintf(":%d", conf.Port)); err != nil {
 		logger.S().Fatalf("gin run error: %v", err)
 	}
 }
+
+func newAuthenticator(conf *config.Values, repository db.Repository, secrets *models.SecretKey) (auth.Authenticator, error) {
+	switch conf.AuthMode {
+	case "db":
+		return auth.NewDBAuthenticator(repository), nil
+	case "static":
+		return auth.NewStaticAuthenticator(conf.AuthKeysFile)
+	case "hmac":
+		return auth.NewHMACAuthenticator(repository, time.Duration(conf.AuthHMACWindow)*time.Second, secrets), nil
+	}
+	return nil, fmt.Errorf("unknown auth mode %s", conf.AuthMode)
+}
+
+func newKeyring(chain *config.Chain) (*signer.Keyring, error) {
+	keys := append([]config.Key{chain.Key}, chain.Keys...)
+	signers := make([]signer.Signer, len(keys))
+	for i := range keys {
+		var err error
+		signers[i], err = newVerifyingSigner(&keys[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return signer.NewKeyring(signers...)
+}
+
+// openPKCS11 opens the token of a pkcs11 signer key, tests replace it by a fake token.
+var openPKCS11 = signer.OpenPKCS11
+
+func newVerifyingSigner(key *config.Key) (signer.Signer, error) {
+	switch key.Signer {
+	case "keystore":
+		return signer.NewKeystore(key.Keystore, key.Passphrase)
+	case "remote":
+		if !common.IsHexAddress(key.SignerAddress) {
+			return nil, fmt.Errorf("invalid remote signer address %s", key.SignerAddress)
+		}
+		return signer.NewRemote(key.SignerURL, key.SignerMethod, common.HexToAddress(key.SignerAddress))
+	case "pkcs11":
+		token, err := openPKCS11(signer.PKCS11Config{
+			Module:     key.PKCS11Module,
+			TokenLabel: key.PKCS11TokenLabel,
+			PIN:        key.PKCS11PIN,
+			KeyLabel:   key.PKCS11KeyLabel,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return signer.NewHSM(token)
+	}
+	return nil, fmt.Errorf("unknown signer %s", key.Signer)
+}
+
+func newThrottle(conf *config.Values, repository db.Repository) (*ratelimit.Throttle, error) {
+	var store ratelimit.Store
+	switch conf.RateLimitStore {
+	case "memory":
+		store = ratelimit.NewMemoryStore(time.Minute)
+	case "postgres":
+		store = ratelimit.NewPostgresStore(repository, time.Minute)
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %s", conf.RateLimitStore)
+	}
+
+	keyLimits, ipLimits := ratelimit.Limits{}, ratelimit.Limits{}
+	limits := []struct {
+		limits ratelimit.Limits
+		class  string
+		value  string
+	}{
+		{keyLimits, jsonrpc.RateSponsor, conf.RateLimitKeySponsor},
+		{keyLimits, jsonrpc.RateRead, conf.RateLimitKeyRead},
+		{ipLimits, jsonrpc.RateSponsor, conf.RateLimitIPSponsor},
+		{ipLimits, jsonrpc.RateRead, conf.RateLimitIPRead},
+	}
+	for _, l := range limits {
+		limit, err := ratelimit.ParseLimit(l.value)
+		if err != nil {
+			return nil, err
+		}
+		l.limits[l.class] = limit
+	}
+	return ratelimit.NewThrottle(store, keyLimits, ipLimits), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ququzone/verifying-paymaster-service/config"
+	"github.com/ququzone/verifying-paymaster-service/signer"
+)
+
+// fakeToken signs digests with an in memory key as a PKCS#11 token does, without recovery id.
+type fakeToken struct {
+	key *ecdsa.PrivateKey
+}
+
+func (t fakeToken) PublicKey() (*ecdsa.PublicKey, error) {
+	return &t.key.PublicKey, nil
+}
+
+func (t fakeToken) SignDigest(digest []byte) ([]byte, error) {
+	signature, err := crypto.Sign(digest, t.key)
+	if err != nil {
+		return nil, err
+	}
+	return signature[:64], nil
+}
+
+func TestNewVerifyingSignerPKCS11(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opened signer.PKCS11Config
+	openPKCS11 = func(conf signer.PKCS11Config) (signer.Token, error) {
+		opened = conf
+		return fakeToken{key: key}, nil
+	}
+	defer func() { openPKCS11 = signer.OpenPKCS11 }()
+
+	s, err := newVerifyingSigner(&config.Key{
+		Signer:           "pkcs11",
+		PKCS11Module:     "/usr/lib/softhsm/libsofthsm2.so",
+		PKCS11TokenLabel: "paymaster",
+		PKCS11PIN:        "1234",
+		PKCS11KeyLabel:   "verifying",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := signer.PKCS11Config{Module: "/usr/lib/softhsm/libsofthsm2.so", TokenLabel: "paymaster", PIN: "1234", KeyLabel: "verifying"}
+	if opened != expected {
+		t.Fatalf("opened token %+v, expected %+v", opened, expected)
+	}
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	if s.Address() != address {
+		t.Fatalf("signer address %s, expected %s", s.Address(), address)
+	}
+
+	message := []byte("paymaster hash")
+	signature, err := s.SignMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature[crypto.RecoveryIDOffset] -= 27
+	pub, err := crypto.SigToPub(accounts.TextHash(message), signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crypto.PubkeyToAddress(*pub) != address {
+		t.Fatalf("signature recovers to %s, expected %s", crypto.PubkeyToAddress(*pub), address)
+	}
+}
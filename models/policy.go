@@ -0,0 +1,29 @@
+package models
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// PolicyRule is a single sponsorship rule. Rules with ApiKeyID 0 apply to every api key,
+// rules with a PolicyID only apply to the sponsorships requesting that policy.
+type PolicyRule struct {
+	gorm.Model
+	ApiKeyID uint   `gorm:"index"`
+	PolicyID string `gorm:"type:varchar(64)"`
+	Name     string `gorm:"type:varchar(64)"`
+	Kind     string `gorm:"type:varchar(32)"`
+	Action   string `gorm:"type:varchar(8)"`
+	Values   string
+	Enable   bool
+}
+
+func (r *PolicyRule) FindEnabled(rep db.Repository) ([]PolicyRule, error) {
+	var recs []PolicyRule
+	err := rep.Model(&PolicyRule{}).Where(`"enable" = ?`, true).Order("id").Find(&recs).Error
+	if err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Limits are the sponsorship limits of an api key or a user. Budgets are in wei
+// and a zero value means unlimited. Days and months start at the reset time of the fixed quota window.
+type Limits struct {
+	// DailyBudget is the spend across all senders since the day start.
+	DailyBudget string `gorm:"type:numeric(78,0);default:0"`
+	// MonthlyBudget is the spend across all senders since the month start.
+	MonthlyBudget string `gorm:"type:numeric(78,0);default:0"`
+	// SenderAllowance is the spend of one sender since the day start.
+	SenderAllowance string `gorm:"type:numeric(78,0);default:0"`
+	// SenderMaxOps is the number of sponsored operations of one sender since the day start.
+	SenderMaxOps uint `gorm:"default:0"`
+	// HardCap is the total spend across all senders.
+	HardCap string `gorm:"type:numeric(78,0);default:0"`
+}
+
+func (l *Limits) GetDailyBudget() *big.Int {
+	return parseWei(l.DailyBudget)
+}
+
+func (l *Limits) GetMonthlyBudget() *big.Int {
+	return parseWei(l.MonthlyBudget)
+}
+
+func (l *Limits) GetSenderAllowance() *big.Int {
+	return parseWei(l.SenderAllowance)
+}
+
+func (l *Limits) GetHardCap() *big.Int {
+	return parseWei(l.HardCap)
+}
+
+func parseWei(value string) *big.Int {
+	n, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return n
+}
+
+// Usage is the spend and the number of operations of sponsorships. Pending reservations
+// count with their max cost, released ones are not counted.
+type Usage struct {
+	Cost string
+	Ops  int64
+}
+
+// UsageFilter selects the sponsorships on a chain of an api key, or of all api keys of a user
+// when UserID is set, optionally of one sender and created since a time.
+type UsageFilter struct {
+	ChainID  uint64
+	ApiKeyID uint
+	UserID   uint
+	Sender   string
+	Since    time.Time
+}
+
+func (u *Usage) Find(rep db.Repository, filter UsageFilter) (*Usage, error) {
+	query := rep.Model(&Sponsorship{}).
+		Select(`COALESCE(SUM(CASE reservations.status WHEN ? THEN reservations.actual_gas_cost WHEN ? THEN reservations.amount ELSE 0 END), 0) AS cost, `+
+			`COUNT(*) FILTER (WHERE reservations.status <> ?) AS ops`,
+			ReservationSettled, ReservationPending, ReservationReleased).
+		Joins("JOIN reservations ON reservations.user_op_hash = sponsorships.user_op_hash").
+		Where("sponsorships.chain_id = ?", filter.ChainID)
+	if filter.UserID != 0 {
+		query = query.Where("sponsorships.api_key_id IN (SELECT id FROM api_keys WHERE user_id = ?)", filter.UserID)
+	} else {
+		query = query.Where("sponsorships.api_key_id = ?", filter.ApiKeyID)
+	}
+	if filter.Sender != "" {
+		query = query.Where("sponsorships.sender = ?", filter.Sender)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("sponsorships.created_at >= ?", filter.Since)
+	}
+
+	var rec Usage
+	if err := query.Scan(&rec).Error; err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (u *Usage) GetCost() *big.Int {
+	return parseWei(u.Cost)
+}
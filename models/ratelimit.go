@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// RateBucket is a token bucket shared by the replicas of the service.
+type RateBucket struct {
+	Key       string `gorm:"primaryKey;type:varchar(128)"`
+	Tokens    float64
+	UpdatedAt time.Time
+	// Rate and Burst are the limit of the last take, the bucket is full again Burst / Rate seconds after it.
+	Rate  float64
+	Burst int
+}
+
+// RateLimits are the "<rate>:<burst>" limits of an api key per rate class, empty for the default limits.
+type RateLimits struct {
+	Sponsor string `gorm:"type:varchar(32)"`
+	Read    string `gorm:"type:varchar(32)"`
+}
+
+func (r *RateLimits) Get(class string) string {
+	switch class {
+	case "sponsor":
+		return r.Sponsor
+	case "read":
+		return r.Read
+	}
+	return ""
+}
+
+// Take refills the bucket key with rate tokens per second up to burst and takes one token,
+// reporting whether one was available. The bucket is updated in a single statement.
+func (b *RateBucket) Take(rep db.Repository, key string, rate float64, burst int) (bool, error) {
+	res := rep.Exec(`INSERT INTO rate_buckets (key, tokens, updated_at, rate, burst) VALUES (@key, @burst - 1, clock_timestamp(), @rate, @burst)
+ON CONFLICT (key) DO UPDATE SET
+	tokens = LEAST(@burst, rate_buckets.tokens + EXTRACT(EPOCH FROM clock_timestamp() - rate_buckets.updated_at) * @rate) - 1,
+	updated_at = clock_timestamp(),
+	rate = @rate,
+	burst = @burst
+WHERE LEAST(@burst, rate_buckets.tokens + EXTRACT(EPOCH FROM clock_timestamp() - rate_buckets.updated_at) * @rate) >= 1`,
+		map[string]interface{}{"key": key, "rate": rate, "burst": burst},
+	)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// Available reports whether the bucket key refilled with rate tokens per second up to burst has a token.
+func (b *RateBucket) Available(rep db.Repository, key string, rate float64, burst int) (bool, error) {
+	var available []bool
+	err := rep.Raw(`SELECT LEAST(@burst, tokens + EXTRACT(EPOCH FROM clock_timestamp() - updated_at) * @rate) >= 1
+FROM rate_buckets WHERE key = @key`,
+		map[string]interface{}{"key": key, "rate": rate, "burst": burst},
+	).Scan(&available).Error
+	if err != nil {
+		return false, err
+	}
+	if len(available) == 0 {
+		return burst >= 1, nil
+	}
+	return available[0], nil
+}
+
+// DeleteRefilled deletes the buckets refilled to their burst at the rate of their last take,
+// taking from a new bucket is the same. It returns the number of deleted buckets.
+func (b *RateBucket) DeleteRefilled(rep db.Repository) (int64, error) {
+	res := rep.Exec(`DELETE FROM rate_buckets
+WHERE tokens + EXTRACT(EPOCH FROM clock_timestamp() - updated_at) * rate >= burst`)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	return res.RowsAffected, nil
+}
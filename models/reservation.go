@@ -0,0 +1,128 @@
+package models
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+const (
+	ReservationPending  = "pending"
+	ReservationSettled  = "settled"
+	ReservationReleased = "released"
+)
+
+// Reservation is the worst case cost of a signed user operation, held from the account
+// until the operation is included on chain or its validity expires.
+type Reservation struct {
+	gorm.Model
+	UserOpHash    string `gorm:"unique;type:varchar(66)"`
+	AccountID     uint   `gorm:"index"`
+	Amount        string `gorm:"type:numeric(78,0)"`
+	ActualGasCost string `gorm:"type:numeric(78,0)"`
+	Status        string `gorm:"index;type:varchar(16)"`
+	ValidUntil    time.Time
+	// Block is the chain head when the reservation was made, the operation can not be included before it.
+	Block uint64
+}
+
+func (r *Reservation) FindPendingForUpdate(tx db.Repository, userOpHash string) (*Reservation, error) {
+	return r.findForUpdate(tx, userOpHash, ReservationPending)
+}
+
+func (r *Reservation) FindSettledForUpdate(tx db.Repository, userOpHash string) (*Reservation, error) {
+	return r.findForUpdate(tx, userOpHash, ReservationSettled)
+}
+
+func (r *Reservation) findForUpdate(tx db.Repository, userOpHash string, status string) (*Reservation, error) {
+	var rec Reservation
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&rec, `"user_op_hash" = ? AND "status" = ?`, userOpHash, status).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// FindExpired returns the pending reservations whose validity ended before t.
+func (r *Reservation) FindExpired(rep db.Repository, t time.Time) ([]Reservation, error) {
+	var recs []Reservation
+	err := rep.Model(&Reservation{}).
+		Where(`"status" = ? AND "valid_until" < ?`, ReservationPending, t).
+		Find(&recs).Error
+	if err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+func (r *Reservation) GetAmount() *big.Int {
+	amount, ok := new(big.Int).SetString(r.Amount, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return amount
+}
+
+func (r *Reservation) FindByHashes(rep db.Repository, userOpHashes []string) ([]Reservation, error) {
+	var recs []Reservation
+	err := rep.Model(&Reservation{}).Where(`"user_op_hash" IN ?`, userOpHashes).Find(&recs).Error
+	if err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// SumUsage returns the spend of the account on reservations created since a time,
+// pending reservations count with their amount and released ones are not counted.
+func (r *Reservation) SumUsage(rep db.Repository, accountID uint, since time.Time) (*big.Int, error) {
+	var used string
+	err := rep.Model(&Reservation{}).
+		Select(`COALESCE(SUM(CASE "status" WHEN ? THEN "actual_gas_cost" WHEN ? THEN "amount" ELSE 0 END), 0)`,
+			ReservationSettled, ReservationPending).
+		Where(`"account_id" = ? AND "created_at" >= ?`, accountID, since).
+		Scan(&used).Error
+	if err != nil {
+		return nil, err
+	}
+	amount, ok := new(big.Int).SetString(used, 10)
+	if !ok {
+		return new(big.Int), nil
+	}
+	return amount, nil
+}
+
+// LockPending serializes the transactions which check and add to the pending reservations of the
+// sponsorships on chainID through entryPoint, the lock is held until the end of the transaction tx.
+func (r *Reservation) LockPending(tx db.Repository, chainID uint64, entryPoint string) error {
+	return tx.Exec(`SELECT pg_advisory_xact_lock(hashtextextended(?, 0))`,
+		fmt.Sprintf("reservations:%d:%s", chainID, entryPoint)).Error
+}
+
+// SumPending returns the amount of the pending reservations of the sponsorships on chainID through entryPoint,
+// the cost the paymaster deposit may still be charged for.
+func (r *Reservation) SumPending(rep db.Repository, chainID uint64, entryPoint string) (*big.Int, error) {
+	var pending string
+	err := rep.Model(&Reservation{}).
+		Select(`COALESCE(SUM("reservations"."amount"), 0)`).
+		Joins(`JOIN "sponsorships" ON "sponsorships"."user_op_hash" = "reservations"."user_op_hash"`).
+		Where(`"reservations"."status" = ? AND "sponsorships"."chain_id" = ? AND "sponsorships"."entry_point" = ?`,
+			ReservationPending, chainID, entryPoint).
+		Scan(&pending).Error
+	if err != nil {
+		return nil, err
+	}
+	amount, ok := new(big.Int).SetString(pending, 10)
+	if !ok {
+		return new(big.Int), nil
+	}
+	return amount, nil
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// sealedPrefix marks the signing secrets stored encrypted.
+const sealedPrefix = "enc:"
+
+// SecretKey encrypts the signing secrets of api keys at rest with AES-256-GCM. Without a SecretKey,
+// a nil one, signing secrets are stored in plain.
+type SecretKey struct {
+	aead cipher.AEAD
+}
+
+// NewSecretKey returns the SecretKey of the hex encoded 32 bytes key, nil for an empty key.
+func NewSecretKey(key string) (*SecretKey, error) {
+	if key == "" {
+		return nil, nil
+	}
+	raw, err := hex.DecodeString(strings.TrimPrefix(key, "0x"))
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("signing secret key is not 32 hex encoded bytes")
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretKey{aead: aead}, nil
+}
+
+func (k *SecretKey) seal(secret string) (string, error) {
+	if k == nil {
+		return secret, nil
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := k.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *SecretKey) open(stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedPrefix) {
+		return stored, nil
+	}
+	if k == nil {
+		return "", errors.New("signing secret is encrypted and no signing secret key is set")
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return "", errors.New("malformed encrypted signing secret")
+	}
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	secret, err := k.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypt signing secret: %v", err)
+	}
+	return string(secret), nil
+}
+
+// SetSigningSecret stores secret encrypted with key.
+func (a *ApiKeys) SetSigningSecret(key *SecretKey, secret string) error {
+	stored, err := key.seal(secret)
+	if err != nil {
+		return err
+	}
+	a.SigningSecret = stored
+	return nil
+}
+
+// GetSigningSecret returns the signing secret decrypted with key, secrets stored in plain are returned as is.
+func (a *ApiKeys) GetSigningSecret(key *SecretKey) (string, error) {
+	return key.open(a.SigningSecret)
+}
+
+// SealSigningSecrets encrypts with key the signing secrets of the api_keys table stored in plain,
+// before the key was set.
+func SealSigningSecrets(rep db.Repository, key *SecretKey) error {
+	if key == nil {
+		return nil
+	}
+	var recs []ApiKeys
+	err := rep.Model(&ApiKeys{}).
+		Where(`"signing_secret" <> '' AND "signing_secret" NOT LIKE ?`, sealedPrefix+"%").
+		Find(&recs).Error
+	if err != nil {
+		return err
+	}
+	for i := range recs {
+		plain := recs[i].SigningSecret
+		if err := recs[i].SetSigningSecret(key, plain); err != nil {
+			return err
+		}
+		// a secret rotated meanwhile is kept
+		err := rep.Model(&ApiKeys{}).Where(`"id" = ? AND "signing_secret" = ?`, recs[i].ID, plain).
+			Update("signing_secret", recs[i].SigningSecret).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
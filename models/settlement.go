@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// SettlementCursor is the last block whose UserOperationEvents of a paymaster are settled,
+// the settlement resumes after it on restart.
+type SettlementCursor struct {
+	gorm.Model
+	ChainID   uint64 `gorm:"uniqueIndex:idx_settlement_cursors_chain_paymaster"`
+	Paymaster string `gorm:"uniqueIndex:idx_settlement_cursors_chain_paymaster;type:varchar(42)"`
+	Block     uint64
+}
+
+func (c *SettlementCursor) Find(rep db.Repository, chainID uint64, paymaster string) (*SettlementCursor, error) {
+	var rec SettlementCursor
+	err := rep.Model(&SettlementCursor{}).First(&rec, `"chain_id" = ? AND "paymaster" = ?`, chainID, paymaster).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// Advance moves the cursor of the chain and paymaster of c to c.Block, it never moves back.
+func (c *SettlementCursor) Advance(rep db.Repository) error {
+	return rep.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "chain_id"}, {Name: "paymaster"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"block":      gorm.Expr(`GREATEST("settlement_cursors"."block", excluded."block")`),
+			"updated_at": time.Now(),
+		}),
+	}).Create(c).Error
+}
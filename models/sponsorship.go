@@ -0,0 +1,106 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+)
+
+// Sponsorship is the audit record of a signed paymasterAndData.
+type Sponsorship struct {
+	gorm.Model
+	UserOpHash                    string `gorm:"unique;type:varchar(66)"`
+	ChainID                       uint64 `gorm:"index"`
+	Sender                        string `gorm:"index;type:varchar(42)"`
+	Nonce                         string `gorm:"type:numeric(78,0)"`
+	ApiKeyID                      uint   `gorm:"index"`
+	EntryPoint                    string `gorm:"type:varchar(42)"`
+	CallGasLimit                  string `gorm:"type:numeric(78,0)"`
+	VerificationGasLimit          string `gorm:"type:numeric(78,0)"`
+	PreVerificationGas            string `gorm:"type:numeric(78,0)"`
+	PaymasterVerificationGasLimit string `gorm:"type:numeric(78,0)"`
+	PaymasterPostOpGasLimit       string `gorm:"type:numeric(78,0)"`
+	MaxFeePerGas                  string `gorm:"type:numeric(78,0)"`
+	MaxPriorityFeePerGas          string `gorm:"type:numeric(78,0)"`
+	MaxCost                       string `gorm:"type:numeric(78,0)"`
+	ValidUntil                    time.Time
+	ValidAfter                    time.Time
+	Signer                        string `gorm:"type:varchar(42)"`
+	PaymasterAndData              string
+	Result                        string
+	PolicyID                      string `gorm:"index;type:varchar(64)"`
+	SponsorshipType               string `gorm:"type:varchar(64)"`
+	// Context is the JSON encoded context param of the sponsorship.
+	Context string
+	// RequestHash is the userOpHash of the operation as requested, without the paymaster fields.
+	RequestHash string `gorm:"index;type:varchar(66)"`
+}
+
+func (s *Sponsorship) FindByHash(rep db.Repository, chainID uint64, apiKeyID uint, userOpHash string) (*Sponsorship, error) {
+	var rec Sponsorship
+	err := rep.Model(&Sponsorship{}).
+		First(&rec, `"chain_id" = ? AND "api_key_id" = ? AND "user_op_hash" = ?`, chainID, apiKeyID, userOpHash).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// FindValidByRequestHash returns the last sponsorship of the operation requested as requestHash by the api key
+// which is still valid at t.
+func (s *Sponsorship) FindValidByRequestHash(rep db.Repository, chainID uint64, apiKeyID uint, requestHash string, t time.Time) (*Sponsorship, error) {
+	var rec Sponsorship
+	err := rep.Model(&Sponsorship{}).
+		Where(`"chain_id" = ? AND "api_key_id" = ? AND "request_hash" = ? AND "valid_until" > ?`, chainID, apiKeyID, requestHash, t).
+		Order("id desc").
+		First(&rec).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// FindByUserOpHash returns the sponsorship of userOpHash by any api key on any chain.
+func (s *Sponsorship) FindByUserOpHash(rep db.Repository, userOpHash string) (*Sponsorship, error) {
+	var rec Sponsorship
+	err := rep.Model(&Sponsorship{}).First(&rec, `"user_op_hash" = ?`, userOpHash).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *Sponsorship) FindBySender(rep db.Repository, chainID uint64, apiKeyID uint, sender string, offset int, limit int) ([]Sponsorship, error) {
+	var recs []Sponsorship
+	err := rep.Model(&Sponsorship{}).
+		Where(`"chain_id" = ? AND "api_key_id" = ? AND "sender" = ?`, chainID, apiKeyID, sender).
+		Order("id desc").Offset(offset).Limit(limit).
+		Find(&recs).Error
+	if err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+func (s *Sponsorship) FindByKey(rep db.Repository, chainID uint64, apiKeyID uint, offset int, limit int) ([]Sponsorship, error) {
+	var recs []Sponsorship
+	err := rep.Model(&Sponsorship{}).
+		Where(`"chain_id" = ? AND "api_key_id" = ?`, chainID, apiKeyID).
+		Order("id desc").Offset(offset).Limit(limit).
+		Find(&recs).Error
+	if err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
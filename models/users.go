@@ -1,9 +1,15 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/ququzone/verifying-paymaster-service/db"
 )
@@ -11,40 +17,264 @@ import (
 type User struct {
 	gorm.Model
 	Address string `gorm:"type:varchar(42)"`
+	Limits  Limits `gorm:"embedded;embeddedPrefix:limit_"`
 }
 
 type ApiKeys struct {
 	gorm.Model
-	UserID      uint `json:"-"`
-	User        User
-	Key         string `gorm:"unique;type:varchar(32)"`
+	UserID uint `json:"-"`
+	User   User
+	// KeyHash is the hex encoded SHA-256 of the key, the key itself is not stored.
+	KeyHash string `gorm:"unique;type:varchar(64)"`
+	// KeyPrefix is the start of the key to identify it.
+	KeyPrefix   string `gorm:"type:varchar(8)"`
 	Enable      bool
 	Description string
+	Limits      Limits `gorm:"embedded;embeddedPrefix:limit_"`
+	// QuotaWindow selects how sender accounts are refilled, empty for the default window.
+	QuotaWindow string `gorm:"type:varchar(16)"`
+	// Scopes are the comma separated scopes granted to the key, empty grants every scope.
+	Scopes     string
+	RateLimits RateLimits `gorm:"embedded;embeddedPrefix:rate_limit_"`
+	// ValidFor is the validity of the sponsorships of the key in seconds, 0 for the default validity.
+	ValidFor uint
+	// SigningSecret keys the HMAC signatures of requests, it is generated with the key. It is stored encrypted
+	// when a SecretKey is set, use GetSigningSecret and SetSigningSecret.
+	SigningSecret string `gorm:"type:varchar(128)" json:"-"`
+	// Static marks the keys of the keys file of the static auth mode, they have no row in api_keys.
+	Static bool `gorm:"-" json:"-"`
+}
+
+const keyPrefixLength = 8
+
+// HashKey returns the hex encoded SHA-256 of an api key.
+func HashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// HashLegacyKeys replaces the plain keys of the api_keys table, stored before keys were hashed,
+// with their hash and prefix and drops the plain key column.
+func HashLegacyKeys(rep db.Repository) error {
+	return rep.Exec(`DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'api_keys' AND column_name = 'key') THEN
+		UPDATE api_keys SET key_hash = encode(sha256(convert_to(key, 'UTF8')), 'hex'), key_prefix = left(key, 8)
+			WHERE key IS NOT NULL AND (key_hash IS NULL OR key_hash = '');
+		ALTER TABLE api_keys DROP COLUMN key;
+	END IF;
+END $$`).Error
+}
+
+// Scopes of api keys.
+const (
+	ScopeSponsor = "sponsor"
+	ScopeRead    = "read"
+)
+
+// ValidScope reports whether scope is a known api key scope.
+func ValidScope(scope string) bool {
+	return scope == ScopeSponsor || scope == ScopeRead
+}
+
+func (a *ApiKeys) HasScope(scope string) bool {
+	if strings.TrimSpace(a.Scopes) == "" {
+		return true
+	}
+	for _, s := range strings.Split(a.Scopes, ",") {
+		if strings.TrimSpace(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) FindByID(rep db.Repository, id uint) (*User, error) {
+	var rec User
+	err := rep.Model(&User{}).First(&rec, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// FindByIDForUpdate locks the user row until the end of the transaction tx.
+func (u *User) FindByIDForUpdate(tx db.Repository, id uint) (*User, error) {
+	var rec User
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&rec, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (a *ApiKeys) FindByID(rep db.Repository, id uint) (*ApiKeys, error) {
+	var rec ApiKeys
+	err := rep.Model(&ApiKeys{}).First(&rec, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// FindByIDForUpdate locks the api key row until the end of the transaction tx.
+func (a *ApiKeys) FindByIDForUpdate(tx db.Repository, id uint) (*ApiKeys, error) {
+	var rec ApiKeys
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&rec, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// LockStatic serializes the transactions which check the quota of the static key of id, in place of the
+// api key row lock, the lock is held until the end of the transaction tx.
+func (a *ApiKeys) LockStatic(tx db.Repository, id uint) error {
+	return tx.Exec(`SELECT pg_advisory_xact_lock(hashtextextended(?, 0))`, fmt.Sprintf("api_keys:static:%d", id)).Error
+}
+
+func (a *ApiKeys) FindByUser(rep db.Repository, userID uint) ([]ApiKeys, error) {
+	var recs []ApiKeys
+	err := rep.Model(&ApiKeys{}).Where(`"user_id" = ?`, userID).Order("id").Find(&recs).Error
+	if err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// SetKey stores the hash and the prefix of key.
+func (a *ApiKeys) SetKey(key string) {
+	a.KeyHash = HashKey(key)
+	a.KeyPrefix = key
+	if len(key) > keyPrefixLength {
+		a.KeyPrefix = key[:keyPrefixLength]
+	}
 }
 
 func (a *ApiKeys) FindByKey(rep db.Repository, key string) (*ApiKeys, error) {
 	var rec ApiKeys
-	err := rep.Model(&ApiKeys{}).First(&rec, `"key" = ?`, key).Error
+	err := rep.Model(&ApiKeys{}).First(&rec, `"key_hash" = ?`, HashKey(key)).Error
 	if err == gorm.ErrRecordNotFound {
 		return nil, nil
 	}
 	return &rec, nil
 }
 
+// Account is the gas allowance of a sender on a chain.
 type Account struct {
 	gorm.Model
-	Address     string `gorm:"unique;type:varchar(42)"`
+	ChainID     uint64 `gorm:"uniqueIndex:idx_accounts_chain_address"`
+	Address     string `gorm:"uniqueIndex:idx_accounts_chain_address;type:varchar(42)"`
 	Enable      bool
-	RemainGas   string `gorm:"type:varchar(30)"`
-	UsedGas     string `gorm:"type:varchar(30)"`
+	RemainGas   string `gorm:"type:numeric(78,0)"`
+	UsedGas     string `gorm:"type:numeric(78,0)"`
 	LastRequest time.Time
 }
 
-func (a *Account) FindByAddress(rep db.Repository, address string) (*Account, error) {
+func (a *Account) FindByAddress(rep db.Repository, chainID uint64, address string) (*Account, error) {
+	var rec Account
+	err := rep.Model(&Account{}).First(&rec, `"chain_id" = ? AND "address" = ?`, chainID, address).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return &rec, nil
+}
+
+// FindForUpdate locks the account row until the end of the transaction tx,
+// creating the account with remainGas if it does not exist.
+func (a *Account) FindForUpdate(tx db.Repository, chainID uint64, address string, remainGas string) (rec *Account, created bool, err error) {
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Account{
+		ChainID:     chainID,
+		Address:     address,
+		Enable:      true,
+		UsedGas:     "0",
+		RemainGas:   remainGas,
+		LastRequest: time.Now(),
+	})
+	if res.Error != nil {
+		return nil, false, res.Error
+	}
+
+	rec = &Account{}
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(rec, `"chain_id" = ? AND "address" = ?`, chainID, address).Error
+	if err != nil {
+		return nil, false, err
+	}
+	return rec, res.RowsAffected == 1, nil
+}
+
+func (a *Account) Remain() *big.Int {
+	remain, ok := new(big.Int).SetString(a.RemainGas, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return remain
+}
+
+func (a *Account) Used() *big.Int {
+	used, ok := new(big.Int).SetString(a.UsedGas, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return used
+}
+
+// FindByIDForUpdate locks the account row until the end of the transaction tx.
+func (a *Account) FindByIDForUpdate(tx db.Repository, id uint) (*Account, error) {
 	var rec Account
-	err := rep.Model(&Account{}).First(&rec, `"address" = ?`, address).Error
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&rec, id).Error
 	if err == gorm.ErrRecordNotFound {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
 	return &rec, nil
 }
+
+// Reserve subtracts amount from the remain gas until the reservation is settled or released.
+// It fails without changes if the remain gas is less than amount.
+func (a *Account) Reserve(tx db.Repository, amount *big.Int) (bool, error) {
+	res := tx.Exec(
+		`UPDATE accounts SET remain_gas = remain_gas - ?, updated_at = ? WHERE id = ? AND remain_gas >= ?`,
+		amount.String(), time.Now(), a.ID, amount.String(),
+	)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// Settle adds the actual cost of a reservation to the used gas and gives refund back to the remain gas.
+func (a *Account) Settle(tx db.Repository, refund *big.Int, actual *big.Int) error {
+	return tx.Exec(
+		`UPDATE accounts SET remain_gas = remain_gas + ?, used_gas = used_gas + ?, updated_at = ? WHERE id = ?`,
+		refund.String(), actual.String(), time.Now(), a.ID,
+	).Error
+}
+
+// AssignChain moves the accounts and sponsorships recorded before chains were tracked to chainID,
+// and drops the unique address constraint replaced by the unique chain and address index.
+func AssignChain(rep db.Repository, chainID uint64) error {
+	return rep.Transaction(func(tx db.Repository) error {
+		if err := tx.Exec(`ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_address_key`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`UPDATE accounts SET chain_id = ? WHERE chain_id = 0 OR chain_id IS NULL`, chainID).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`UPDATE sponsorships SET chain_id = ? WHERE chain_id = 0 OR chain_id IS NULL`, chainID).Error
+	})
+}
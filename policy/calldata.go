@@ -0,0 +1,66 @@
+package policy
+
+import (
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// accountABI holds the execute functions of SimpleAccount, executeBatch of the v0.6 account and the overloaded
+// executeBatch with values of the v0.7 account.
+const accountABI = `[
+	{"type":"function","name":"execute","inputs":[{"name":"dest","type":"address"},{"name":"value","type":"uint256"},{"name":"func","type":"bytes"}]},
+	{"type":"function","name":"executeBatch","inputs":[{"name":"dest","type":"address[]"},{"name":"func","type":"bytes[]"}]},
+	{"type":"function","name":"executeBatch","inputs":[{"name":"dest","type":"address[]"},{"name":"value","type":"uint256[]"},{"name":"func","type":"bytes[]"}]}
+]`
+
+var account, _ = abi.JSON(strings.NewReader(accountABI))
+
+// Call is an inner call made by the account when executing the user operation.
+type Call struct {
+	Target   common.Address
+	Selector []byte
+}
+
+// decodeCalls extracts the inner calls of the well known account execute functions.
+// ok is false when the callData is not a recognized execute call.
+func decodeCalls(callData []byte) (calls []Call, ok bool) {
+	if len(callData) < 4 {
+		return nil, false
+	}
+	method, err := account.MethodById(callData[:4])
+	if err != nil {
+		return nil, false
+	}
+	args, err := method.Inputs.Unpack(callData[4:])
+	if err != nil {
+		return nil, false
+	}
+
+	switch method.Sig {
+	case "execute(address,uint256,bytes)":
+		target, _ := args[0].(common.Address)
+		data, _ := args[2].([]byte)
+		return []Call{{Target: target, Selector: selector(data)}}, true
+	case "executeBatch(address[],bytes[])", "executeBatch(address[],uint256[],bytes[])":
+		targets, _ := args[0].([]common.Address)
+		datas, _ := args[len(args)-1].([][]byte)
+		calls = make([]Call, len(targets))
+		for i, target := range targets {
+			calls[i].Target = target
+			if i < len(datas) {
+				calls[i].Selector = selector(datas[i])
+			}
+		}
+		return calls, true
+	}
+	return nil, false
+}
+
+func selector(data []byte) []byte {
+	if len(data) < 4 {
+		return nil
+	}
+	return append([]byte{}, data[:4]...)
+}
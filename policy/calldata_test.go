@@ -0,0 +1,73 @@
+package policy
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var (
+	target1   = common.HexToAddress("0x9406cc6185a346906296840746125a0e44976454")
+	target2   = common.HexToAddress(denied)
+	transfer  = hexutil.MustDecode("0xa9059cbb")
+	approve   = hexutil.MustDecode("0x095ea7b3")
+	callData1 = append(append([]byte{}, transfer...), make([]byte, 64)...)
+	callData2 = append(append([]byte{}, approve...), make([]byte, 64)...)
+)
+
+func packAccountCall(t *testing.T, name string, args ...interface{}) []byte {
+	data, err := account.Pack(name, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeCalls(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		callData []byte
+		selector string
+		calls    []Call
+	}{
+		{
+			"execute",
+			packAccountCall(t, "execute", target1, big.NewInt(1), callData1),
+			"0xb61d27f6",
+			[]Call{{Target: target1, Selector: transfer}},
+		},
+		{
+			"v0.6 executeBatch",
+			packAccountCall(t, "executeBatch", []common.Address{target1, target2}, [][]byte{callData1, callData2}),
+			"0x18dfb3c7",
+			[]Call{{Target: target1, Selector: transfer}, {Target: target2, Selector: approve}},
+		},
+		{
+			"v0.7 executeBatch",
+			packAccountCall(t, "executeBatch0", []common.Address{target1, target2}, []*big.Int{big.NewInt(0), big.NewInt(1)}, [][]byte{callData1, {}}),
+			"0x47e1da2a",
+			[]Call{{Target: target1, Selector: transfer}, {Target: target2}},
+		},
+	} {
+		if selector := hexutil.Encode(tc.callData[:4]); selector != tc.selector {
+			t.Errorf("%s selector %s, expected %s", tc.name, selector, tc.selector)
+		}
+		calls, ok := decodeCalls(tc.callData)
+		if !ok || len(calls) != len(tc.calls) {
+			t.Errorf("%s decodes to %v, %t", tc.name, calls, ok)
+			continue
+		}
+		for i, call := range calls {
+			if call.Target != tc.calls[i].Target || !bytes.Equal(call.Selector, tc.calls[i].Selector) {
+				t.Errorf("%s call %d is %+v, expected %+v", tc.name, i, call, tc.calls[i])
+			}
+		}
+	}
+
+	if _, ok := decodeCalls(hexutil.MustDecode("0xdeadbeef00")); ok {
+		t.Error("unknown call data is decoded")
+	}
+}
@@ -0,0 +1,90 @@
+package policy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// Engine holds the enabled policy rules grouped by api key and reloads them from the database.
+type Engine struct {
+	rep   db.Repository
+	mu    sync.RWMutex
+	rules map[uint][]*Rule
+}
+
+func NewEngine(rep db.Repository) *Engine {
+	return &Engine{
+		rep:   rep,
+		rules: make(map[uint][]*Rule),
+	}
+}
+
+// Reload replaces the rule set with the enabled rules in the database. An invalid rule fails the
+// reload and the previous rule set is kept, so a broken deny rule never lifts a restriction.
+func (e *Engine) Reload() error {
+	recs, err := (&models.PolicyRule{}).FindEnabled(e.rep)
+	if err != nil {
+		return err
+	}
+
+	rules := make(map[uint][]*Rule)
+	for i := range recs {
+		rule, err := NewRule(&recs[i])
+		if err != nil {
+			return err
+		}
+		rules[recs[i].ApiKeyID] = append(rules[recs[i].ApiKeyID], rule)
+	}
+
+	e.mu.Lock()
+	e.rules = rules
+	e.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the rules every interval until stop is closed.
+func (e *Engine) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := e.Reload(); err != nil {
+				logger.S().Errorf("reload policy rules error: %v", err)
+			}
+		}
+	}
+}
+
+// Evaluate checks op against the global rules and the rules of the api key without policy and
+// of policyID, returning the rejection of the first failed rule. A policyID without rules is rejected.
+func (e *Engine) Evaluate(apiKeyID uint, policyID string, op *Operation) error {
+	e.mu.RLock()
+	rules := append(append([]*Rule{}, e.rules[0]...), e.rules[apiKeyID]...)
+	e.mu.RUnlock()
+
+	found := false
+	for _, rule := range rules {
+		if rule.PolicyID != "" && rule.PolicyID != policyID {
+			continue
+		}
+		found = found || rule.PolicyID != ""
+		if err := rule.Check(op); err != nil {
+			return err
+		}
+	}
+	if policyID != "" && !found {
+		return errors.NewRPCError(errors.INVALID_FIELDS, fmt.Sprintf("Unknown policy %s", policyID), map[string]string{
+			"policyId": policyID,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package policy
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+const (
+	KindSender       = "sender"
+	KindTarget       = "target"
+	KindSelector     = "selector"
+	KindFactory      = "factory"
+	KindMaxGas       = "max_gas"
+	KindMaxFeePerGas = "max_fee_per_gas"
+
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// Operation is the part of a user operation the rules are evaluated against.
+type Operation struct {
+	Sender       common.Address
+	Factory      common.Address
+	CallData     []byte
+	TotalGas     *big.Int
+	MaxFeePerGas *big.Int
+}
+
+// Rule is a parsed models.PolicyRule.
+type Rule struct {
+	Name string
+	// PolicyID is the policy the rule belongs to, empty for rules of every sponsorship.
+	PolicyID string
+	Kind     string
+	Action   string
+	values   map[string]struct{}
+	limit    *big.Int
+}
+
+func NewRule(rec *models.PolicyRule) (*Rule, error) {
+	rule := &Rule{
+		Name:     rec.Name,
+		PolicyID: rec.PolicyID,
+		Kind:     rec.Kind,
+		Action:   rec.Action,
+		values:   make(map[string]struct{}),
+	}
+	if rule.Name == "" {
+		rule.Name = fmt.Sprintf("%s#%d", rec.Kind, rec.ID)
+	}
+
+	switch rec.Kind {
+	case KindSender, KindTarget, KindFactory, KindSelector:
+		if rec.Action != ActionAllow && rec.Action != ActionDeny {
+			return nil, fmt.Errorf("policy rule %s: invalid action %q", rule.Name, rec.Action)
+		}
+		for _, value := range strings.Split(rec.Values, ",") {
+			value = strings.ToLower(strings.TrimSpace(value))
+			if value == "" {
+				continue
+			}
+			if rec.Kind == KindSelector {
+				if b, err := hexutil.Decode(value); err != nil || len(b) != 4 {
+					return nil, fmt.Errorf("policy rule %s: invalid selector %q", rule.Name, value)
+				}
+			} else if !common.IsHexAddress(value) {
+				return nil, fmt.Errorf("policy rule %s: invalid address %q", rule.Name, value)
+			}
+			rule.values[value] = struct{}{}
+		}
+	case KindMaxGas, KindMaxFeePerGas:
+		limit, ok := new(big.Int).SetString(strings.TrimSpace(rec.Values), 0)
+		if !ok || limit.Sign() < 0 {
+			return nil, fmt.Errorf("policy rule %s: invalid limit %q", rule.Name, rec.Values)
+		}
+		rule.limit = limit
+	default:
+		return nil, fmt.Errorf("policy rule %s: unknown kind %q", rule.Name, rec.Kind)
+	}
+	return rule, nil
+}
+
+// Check returns a rejection error if op violates the rule.
+func (r *Rule) Check(op *Operation) error {
+	switch r.Kind {
+	case KindSender:
+		return r.checkValues([]string{addressKey(op.Sender)})
+	case KindFactory:
+		if op.Factory == (common.Address{}) {
+			return nil
+		}
+		return r.checkValues([]string{addressKey(op.Factory)})
+	case KindTarget:
+		calls, ok := decodeCalls(op.CallData)
+		if !ok {
+			// a target hidden in unknown call data could be a denied one
+			if len(op.CallData) == 0 {
+				return nil
+			}
+			return r.reject("call target can not be decoded")
+		}
+		targets := make([]string, len(calls))
+		for i, call := range calls {
+			targets[i] = addressKey(call.Target)
+		}
+		return r.checkValues(targets)
+	case KindSelector:
+		if len(op.CallData) == 0 {
+			return nil
+		}
+		var selectors []string
+		if calls, ok := decodeCalls(op.CallData); ok {
+			for _, call := range calls {
+				if call.Selector != nil {
+					selectors = append(selectors, hexutil.Encode(call.Selector))
+				} else if r.Action == ActionAllow {
+					// a call without selector, e.g. a value transfer, matches no allowed selector
+					return r.reject("call without selector is not allowed")
+				}
+			}
+		} else if s := selector(op.CallData); s != nil {
+			selectors = append(selectors, hexutil.Encode(s))
+		} else if r.Action == ActionAllow {
+			return r.reject("call without selector is not allowed")
+		}
+		return r.checkValues(selectors)
+	case KindMaxGas:
+		if op.TotalGas != nil && op.TotalGas.Cmp(r.limit) > 0 {
+			return r.reject(fmt.Sprintf("gas %s exceeds %s", op.TotalGas, r.limit))
+		}
+	case KindMaxFeePerGas:
+		if op.MaxFeePerGas != nil && op.MaxFeePerGas.Cmp(r.limit) > 0 {
+			return r.reject(fmt.Sprintf("maxFeePerGas %s exceeds %s", op.MaxFeePerGas, r.limit))
+		}
+	}
+	return nil
+}
+
+func (r *Rule) checkValues(values []string) error {
+	for _, value := range values {
+		_, found := r.values[value]
+		if r.Action == ActionDeny && found {
+			return r.reject(fmt.Sprintf("%s %s is denied", r.Kind, value))
+		}
+		if r.Action == ActionAllow && !found {
+			return r.reject(fmt.Sprintf("%s %s is not allowed", r.Kind, value))
+		}
+	}
+	return nil
+}
+
+func (r *Rule) reject(reason string) error {
+	return errors.NewRPCError(
+		errors.REJECTED_BY_PAYMASTER,
+		fmt.Sprintf("rejected by policy rule %s: %s", r.Name, reason),
+		map[string]string{
+			"rule":   r.Name,
+			"kind":   r.Kind,
+			"reason": reason,
+		},
+	)
+}
+
+func addressKey(addr common.Address) string {
+	return strings.ToLower(addr.Hex())
+}
@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+const denied = "0x1306b01bc3e4ad202612d3843387e94737673f53"
+
+func TestTargetRuleRejectsUndecodableCallData(t *testing.T) {
+	for _, action := range []string{ActionAllow, ActionDeny} {
+		rule, err := NewRule(&models.PolicyRule{Name: action, Kind: KindTarget, Action: action, Values: denied})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rule.Check(&Operation{CallData: hexutil.MustDecode("0xdeadbeef00")}); err == nil {
+			t.Errorf("%s target rule accepts undecodable call data", action)
+		}
+		if err := rule.Check(&Operation{}); err != nil {
+			t.Errorf("%s target rule rejects empty call data: %v", action, err)
+		}
+	}
+}
+
+func TestTargetDenyRule(t *testing.T) {
+	rule, err := NewRule(&models.PolicyRule{Kind: KindTarget, Action: ActionDeny, Values: denied})
+	if err != nil {
+		t.Fatal(err)
+	}
+	execute := func(target string) []byte {
+		data, err := account.Pack("execute", common.HexToAddress(target), common.Big0, []byte{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	if err := rule.Check(&Operation{CallData: execute(denied)}); err == nil {
+		t.Error("denied target is accepted")
+	}
+	if err := rule.Check(&Operation{CallData: execute("0x9406cc6185a346906296840746125a0e44976454")}); err != nil {
+		t.Errorf("other target is rejected: %v", err)
+	}
+}
+
+func TestNewRuleRejectsInvalidValues(t *testing.T) {
+	for _, rec := range []models.PolicyRule{
+		{Kind: KindTarget, Action: ActionDeny, Values: "0x1234"},
+		{Kind: KindSelector, Action: ActionDeny, Values: "0x12"},
+		{Kind: KindSender, Action: "block", Values: denied},
+		{Kind: KindMaxGas, Values: "-1"},
+		{Kind: "unknown"},
+	} {
+		if _, err := NewRule(&rec); err == nil {
+			t.Errorf("rule %+v is accepted", rec)
+		}
+	}
+}
+
+func TestSelectorAllowRule(t *testing.T) {
+	rule, err := NewRule(&models.PolicyRule{Kind: KindSelector, Action: ActionAllow, Values: hexutil.Encode(transfer)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name     string
+		callData []byte
+		allowed  bool
+	}{
+		{"allowed selector", packAccountCall(t, "execute", target1, common.Big0, callData1), true},
+		{"other selector", packAccountCall(t, "execute", target1, common.Big0, callData2), false},
+		{"value transfer", packAccountCall(t, "execute", target1, big.NewInt(1), []byte{}), false},
+		{"v0.7 batch", packAccountCall(t, "executeBatch0", []common.Address{target1, target2}, []*big.Int{common.Big0, common.Big0}, [][]byte{callData1, callData1}), true},
+		{"v0.7 batch with value transfer", packAccountCall(t, "executeBatch0", []common.Address{target1, target2}, []*big.Int{common.Big0, common.Big1}, [][]byte{callData1, {}}), false},
+		{"no call", nil, true},
+	} {
+		err := rule.Check(&Operation{CallData: tc.callData})
+		if tc.allowed && err != nil {
+			t.Errorf("%s is rejected: %v", tc.name, err)
+		}
+		if !tc.allowed && err == nil {
+			t.Errorf("%s is allowed", tc.name)
+		}
+	}
+
+	// a deny rule has nothing to deny in a value transfer
+	rule, err = NewRule(&models.PolicyRule{Kind: KindSelector, Action: ActionDeny, Values: hexutil.Encode(transfer)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rule.Check(&Operation{CallData: packAccountCall(t, "execute", target1, big.NewInt(1), []byte{})}); err != nil {
+		t.Errorf("value transfer is rejected by a deny rule: %v", err)
+	}
+}
+
+func TestTargetRuleV07Batch(t *testing.T) {
+	rule, err := NewRule(&models.PolicyRule{Kind: KindTarget, Action: ActionDeny, Values: denied})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := packAccountCall(t, "executeBatch0", []common.Address{target1, target2}, []*big.Int{common.Big0, common.Big0}, [][]byte{callData1, callData2})
+	if err := rule.Check(&Operation{CallData: batch}); err == nil {
+		t.Error("denied target in a v0.7 batch is accepted")
+	}
+}
@@ -0,0 +1,152 @@
+package quota
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+const (
+	// Fixed grants the allowance again every day at the reset time.
+	Fixed = "fixed"
+	// Rolling24h allows the allowance to be spent in any 24 hours.
+	Rolling24h = "rolling_24h"
+	// Rolling7d allows the allowance to be spent in any 7 days.
+	Rolling7d = "rolling_7d"
+	// Lifetime grants the allowance once, when the account is created.
+	Lifetime = "lifetime"
+)
+
+// Window decides how the allowance of a sender account is granted over time.
+type Window interface {
+	// Refill brings the remain gas of the account up to date at now, reporting whether it changed.
+	// The account row must be locked by tx if the change is saved.
+	Refill(tx db.Repository, account *models.Account, allowance *big.Int, now time.Time) (bool, error)
+}
+
+// Windows resolves the window selected by an api key, falling back to the default window.
+type Windows struct {
+	windows map[string]Window
+	def     Window
+	fixed   *fixedWindow
+}
+
+// NewWindows creates the windows with the fixed window resetting at resetTime,
+// a "15:04" UTC time, and def used by api keys without a window.
+func NewWindows(def string, resetTime string) (*Windows, error) {
+	reset, err := time.Parse("15:04", resetTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quota reset time %s: %v", resetTime, err)
+	}
+	fixed := &fixedWindow{offset: time.Duration(reset.Hour())*time.Hour + time.Duration(reset.Minute())*time.Minute}
+	w := &Windows{
+		windows: map[string]Window{
+			Fixed:      fixed,
+			Rolling24h: &rollingWindow{period: 24 * time.Hour},
+			Rolling7d:  &rollingWindow{period: 7 * 24 * time.Hour},
+			Lifetime:   &lifetimeWindow{},
+		},
+		fixed: fixed,
+	}
+	w.def, err = w.Get(def)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Get returns the window of name, the default window when name is empty.
+func (w *Windows) Get(name string) (Window, error) {
+	if name == "" {
+		return w.def, nil
+	}
+	window, ok := w.windows[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown quota window %s", name)
+	}
+	return window, nil
+}
+
+// DayStart returns the start of the quota day of now, the last reset of the fixed window.
+// Daily budgets and sender limits are counted from it.
+func (w *Windows) DayStart(now time.Time) time.Time {
+	return w.fixed.lastReset(now)
+}
+
+// MonthStart returns the start of the quota month of now, the reset of the fixed window on the first day
+// of the month. Monthly budgets are counted from it.
+func (w *Windows) MonthStart(now time.Time) time.Time {
+	return w.fixed.lastMonthReset(now)
+}
+
+// Valid reports whether name is a known window or empty.
+func Valid(name string) bool {
+	switch name {
+	case "", Fixed, Rolling24h, Rolling7d, Lifetime:
+		return true
+	}
+	return false
+}
+
+type fixedWindow struct {
+	offset time.Duration
+}
+
+// lastReset returns the latest reset time not after now.
+func (w *fixedWindow) lastReset(now time.Time) time.Time {
+	now = now.UTC()
+	reset := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Add(w.offset)
+	if reset.After(now) {
+		reset = reset.Add(-24 * time.Hour)
+	}
+	return reset
+}
+
+// lastMonthReset returns the latest reset time on the first day of a month not after now.
+func (w *fixedWindow) lastMonthReset(now time.Time) time.Time {
+	now = now.UTC()
+	reset := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).Add(w.offset)
+	if reset.After(now) {
+		reset = time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC).Add(w.offset)
+	}
+	return reset
+}
+
+func (w *fixedWindow) Refill(tx db.Repository, account *models.Account, allowance *big.Int, now time.Time) (bool, error) {
+	if !account.LastRequest.Before(w.lastReset(now)) {
+		return false, nil
+	}
+	account.RemainGas = allowance.String()
+	account.LastRequest = now
+	return true, nil
+}
+
+// rollingWindow computes the remain gas from the reservations of the account in the period before now.
+type rollingWindow struct {
+	period time.Duration
+}
+
+func (w *rollingWindow) Refill(tx db.Repository, account *models.Account, allowance *big.Int, now time.Time) (bool, error) {
+	used, err := (&models.Reservation{}).SumUsage(tx, account.ID, now.Add(-w.period))
+	if err != nil {
+		return false, err
+	}
+	remain := new(big.Int).Sub(allowance, used)
+	if remain.Sign() < 0 {
+		remain = new(big.Int)
+	}
+	if remain.Cmp(account.Remain()) == 0 {
+		return false, nil
+	}
+	account.RemainGas = remain.String()
+	return true, nil
+}
+
+type lifetimeWindow struct{}
+
+func (w *lifetimeWindow) Refill(tx db.Repository, account *models.Account, allowance *big.Int, now time.Time) (bool, error) {
+	return false, nil
+}
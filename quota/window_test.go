@@ -0,0 +1,41 @@
+package quota
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeriodStarts(t *testing.T) {
+	windows, err := NewWindows(Fixed, "06:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(value string) time.Time {
+		ts, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	for _, tc := range []struct {
+		now   string
+		day   string
+		month string
+	}{
+		{"2024-03-15T12:00:00Z", "2024-03-15T06:30:00Z", "2024-03-01T06:30:00Z"},
+		{"2024-03-15T06:30:00Z", "2024-03-15T06:30:00Z", "2024-03-01T06:30:00Z"},
+		{"2024-03-15T06:29:59Z", "2024-03-14T06:30:00Z", "2024-03-01T06:30:00Z"},
+		{"2024-03-01T03:00:00Z", "2024-02-29T06:30:00Z", "2024-02-01T06:30:00Z"},
+		{"2024-01-01T00:00:00Z", "2023-12-31T06:30:00Z", "2023-12-01T06:30:00Z"},
+		// the offset of the reset is applied in UTC
+		{"2024-03-01T08:00:00+03:00", "2024-02-29T06:30:00Z", "2024-02-01T06:30:00Z"},
+	} {
+		now := at(tc.now)
+		if day := windows.DayStart(now); !day.Equal(at(tc.day)) {
+			t.Errorf("day start of %s is %s, expected %s", tc.now, day, tc.day)
+		}
+		if month := windows.MonthStart(now); !month.Equal(at(tc.month)) {
+			t.Errorf("month start of %s is %s, expected %s", tc.now, month, tc.month)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ququzone/verifying-paymaster-service/auth"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/jsonrpc"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+// Limit is a token bucket refilled with Rate tokens per second up to Burst tokens.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited reports whether the limit is not set.
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// ParseLimit parses "<rate>:<burst>" or "<rate>", the burst defaulting to the rate rounded up.
+// An empty value is unlimited.
+func ParseLimit(value string) (Limit, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Limit{}, nil
+	}
+	rateValue, burstValue, hasBurst := strings.Cut(value, ":")
+	rate, err := strconv.ParseFloat(rateValue, 64)
+	if err != nil || rate < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %s", value)
+	}
+	burst := int(rate)
+	if float64(burst) < rate {
+		burst++
+	}
+	if hasBurst {
+		burst, err = strconv.Atoi(burstValue)
+		if err != nil || burst < 1 {
+			return Limit{}, fmt.Errorf("invalid rate limit %s", value)
+		}
+	}
+	return Limit{Rate: rate, Burst: burst}, nil
+}
+
+// Bucket is a token bucket of a Store.
+type Bucket struct {
+	Key   string
+	Limit Limit
+}
+
+// Store takes tokens from token buckets.
+type Store interface {
+	// Take takes one token from each of buckets if all of them have one. It returns the index of
+	// the first bucket without a token, or -1 if the tokens were taken.
+	Take(buckets ...Bucket) (int, error)
+	// Available reports whether bucket has a token, without taking it.
+	Available(bucket Bucket) (bool, error)
+}
+
+// Limits are the limits of each rate class.
+type Limits map[string]Limit
+
+// Throttle limits the calls of each rate class per api key and per client ip.
+// The limits of an api key override the default key limits.
+type Throttle struct {
+	store     Store
+	keyLimits Limits
+	ipLimits  Limits
+}
+
+func NewThrottle(store Store, keyLimits Limits, ipLimits Limits) *Throttle {
+	return &Throttle{
+		store:     store,
+		keyLimits: keyLimits,
+		ipLimits:  ipLimits,
+	}
+}
+
+// CheckIP implements jsonrpc.RateLimiter, it rejects a client ip without a token of class
+// before the caller is authenticated. The token is taken by Allow.
+func (t *Throttle) CheckIP(_ context.Context, ip string, class string) error {
+	limit := t.ipLimits[class]
+	if class == "" || ip == "" || limit.Unlimited() {
+		return nil
+	}
+	ok, err := t.store.Available(Bucket{Key: ipKey(ip, class), Limit: limit})
+	if err != nil {
+		// a failing store must not take the service down
+		logger.S().Errorf("rate limit store error: %v", err)
+		return nil
+	}
+	if !ok {
+		return limitError("ip", class, limit)
+	}
+	return nil
+}
+
+// Allow implements jsonrpc.RateLimiter. The tokens of the api key and of the client ip are
+// only taken if both of them have one.
+func (t *Throttle) Allow(ctx context.Context, ip string, class string) error {
+	if class == "" {
+		return nil
+	}
+	var (
+		buckets []Bucket
+		scopes  []string
+	)
+	if principal := auth.FromContext(ctx); principal != nil {
+		limit := t.keyLimits[class]
+		if override := principal.ApiKey.RateLimits.Get(class); override != "" {
+			var err error
+			limit, err = ParseLimit(override)
+			if err != nil {
+				logger.S().Warnf("api key %d: %v", principal.ApiKey.ID, err)
+				limit = t.keyLimits[class]
+			}
+		}
+		if !limit.Unlimited() {
+			buckets = append(buckets, Bucket{Key: fmt.Sprintf("key:%d:%s", principal.ApiKey.ID, class), Limit: limit})
+			scopes = append(scopes, "apiKey")
+		}
+	}
+	if limit := t.ipLimits[class]; ip != "" && !limit.Unlimited() {
+		buckets = append(buckets, Bucket{Key: ipKey(ip, class), Limit: limit})
+		scopes = append(scopes, "ip")
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+	exhausted, err := t.store.Take(buckets...)
+	if err != nil {
+		// a failing store must not take the service down
+		logger.S().Errorf("rate limit store error: %v", err)
+		return nil
+	}
+	if exhausted >= 0 {
+		return limitError(scopes[exhausted], class, buckets[exhausted].Limit)
+	}
+	return nil
+}
+
+func ipKey(ip string, class string) string {
+	return fmt.Sprintf("ip:%s:%s", ip, class)
+}
+
+func limitError(scope string, class string, limit Limit) error {
+	return errors.NewRPCError(errors.LIMIT_EXCEEDED, "Limit exceeded", map[string]any{
+		"scope": scope,
+		"class": class,
+		"rate":  limit.Rate,
+		"burst": limit.Burst,
+	})
+}
+
+var _ jsonrpc.RateLimiter = (*Throttle)(nil)
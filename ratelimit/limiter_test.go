@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/auth"
+	"github.com/ququzone/verifying-paymaster-service/errors"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.InitLogger(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func keyContext(id uint) context.Context {
+	apiKey := &models.ApiKeys{}
+	apiKey.ID = id
+	return auth.NewContext(context.Background(), &auth.Principal{ApiKey: apiKey})
+}
+
+func limitScope(t *testing.T, err error) string {
+	t.Helper()
+	rpcErr, ok := err.(*errors.RPCError)
+	if !ok || rpcErr.Code() != errors.LIMIT_EXCEEDED {
+		t.Fatalf("error %v is not a limit error", err)
+	}
+	return rpcErr.Data().(map[string]any)["scope"].(string)
+}
+
+func TestParseLimit(t *testing.T) {
+	for value, want := range map[string]Limit{
+		"":      {},
+		"2":     {Rate: 2, Burst: 2},
+		"0.5":   {Rate: 0.5, Burst: 1},
+		"2:10":  {Rate: 2, Burst: 10},
+		" 1:3 ": {Rate: 1, Burst: 3},
+	} {
+		limit, err := ParseLimit(value)
+		if err != nil || limit != want {
+			t.Errorf("ParseLimit(%q) = %v, %v, expected %v", value, limit, err, want)
+		}
+	}
+	for _, value := range []string{"a", "-1", "1:0", "1:a"} {
+		if _, err := ParseLimit(value); err == nil {
+			t.Errorf("ParseLimit(%q) is valid", value)
+		}
+	}
+}
+
+func TestAllowTakesNoTokenOverALimit(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	throttle := NewThrottle(store, Limits{"sponsor": {Rate: 0.001, Burst: 2}}, Limits{"sponsor": {Rate: 0.001, Burst: 1}})
+
+	if err := throttle.Allow(keyContext(1), "192.0.2.1", "sponsor"); err != nil {
+		t.Fatal(err)
+	}
+	// the ip is over its limit, the token of the key is kept
+	if scope := limitScope(t, throttle.Allow(keyContext(1), "192.0.2.1", "sponsor")); scope != "ip" {
+		t.Fatalf("rejected by the %s limit", scope)
+	}
+	if err := throttle.Allow(keyContext(1), "192.0.2.2", "sponsor"); err != nil {
+		t.Fatal(err)
+	}
+	// the key is over its limit, the token of the ip is kept
+	if scope := limitScope(t, throttle.Allow(keyContext(1), "192.0.2.3", "sponsor")); scope != "apiKey" {
+		t.Fatalf("rejected by the %s limit", scope)
+	}
+	if err := throttle.Allow(keyContext(2), "192.0.2.3", "sponsor"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllowUnlimited(t *testing.T) {
+	throttle := NewThrottle(NewMemoryStore(time.Hour), Limits{}, Limits{"sponsor": {Rate: 0.001, Burst: 1}})
+	for i := 0; i < 3; i++ {
+		if err := throttle.Allow(keyContext(1), "192.0.2.1", "read"); err != nil {
+			t.Fatal(err)
+		}
+		if err := throttle.Allow(keyContext(1), "", "sponsor"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckIPTakesNoToken(t *testing.T) {
+	throttle := NewThrottle(NewMemoryStore(time.Hour), Limits{}, Limits{"sponsor": {Rate: 0.001, Burst: 1}})
+	for i := 0; i < 3; i++ {
+		if err := throttle.CheckIP(context.Background(), "192.0.2.1", "sponsor"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := throttle.Allow(context.Background(), "192.0.2.1", "sponsor"); err != nil {
+		t.Fatal(err)
+	}
+	if scope := limitScope(t, throttle.CheckIP(context.Background(), "192.0.2.1", "sponsor")); scope != "ip" {
+		t.Fatalf("rejected by the %s limit", scope)
+	}
+	if err := throttle.CheckIP(context.Background(), "192.0.2.2", "sponsor"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// refilled returns the tokens of the bucket at now.
+func (b *bucket) refilled(now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*b.limit.Rate
+	if tokens > float64(b.limit.Burst) {
+		tokens = float64(b.limit.Burst)
+	}
+	return tokens
+}
+
+// MemoryStore keeps the token buckets in memory, for a single replica.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewMemoryStore creates the store, dropping refilled buckets every interval.
+func NewMemoryStore(interval time.Duration) *MemoryStore {
+	s := &MemoryStore{buckets: make(map[string]*bucket)}
+	go s.gc(interval)
+	return s
+}
+
+func (s *MemoryStore) Take(buckets ...Bucket) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	refilled := make([]*bucket, len(buckets))
+	for i, bk := range buckets {
+		b, ok := s.buckets[bk.Key]
+		if !ok {
+			b = &bucket{tokens: float64(bk.Limit.Burst), last: now}
+			s.buckets[bk.Key] = b
+		}
+		b.limit = bk.Limit
+		b.tokens = b.refilled(now)
+		b.last = now
+		if b.tokens < 1 {
+			return i, nil
+		}
+		refilled[i] = b
+	}
+	for _, b := range refilled {
+		b.tokens--
+	}
+	return -1, nil
+}
+
+func (s *MemoryStore) Available(bk Bucket) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[bk.Key]
+	if !ok {
+		return bk.Limit.Burst >= 1, nil
+	}
+	refilled := *b
+	refilled.limit = bk.Limit
+	return refilled.refilled(time.Now()) >= 1, nil
+}
+
+// gc drops the buckets which are refilled to their burst, taking from a new bucket is the same.
+func (s *MemoryStore) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.evict(time.Now())
+	}
+}
+
+func (s *MemoryStore) evict(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, b := range s.buckets {
+		if b.refilled(now) >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
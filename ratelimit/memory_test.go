@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	s := NewMemoryStore(time.Hour)
+	a := Bucket{Key: "a", Limit: Limit{Rate: 0.001, Burst: 2}}
+	b := Bucket{Key: "b", Limit: Limit{Rate: 0.001, Burst: 1}}
+
+	if exhausted, _ := s.Take(a, b); exhausted != -1 {
+		t.Fatalf("bucket %d exhausted", exhausted)
+	}
+	if exhausted, _ := s.Take(a, b); exhausted != 1 {
+		t.Fatalf("bucket %d exhausted, expected 1", exhausted)
+	}
+	// the token of a was not taken by the rejected take
+	if exhausted, _ := s.Take(a); exhausted != -1 {
+		t.Fatalf("bucket %d exhausted", exhausted)
+	}
+	if exhausted, _ := s.Take(a); exhausted != 0 {
+		t.Fatalf("bucket %d exhausted, expected 0", exhausted)
+	}
+	if ok, _ := s.Available(a); ok {
+		t.Fatal("empty bucket is available")
+	}
+	if ok, _ := s.Available(Bucket{Key: "c", Limit: Limit{Rate: 1, Burst: 1}}); !ok {
+		t.Fatal("new bucket is not available")
+	}
+}
+
+func TestMemoryStoreEvictsRefilledBuckets(t *testing.T) {
+	s := NewMemoryStore(time.Hour)
+	fast := Bucket{Key: "fast", Limit: Limit{Rate: 10, Burst: 1}}
+	slow := Bucket{Key: "slow", Limit: Limit{Rate: 0.01, Burst: 1}}
+	s.Take(fast)
+	s.Take(slow)
+
+	// after a second the fast bucket is refilled, the slow one needs 100 seconds
+	s.evict(time.Now().Add(time.Second))
+	if _, ok := s.buckets["fast"]; ok {
+		t.Fatal("refilled bucket is kept")
+	}
+	if _, ok := s.buckets["slow"]; !ok {
+		t.Fatal("bucket which is not refilled is dropped")
+	}
+	if ok, _ := s.Available(slow); ok {
+		t.Fatal("slow bucket was refilled")
+	}
+	s.evict(time.Now().Add(100 * time.Second))
+	if _, ok := s.buckets["slow"]; ok {
+		t.Fatal("refilled bucket is kept")
+	}
+}
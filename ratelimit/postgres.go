@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/logger"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+var errNoToken = errors.New("no token")
+
+// PostgresStore keeps the token buckets in the rate_buckets table, shared by all replicas.
+type PostgresStore struct {
+	rep db.Repository
+}
+
+// NewPostgresStore creates the store, deleting refilled buckets every interval.
+func NewPostgresStore(rep db.Repository, interval time.Duration) *PostgresStore {
+	s := &PostgresStore{rep: rep}
+	go s.gc(interval)
+	return s
+}
+
+// Take takes the tokens in a transaction, which is rolled back if a bucket has no token.
+// The buckets are updated in key order so concurrent takes don't deadlock.
+func (s *PostgresStore) Take(buckets ...Bucket) (int, error) {
+	order := make([]int, len(buckets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return buckets[order[i]].Key < buckets[order[j]].Key
+	})
+
+	exhausted := -1
+	err := s.rep.Transaction(func(tx db.Repository) error {
+		for _, i := range order {
+			ok, err := (&models.RateBucket{}).Take(tx, buckets[i].Key, buckets[i].Limit.Rate, buckets[i].Limit.Burst)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				exhausted = i
+				return errNoToken
+			}
+		}
+		return nil
+	})
+	if err == errNoToken {
+		return exhausted, nil
+	}
+	return -1, err
+}
+
+func (s *PostgresStore) Available(bucket Bucket) (bool, error) {
+	return (&models.RateBucket{}).Available(s.rep, bucket.Key, bucket.Limit.Rate, bucket.Limit.Burst)
+}
+
+// gc deletes the buckets which are refilled to their burst, the rows of keys and ips seen once would be kept forever.
+func (s *PostgresStore) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := (&models.RateBucket{}).DeleteRefilled(s.rep); err != nil {
+			logger.S().Errorf("Delete refilled rate buckets error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ququzone/verifying-paymaster-service/db"
+	"github.com/ququzone/verifying-paymaster-service/models"
+)
+
+// testRepository connects to the postgres database of TEST_DATABASE_DSN and migrates it,
+// the test is skipped without it.
+func testRepository(t *testing.T) db.Repository {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	rep, err := db.Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rep.Close() })
+	if err := rep.AutoMigrate(&models.RateBucket{}); err != nil {
+		t.Fatal(err)
+	}
+	return rep
+}
+
+func TestPostgresStoreDeletesRefilledBuckets(t *testing.T) {
+	rep := testRepository(t)
+	s := &PostgresStore{rep: rep}
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	fast := Bucket{Key: "test:fast:" + suffix, Limit: Limit{Rate: 1000, Burst: 1}}
+	slow := Bucket{Key: "test:slow:" + suffix, Limit: Limit{Rate: 0.001, Burst: 1}}
+	if exhausted, err := s.Take(fast, slow); err != nil || exhausted != -1 {
+		t.Fatalf("take: %d, %v", exhausted, err)
+	}
+
+	// the fast bucket is refilled after a millisecond, the slow one after 1000 seconds
+	time.Sleep(10 * time.Millisecond)
+	if _, err := (&models.RateBucket{}).DeleteRefilled(rep); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	if err := rep.Model(&models.RateBucket{}).Where(`"key" IN ?`, []string{fast.Key, slow.Key}).Pluck("key", &keys).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != slow.Key {
+		t.Fatalf("buckets %v are kept, expected only %s", keys, slow.Key)
+	}
+	if ok, err := s.Available(slow); err != nil || ok {
+		t.Fatalf("slow bucket is available: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package signer
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Keyring holds the keys of the verifying signers, a paymaster is signed by the key of its verifying signer.
+type Keyring struct {
+	signers map[common.Address]Signer
+}
+
+func NewKeyring(signers ...Signer) (*Keyring, error) {
+	keyring := &Keyring{signers: make(map[common.Address]Signer)}
+	for _, signer := range signers {
+		if _, ok := keyring.signers[signer.Address()]; ok {
+			return nil, fmt.Errorf("duplicate key %s", signer.Address())
+		}
+		keyring.signers[signer.Address()] = signer
+	}
+	return keyring, nil
+}
+
+// Get returns the key of address, nil if it is not held.
+func (k *Keyring) Get(address common.Address) Signer {
+	return k.signers[address]
+}
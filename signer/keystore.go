@@ -0,0 +1,43 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"os"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ququzone/verifying-paymaster-service/utils"
+)
+
+// Local signs with a private key held in memory.
+type Local struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+func NewLocal(key *ecdsa.PrivateKey) *Local {
+	return &Local{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+// NewKeystore decrypts the key of the keystore file with passphrase.
+func NewKeystore(file string, passphrase string) (*Local, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keystore.DecryptKey(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return NewLocal(key.PrivateKey), nil
+}
+
+func (l *Local) Address() common.Address {
+	return l.address
+}
+
+func (l *Local) SignMessage(message []byte) ([]byte, error) {
+	return utils.SignMessage(l.key, message)
+}
@@ -0,0 +1,73 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Token is the part of a PKCS#11 session, or of a KMS client, used to sign. The key never
+// leaves the token, which signs raw secp256k1 digests as with CKM_ECDSA.
+type Token interface {
+	// PublicKey returns the public key of the signing key object.
+	PublicKey() (*ecdsa.PublicKey, error)
+	// SignDigest returns the 64 bytes r || s signature of the 32 bytes digest.
+	SignDigest(digest []byte) ([]byte, error)
+}
+
+var (
+	secp256k1N     = crypto.S256().Params().N
+	secp256k1HalfN = new(big.Int).Rsh(secp256k1N, 1)
+)
+
+// HSM signs with the key object of a Token.
+type HSM struct {
+	token   Token
+	address common.Address
+}
+
+func NewHSM(token Token) (*HSM, error) {
+	pub, err := token.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return &HSM{token: token, address: crypto.PubkeyToAddress(*pub)}, nil
+}
+
+func (h *HSM) Address() common.Address {
+	return h.address
+}
+
+// SignMessage signs the EIP-191 hash of message with the token. Tokens do not return the
+// recovery id, so s is normalized to the lower half of the curve order and v is found by recovery.
+func (h *HSM) SignMessage(message []byte) ([]byte, error) {
+	hash := accounts.TextHash(message)
+	rs, err := h.token.SignDigest(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(rs) != 64 {
+		return nil, errors.New("invalid token signature length")
+	}
+	s := new(big.Int).SetBytes(rs[32:])
+	if s.Cmp(secp256k1HalfN) > 0 {
+		s.Sub(secp256k1N, s)
+	}
+
+	signature := make([]byte, crypto.SignatureLength)
+	copy(signature[:32], rs[:32])
+	s.FillBytes(signature[32:64])
+	for v := byte(0); v < 2; v++ {
+		signature[crypto.RecoveryIDOffset] = v
+		pub, err := crypto.SigToPub(hash, signature)
+		if err == nil && crypto.PubkeyToAddress(*pub) == h.address {
+			signature[crypto.RecoveryIDOffset] += 27
+			return signature, nil
+		}
+	}
+	return nil, errors.New("token signature does not recover to the token address")
+}
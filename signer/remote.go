@@ -0,0 +1,62 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Methods of remote signers.
+const (
+	// MethodEthSign is eth_sign(address, data) of web3signer and of nodes with unlocked accounts.
+	MethodEthSign = "eth_sign"
+	// MethodClef is account_signData("text/plain", address, data) of Clef.
+	MethodClef = "account_signData"
+)
+
+const remoteTimeout = 10 * time.Second
+
+// Remote signs through the JSON-RPC api of an external signer, the key never enters the service.
+type Remote struct {
+	client  *rpc.Client
+	method  string
+	address common.Address
+}
+
+// NewRemote connects to the signer at url which signs with the key of address by method.
+func NewRemote(url string, method string, address common.Address) (*Remote, error) {
+	if method != MethodEthSign && method != MethodClef {
+		return nil, fmt.Errorf("unknown remote signer method %s", method)
+	}
+	client, err := rpc.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	return &Remote{client: client, method: method, address: address}, nil
+}
+
+func (r *Remote) Address() common.Address {
+	return r.address
+}
+
+func (r *Remote) SignMessage(message []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	var signature hexutil.Bytes
+	var err error
+	if r.method == MethodClef {
+		err = r.client.CallContext(ctx, &signature, r.method, "text/plain", r.address, hexutil.Bytes(message))
+	} else {
+		err = r.client.CallContext(ctx, &signature, r.method, r.address, hexutil.Bytes(message))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("remote signer: %v", err)
+	}
+	// the signature is checked so a misconfigured signer can not hand out invalid sponsorships
+	return verify(r.address, message, signature)
+}
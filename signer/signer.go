@@ -0,0 +1,43 @@
+// Package signer provides the backends holding the verifying signer key of the paymaster.
+package signer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Signer signs paymaster hashes with the verifying signer key.
+type Signer interface {
+	// Address returns the address of the key.
+	Address() common.Address
+	// SignMessage returns the EIP-191 personal signature of message, with v 27 or 28.
+	SignMessage(message []byte) ([]byte, error)
+}
+
+// verify normalizes v of signature to 27 or 28 and checks that it recovers to address.
+func verify(address common.Address, message []byte, signature []byte) ([]byte, error) {
+	if len(signature) != crypto.SignatureLength {
+		return nil, fmt.Errorf("invalid signature length %d", len(signature))
+	}
+	sig := make([]byte, len(signature))
+	copy(sig, signature)
+	if sig[crypto.RecoveryIDOffset] < 27 {
+		sig[crypto.RecoveryIDOffset] += 27
+	}
+
+	recoverable := make([]byte, len(sig))
+	copy(recoverable, sig)
+	recoverable[crypto.RecoveryIDOffset] -= 27
+	pub, err := crypto.SigToPub(accounts.TextHash(message), recoverable)
+	if err != nil {
+		return nil, err
+	}
+	if crypto.PubkeyToAddress(*pub) != address {
+		return nil, errors.New("signature does not recover to the signer address")
+	}
+	return sig, nil
+}
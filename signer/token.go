@@ -0,0 +1,36 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// secp256k1OID is the named curve of the CKA_EC_PARAMS of secp256k1 keys.
+var secp256k1OID = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+
+// PKCS11Config locates the key objects labeled KeyLabel in the token labeled TokenLabel of the PKCS#11 Module.
+type PKCS11Config struct {
+	Module     string
+	TokenLabel string
+	PIN        string
+	KeyLabel   string
+}
+
+// parsePublicKey parses the DER named curve and the DER octet string of the uncompressed point of a secp256k1 key.
+func parsePublicKey(params []byte, point []byte) (*ecdsa.PublicKey, error) {
+	var curve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &curve); err != nil {
+		return nil, fmt.Errorf("parse key curve: %v", err)
+	}
+	if !curve.Equal(secp256k1OID) {
+		return nil, fmt.Errorf("key curve %s is not secp256k1", curve)
+	}
+	var raw []byte
+	if _, err := asn1.Unmarshal(point, &raw); err != nil {
+		return nil, fmt.Errorf("parse key point: %v", err)
+	}
+	return crypto.UnmarshalPubkey(raw)
+}
@@ -0,0 +1,11 @@
+//go:build !pkcs11
+// +build !pkcs11
+
+package signer
+
+import "errors"
+
+// OpenPKCS11 fails in builds without the pkcs11 tag, the PKCS#11 backend loads the module with cgo.
+func OpenPKCS11(conf PKCS11Config) (Token, error) {
+	return nil, errors.New("pkcs11 signer is not supported by this build, build with cgo and -tags pkcs11")
+}
@@ -0,0 +1,137 @@
+//go:build pkcs11
+// +build pkcs11
+
+package signer
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS11Token is a Token signing with a secp256k1 key object in a logged in PKCS#11 session.
+type PKCS11Token struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	private pkcs11.ObjectHandle
+	public  pkcs11.ObjectHandle
+
+	// a session runs one signing operation at a time
+	mu sync.Mutex
+}
+
+// OpenPKCS11 loads the module and logs in to the token of conf.
+func OpenPKCS11(conf PKCS11Config) (Token, error) {
+	ctx := pkcs11.New(conf.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("load pkcs11 module %s", conf.Module)
+	}
+	if err := ctx.Initialize(); err != nil && !isPKCS11Error(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("initialize pkcs11 module %s: %v", conf.Module, err)
+	}
+	token, err := openToken(ctx, conf)
+	if err != nil {
+		ctx.Destroy()
+		return nil, err
+	}
+	return token, nil
+}
+
+func openToken(ctx *pkcs11.Ctx, conf PKCS11Config) (*PKCS11Token, error) {
+	slot, err := findSlot(ctx, conf.TokenLabel)
+	if err != nil {
+		return nil, err
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("open session of token %s: %v", conf.TokenLabel, err)
+	}
+	err = ctx.Login(session, pkcs11.CKU_USER, conf.PIN)
+	if err != nil && !isPKCS11Error(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		ctx.CloseSession(session)
+		return nil, fmt.Errorf("login to token %s: %v", conf.TokenLabel, err)
+	}
+
+	token := &PKCS11Token{ctx: ctx, session: session}
+	token.private, err = findObject(ctx, session, pkcs11.CKO_PRIVATE_KEY, conf.KeyLabel)
+	if err == nil {
+		token.public, err = findObject(ctx, session, pkcs11.CKO_PUBLIC_KEY, conf.KeyLabel)
+	}
+	if err != nil {
+		ctx.CloseSession(session)
+		return nil, err
+	}
+	return token, nil
+}
+
+func findSlot(ctx *pkcs11.Ctx, label string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("list pkcs11 slots: %v", err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, fmt.Errorf("read token of slot %d: %v", slot, err)
+		}
+		if info.Label == label {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("pkcs11 token %s not found", label)
+}
+
+func findObject(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, err
+	}
+	objects, _, err := ctx.FindObjects(session, 2)
+	if finalErr := ctx.FindObjectsFinal(session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(objects) != 1 {
+		return 0, fmt.Errorf("%d key objects of class %d labeled %s, expected one", len(objects), class, label)
+	}
+	return objects[0], nil
+}
+
+func isPKCS11Error(err error, code uint) bool {
+	var pkcs11Err pkcs11.Error
+	return errors.As(err, &pkcs11Err) && uint(pkcs11Err) == code
+}
+
+func (t *PKCS11Token) PublicKey() (*ecdsa.PublicKey, error) {
+	attributes, err := t.ctx.GetAttributeValue(t.session, t.public, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read public key: %v", err)
+	}
+	return parsePublicKey(attributes[0].Value, attributes[1].Value)
+}
+
+func (t *PKCS11Token) SignDigest(digest []byte) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+	if err := t.ctx.SignInit(t.session, mechanism, t.private); err != nil {
+		return nil, fmt.Errorf("pkcs11 sign: %v", err)
+	}
+	signature, err := t.ctx.Sign(t.session, digest)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11 sign: %v", err)
+	}
+	return signature, nil
+}
@@ -0,0 +1,98 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// fakeToken signs digests with an in memory key, with the high s of the signature if highS is set.
+type fakeToken struct {
+	key   *ecdsa.PrivateKey
+	highS bool
+}
+
+func (t fakeToken) PublicKey() (*ecdsa.PublicKey, error) {
+	return &t.key.PublicKey, nil
+}
+
+func (t fakeToken) SignDigest(digest []byte) ([]byte, error) {
+	signature, err := crypto.Sign(digest, t.key)
+	if err != nil {
+		return nil, err
+	}
+	if t.highS {
+		s := new(big.Int).SetBytes(signature[32:64])
+		s.Sub(secp256k1N, s).FillBytes(signature[32:64])
+	}
+	return signature[:64], nil
+}
+
+func TestHSMSignMessage(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	message := []byte("paymaster hash")
+
+	for _, highS := range []bool{false, true} {
+		hsm, err := NewHSM(fakeToken{key: key, highS: highS})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hsm.Address() != address {
+			t.Fatalf("hsm address %s, expected %s", hsm.Address(), address)
+		}
+		signature, err := hsm.SignMessage(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if new(big.Int).SetBytes(signature[32:64]).Cmp(secp256k1HalfN) > 0 {
+			t.Fatalf("signature with high s %t is not normalized", highS)
+		}
+		signature[crypto.RecoveryIDOffset] -= 27
+		pub, err := crypto.SigToPub(accounts.TextHash(message), signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if crypto.PubkeyToAddress(*pub) != address {
+			t.Fatalf("signature with high s %t recovers to %s, expected %s", highS, crypto.PubkeyToAddress(*pub), address)
+		}
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	point, err := asn1.Marshal(crypto.FromECDSAPub(&key.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := asn1.Marshal(secp256k1OID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := parsePublicKey(params, point)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crypto.PubkeyToAddress(*pub) != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatal("parsed public key differs from the key")
+	}
+
+	// prime256v1 keys can not sign for ethereum addresses
+	params, err = asn1.Marshal(asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsePublicKey(params, point); err == nil {
+		t.Fatal("public key of another curve is parsed")
+	}
+}
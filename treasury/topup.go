@@ -0,0 +1,93 @@
+package treasury
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+// topUpTimeout is how long a check waits for its deposit to be mined.
+const topUpTimeout = 10 * time.Minute
+
+// Funder is a funding wallet shared by top ups, it sends one deposit at a time so the
+// transactions of concurrent top ups don't take the same nonce.
+type Funder struct {
+	mu   sync.Mutex
+	opts *bind.TransactOpts
+}
+
+func NewFunder(opts *bind.TransactOpts) *Funder {
+	return &Funder{opts: opts}
+}
+
+// TopUp deposits amount from a funding wallet whenever the deposit of the paymaster drops below threshold.
+type TopUp struct {
+	treasury  *Treasury
+	funder    *Funder
+	threshold *big.Int
+	amount    *big.Int
+}
+
+func NewTopUp(treasury *Treasury, funder *Funder, threshold *big.Int, amount *big.Int) *TopUp {
+	return &TopUp{
+		treasury:  treasury,
+		funder:    funder,
+		threshold: threshold,
+		amount:    amount,
+	}
+}
+
+// Check deposits once if the deposit is below the threshold, waiting for the deposit to be mined
+// so the next check sees it. It reports whether a deposit was made. The funder is held until the
+// deposit is mined, so its balance and nonce are not used by another top up meanwhile.
+func (t *TopUp) Check(ctx context.Context) (bool, error) {
+	t.funder.mu.Lock()
+	defer t.funder.mu.Unlock()
+
+	deposit, err := t.treasury.GetDeposit()
+	if err != nil {
+		return false, err
+	}
+	if deposit.Cmp(t.threshold) >= 0 {
+		return false, nil
+	}
+	balance, err := t.treasury.backend.BalanceAt(ctx, t.funder.opts.From, nil)
+	if err != nil {
+		return false, err
+	}
+	if balance.Cmp(t.amount) < 0 {
+		logger.S().Errorf("Paymaster %s deposit %s is below %s, funding wallet %s balance %s is too low to top up",
+			t.treasury.address, deposit, t.threshold, t.funder.opts.From, balance)
+		return false, nil
+	}
+
+	opts := *t.funder.opts
+	opts.Context = ctx
+	tx, err := t.treasury.Deposit(&opts, t.amount)
+	if err != nil {
+		return false, err
+	}
+	logger.S().Infof("Paymaster %s deposit %s is below %s, topping up %s in %s", t.treasury.address, deposit, t.threshold, t.amount, tx.Hash())
+	if _, err := t.treasury.Wait(ctx, tx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Run checks the deposit every interval.
+func (t *TopUp) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), topUpTimeout)
+		if _, err := t.Check(ctx); err != nil {
+			logger.S().Errorf("top up deposit of paymaster %s error: %v", t.treasury.address, err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,155 @@
+// Package treasury manages the EntryPoint deposit and stake of a paymaster.
+package treasury
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/ququzone/verifying-paymaster-service/contracts"
+)
+
+// Backend is the chain access of the treasury, an ethclient.Client or a simulated backend.
+type Backend interface {
+	bind.ContractBackend
+	bind.DeployBackend
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+}
+
+// Status is the deposit and stake of a paymaster.
+type Status struct {
+	Paymaster       string `json:"paymaster"`
+	EntryPoint      string `json:"entryPoint"`
+	Owner           string `json:"owner"`
+	Deposit         string `json:"deposit"`
+	Staked          bool   `json:"staked"`
+	Stake           string `json:"stake"`
+	UnstakeDelaySec uint32 `json:"unstakeDelaySec"`
+	WithdrawTime    int64  `json:"withdrawTime"`
+}
+
+// Treasury sends the deposit and stake transactions of a paymaster.
+type Treasury struct {
+	backend    Backend
+	address    common.Address
+	paymaster  *contracts.VerifyingPaymaster
+	entryPoint *contracts.EntryPointCaller
+}
+
+func New(backend Backend, address common.Address) (*Treasury, error) {
+	paymaster, err := contracts.NewVerifyingPaymaster(address, backend)
+	if err != nil {
+		return nil, err
+	}
+	entryPointAddr, err := paymaster.EntryPoint(nil)
+	if err != nil {
+		return nil, fmt.Errorf("query entry point of paymaster %s: %v", address, err)
+	}
+	entryPoint, err := contracts.NewEntryPointCaller(entryPointAddr, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &Treasury{
+		backend:    backend,
+		address:    address,
+		paymaster:  paymaster,
+		entryPoint: entryPoint,
+	}, nil
+}
+
+// KeystoreTransactor returns the transact options of the key of the keystore file on chainID.
+func KeystoreTransactor(file string, passphrase string, chainID *big.Int) (*bind.TransactOpts, error) {
+	if file == "" {
+		return nil, errors.New("no keystore")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keystore.DecryptKey(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return bind.NewKeyedTransactorWithChainID(key.PrivateKey, chainID)
+}
+
+// Status returns the deposit and stake of the paymaster.
+func (t *Treasury) Status() (*Status, error) {
+	owner, err := t.paymaster.Owner(nil)
+	if err != nil {
+		return nil, err
+	}
+	entryPoint, err := t.paymaster.EntryPoint(nil)
+	if err != nil {
+		return nil, err
+	}
+	info, err := t.entryPoint.GetDepositInfo(nil, t.address)
+	if err != nil {
+		return nil, err
+	}
+	return &Status{
+		Paymaster:       t.address.Hex(),
+		EntryPoint:      entryPoint.Hex(),
+		Owner:           owner.Hex(),
+		Deposit:         info.Deposit.String(),
+		Staked:          info.Staked,
+		Stake:           info.Stake.String(),
+		UnstakeDelaySec: info.UnstakeDelaySec,
+		WithdrawTime:    info.WithdrawTime.Int64(),
+	}, nil
+}
+
+// GetDeposit returns the EntryPoint deposit of the paymaster.
+func (t *Treasury) GetDeposit() (*big.Int, error) {
+	return t.paymaster.GetDeposit(nil)
+}
+
+// Deposit adds amount to the deposit of the paymaster, any account can deposit.
+func (t *Treasury) Deposit(opts *bind.TransactOpts, amount *big.Int) (*types.Transaction, error) {
+	return t.paymaster.Deposit(withValue(opts, amount))
+}
+
+// AddStake stakes amount with the unstake delay, only the owner can stake.
+func (t *Treasury) AddStake(opts *bind.TransactOpts, amount *big.Int, unstakeDelaySec uint32) (*types.Transaction, error) {
+	return t.paymaster.AddStake(withValue(opts, amount), unstakeDelaySec)
+}
+
+// UnlockStake starts the unstake delay, after which the stake can be withdrawn.
+func (t *Treasury) UnlockStake(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return t.paymaster.UnlockStake(opts)
+}
+
+// WithdrawStake withdraws the unlocked stake to to.
+func (t *Treasury) WithdrawStake(opts *bind.TransactOpts, to common.Address) (*types.Transaction, error) {
+	return t.paymaster.WithdrawStake(opts, to)
+}
+
+// WithdrawTo withdraws amount of the deposit to to.
+func (t *Treasury) WithdrawTo(opts *bind.TransactOpts, to common.Address, amount *big.Int) (*types.Transaction, error) {
+	return t.paymaster.WithdrawTo(opts, to, amount)
+}
+
+// Wait waits until tx is mined and fails if it reverted.
+func (t *Treasury) Wait(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+	receipt, err := bind.WaitMined(ctx, t.backend, tx)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return receipt, fmt.Errorf("transaction %s reverted", tx.Hash())
+	}
+	return receipt, nil
+}
+
+func withValue(opts *bind.TransactOpts, value *big.Int) *bind.TransactOpts {
+	copied := *opts
+	copied.Value = value
+	return &copied
+}
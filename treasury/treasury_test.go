@@ -0,0 +1,259 @@
+package treasury
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ququzone/verifying-paymaster-service/logger"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.InitLogger(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// minedBackend mines every transaction when it is sent. Nonces are read slowly, as from a remote
+// node, so concurrent senders of an account read the same nonce unless they are serialized.
+type minedBackend struct {
+	*backends.SimulatedBackend
+}
+
+func (b minedBackend) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	nonce, err := b.SimulatedBackend.PendingNonceAt(ctx, account)
+	time.Sleep(10 * time.Millisecond)
+	return nonce, err
+}
+
+func (b minedBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if err := b.SimulatedBackend.SendTransaction(ctx, tx); err != nil {
+		return err
+	}
+	b.Commit()
+	return nil
+}
+
+func ether(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), big.NewInt(1e18))
+}
+
+func newTransactor(t *testing.T) *bind.TransactOpts {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+// newPaymaster deploys the EntryPoint v0.6 and a VerifyingPaymaster owned by owner, funding owner and the accounts.
+func newPaymaster(t *testing.T, owner *bind.TransactOpts, accounts ...common.Address) (minedBackend, common.Address, common.Address) {
+	alloc := core.GenesisAlloc{owner.From: {Balance: ether(100)}}
+	for _, account := range accounts {
+		alloc[account] = core.GenesisAccount{Balance: ether(100)}
+	}
+	backend := minedBackend{backends.NewSimulatedBackend(alloc, 30000000)}
+	t.Cleanup(func() { backend.Close() })
+	entryPoint := deployFixture(t, backend, owner, "EntryPointV06")
+	paymaster := deployFixture(t, backend, owner, "VerifyingPaymaster", entryPoint, owner.From)
+	return backend, entryPoint, paymaster
+}
+
+func deployFixture(t *testing.T, backend minedBackend, auth *bind.TransactOpts, name string, args ...common.Address) common.Address {
+	code, err := os.ReadFile("../contracts/testdata/" + name + ".bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytecode := hexutil.MustDecode("0x" + strings.TrimSpace(string(code)))
+	for _, arg := range args {
+		bytecode = append(bytecode, common.LeftPadBytes(arg.Bytes(), 32)...)
+	}
+	address, _, _, err := bind.DeployContract(auth, abi.ABI{}, bytecode, backend)
+	if err != nil {
+		t.Fatalf("deploy %s: %v", name, err)
+	}
+	return address
+}
+
+func send(t *testing.T, tr *Treasury, tx *types.Transaction, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Wait(context.Background(), tx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTreasury(t *testing.T) {
+	owner := newTransactor(t)
+	backend, entryPoint, paymaster := newPaymaster(t, owner)
+	tr, err := New(backend, paymaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := tr.Deposit(owner, ether(3))
+	send(t, tr, tx, err)
+	tx, err = tr.AddStake(owner, ether(1), 60)
+	send(t, tr, tx, err)
+	status, err := tr.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Status{
+		Paymaster:       paymaster.Hex(),
+		EntryPoint:      entryPoint.Hex(),
+		Owner:           owner.From.Hex(),
+		Deposit:         ether(3).String(),
+		Staked:          true,
+		Stake:           ether(1).String(),
+		UnstakeDelaySec: 60,
+	}
+	if *status != expected {
+		t.Fatalf("status %+v, expected %+v", status, expected)
+	}
+
+	to := common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+	tx, err = tr.WithdrawTo(owner, to, ether(1))
+	send(t, tr, tx, err)
+	if deposit, err := tr.GetDeposit(); err != nil || deposit.Cmp(ether(2)) != 0 {
+		t.Fatalf("deposit %s after withdrawal, expected %s", deposit, ether(2))
+	}
+
+	// the stake is withdrawn once unlocked and the unstake delay has passed
+	if _, err := tr.WithdrawStake(owner, to); err == nil {
+		t.Fatal("locked stake is withdrawn")
+	}
+	tx, err = tr.UnlockStake(owner)
+	send(t, tr, tx, err)
+	if err := backend.AdjustTime(61 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+	tx, err = tr.WithdrawStake(owner, to)
+	send(t, tr, tx, err)
+	if status, err = tr.Status(); err != nil || status.Staked || status.Stake != "0" {
+		t.Fatalf("status %+v after stake withdrawal", status)
+	}
+	balance, err := backend.BalanceAt(context.Background(), to, nil)
+	if err != nil || balance.Cmp(ether(2)) != 0 {
+		t.Fatalf("balance %s of the withdrawal address, expected %s", balance, ether(2))
+	}
+}
+
+func TestTreasuryOnlyOwner(t *testing.T) {
+	owner := newTransactor(t)
+	other := newTransactor(t)
+	backend, _, paymaster := newPaymaster(t, owner, other.From)
+	tr, err := New(backend, paymaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// anyone can deposit, only the owner stakes and withdraws
+	tx, err := tr.Deposit(other, ether(1))
+	send(t, tr, tx, err)
+	if _, err := tr.AddStake(other, ether(1), 60); err == nil {
+		t.Fatal("stake added by another account")
+	}
+	if _, err := tr.WithdrawTo(other, other.From, ether(1)); err == nil {
+		t.Fatal("deposit withdrawn by another account")
+	}
+}
+
+func TestTopUp(t *testing.T) {
+	owner := newTransactor(t)
+	funding := newTransactor(t)
+	backend, _, paymaster := newPaymaster(t, owner, funding.From)
+	tr, err := New(backend, paymaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topUp := NewTopUp(tr, NewFunder(funding), ether(2), ether(3))
+
+	for i, expected := range []bool{true, false} {
+		deposited, err := topUp.Check(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deposited != expected {
+			t.Fatalf("check %d deposited %v, expected %v", i, deposited, expected)
+		}
+	}
+	if deposit, err := tr.GetDeposit(); err != nil || deposit.Cmp(ether(3)) != 0 {
+		t.Fatalf("deposit %s, expected %s", deposit, ether(3))
+	}
+}
+
+func TestTopUpFundingBalanceTooLow(t *testing.T) {
+	owner := newTransactor(t)
+	funding := newTransactor(t)
+	backend, _, paymaster := newPaymaster(t, owner, funding.From)
+	tr, err := New(backend, paymaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deposited, err := NewTopUp(tr, NewFunder(funding), ether(1), ether(1000)).Check(context.Background())
+	if err != nil || deposited {
+		t.Fatalf("deposited %v, %v with a funding balance below the amount", deposited, err)
+	}
+}
+
+func TestTopUpsShareFunder(t *testing.T) {
+	owner := newTransactor(t)
+	funding := newTransactor(t)
+	backend, entryPoint, paymaster := newPaymaster(t, owner, funding.From)
+	other := deployFixture(t, backend, owner, "VerifyingPaymaster", entryPoint, owner.From)
+	funder := NewFunder(funding)
+
+	const checks = 8
+	paymasters := []common.Address{paymaster, other}
+	topUps := make([]*TopUp, 0, checks)
+	for i := 0; i < checks; i++ {
+		tr, err := New(backend, paymasters[i%2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a threshold above any deposit tops up on every check
+		topUps = append(topUps, NewTopUp(tr, funder, ether(100), ether(1)))
+	}
+	errs := make([]error, checks)
+	var wg sync.WaitGroup
+	for i, topUp := range topUps {
+		wg.Add(1)
+		go func(i int, topUp *TopUp) {
+			defer wg.Done()
+			_, errs[i] = topUp.Check(context.Background())
+		}(i, topUp)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("check %d error: %v", i, err)
+		}
+	}
+	for _, tr := range topUps[:2] {
+		if deposit, err := tr.treasury.GetDeposit(); err != nil || deposit.Cmp(ether(checks/2)) != 0 {
+			t.Errorf("paymaster %s deposit %s, expected %s", tr.treasury.address, deposit, ether(checks/2))
+		}
+	}
+}
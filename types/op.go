@@ -37,6 +37,11 @@ var (
 	onlyOnce = sync.Once{}
 )
 
+// Packer is implemented by the user operations of every entry point version.
+type Packer interface {
+	Pack() []byte
+}
+
 func getAbiArgs() abi.Arguments {
 	return abi.Arguments{
 		{Name: "UserOp", Type: UserOpType},
@@ -164,6 +169,11 @@ func validateBigIntType(field reflect.Value) interface{} {
 	return field
 }
 
+func registerValidations() {
+	validate.RegisterCustomTypeFunc(validateAddressType, common.Address{})
+	validate.RegisterCustomTypeFunc(validateBigIntType, big.Int{})
+}
+
 func NewUserOperation(data map[string]any) (*UserOperation, error) {
 	var op UserOperation
 
@@ -183,10 +193,7 @@ func NewUserOperation(data map[string]any) (*UserOperation, error) {
 	}
 
 	// Validate struct
-	onlyOnce.Do(func() {
-		validate.RegisterCustomTypeFunc(validateAddressType, common.Address{})
-		validate.RegisterCustomTypeFunc(validateBigIntType, big.Int{})
-	})
+	onlyOnce.Do(registerValidations)
 	err = validate.Struct(op)
 	if err != nil {
 		return nil, err
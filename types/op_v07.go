@@ -0,0 +1,219 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/mitchellh/mapstructure"
+)
+
+var (
+	PackedUserOpPrimitives = []abi.ArgumentMarshaling{
+		{Name: "sender", InternalType: "Sender", Type: "address"},
+		{Name: "nonce", InternalType: "Nonce", Type: "uint256"},
+		{Name: "initCode", InternalType: "InitCode", Type: "bytes"},
+		{Name: "callData", InternalType: "CallData", Type: "bytes"},
+		{Name: "accountGasLimits", InternalType: "AccountGasLimits", Type: "bytes32"},
+		{Name: "preVerificationGas", InternalType: "PreVerificationGas", Type: "uint256"},
+		{Name: "gasFees", InternalType: "GasFees", Type: "bytes32"},
+		{Name: "paymasterAndData", InternalType: "PaymasterAndData", Type: "bytes"},
+		{Name: "signature", InternalType: "Signature", Type: "bytes"},
+	}
+
+	PackedUserOpType, _ = abi.NewType("tuple", "op", PackedUserOpPrimitives)
+)
+
+// PackedUserOperation is the EntryPoint v0.7 on-chain representation of a user operation.
+type PackedUserOperation struct {
+	Sender             common.Address
+	Nonce              *big.Int
+	InitCode           []byte
+	CallData           []byte
+	AccountGasLimits   [32]byte
+	PreVerificationGas *big.Int
+	GasFees            [32]byte
+	PaymasterAndData   []byte
+	Signature          []byte
+}
+
+// UserOperationV07 is the EntryPoint v0.7 user operation in its unpacked RPC form.
+type UserOperationV07 struct {
+	Sender                        common.Address `json:"sender"                        mapstructure:"sender"                        validate:"required"`
+	Nonce                         *big.Int       `json:"nonce"                         mapstructure:"nonce"                         validate:"required"`
+	Factory                       common.Address `json:"factory"                       mapstructure:"factory"`
+	FactoryData                   []byte         `json:"factoryData"                   mapstructure:"factoryData"`
+	CallData                      []byte         `json:"callData"                      mapstructure:"callData"                      validate:"required"`
+	CallGasLimit                  *big.Int       `json:"callGasLimit"                  mapstructure:"callGasLimit"                  validate:"required"`
+	VerificationGasLimit          *big.Int       `json:"verificationGasLimit"          mapstructure:"verificationGasLimit"          validate:"required"`
+	PreVerificationGas            *big.Int       `json:"preVerificationGas"            mapstructure:"preVerificationGas"            validate:"required"`
+	MaxFeePerGas                  *big.Int       `json:"maxFeePerGas"                  mapstructure:"maxFeePerGas"                  validate:"required"`
+	MaxPriorityFeePerGas          *big.Int       `json:"maxPriorityFeePerGas"          mapstructure:"maxPriorityFeePerGas"          validate:"required"`
+	Paymaster                     common.Address `json:"paymaster"                     mapstructure:"paymaster"`
+	PaymasterVerificationGasLimit *big.Int       `json:"paymasterVerificationGasLimit" mapstructure:"paymasterVerificationGasLimit"`
+	PaymasterPostOpGasLimit       *big.Int       `json:"paymasterPostOpGasLimit"       mapstructure:"paymasterPostOpGasLimit"`
+	PaymasterData                 []byte         `json:"paymasterData"                 mapstructure:"paymasterData"`
+	Signature                     []byte         `json:"signature"                     mapstructure:"signature"`
+}
+
+func NewUserOperationV07(data map[string]any) (*UserOperationV07, error) {
+	var op UserOperationV07
+
+	// Convert map to struct
+	config := &mapstructure.DecoderConfig{
+		DecodeHook: decodeOpTypes,
+		Result:     &op,
+		ErrorUnset: false,
+		MatchName:  exactFieldMatch,
+	}
+	decoder, err := mapstructure.NewDecoder(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(data); err != nil {
+		return nil, err
+	}
+
+	// Validate struct
+	onlyOnce.Do(registerValidations)
+	err = validate.Struct(op)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range []*big.Int{
+		op.CallGasLimit,
+		op.VerificationGasLimit,
+		op.MaxFeePerGas,
+		op.MaxPriorityFeePerGas,
+		op.PaymasterVerificationGasLimit,
+		op.PaymasterPostOpGasLimit,
+	} {
+		if n != nil && n.BitLen() > 128 {
+			return nil, errors.New("packed gas value overflows uint128")
+		}
+	}
+
+	return &op, nil
+}
+
+func (op *UserOperationV07) GetFactory() common.Address {
+	return op.Factory
+}
+
+// InitCode returns factory and factoryData concatenated, or empty if there is no factory.
+func (op *UserOperationV07) InitCode() []byte {
+	if op.Factory == (common.Address{}) {
+		return []byte{}
+	}
+	return append(op.Factory.Bytes(), op.FactoryData...)
+}
+
+// AccountGasLimits packs verificationGasLimit and callGasLimit as two uint128.
+func (op *UserOperationV07) AccountGasLimits() [32]byte {
+	return packUint128s(op.VerificationGasLimit, op.CallGasLimit)
+}
+
+// GasFees packs maxPriorityFeePerGas and maxFeePerGas as two uint128.
+func (op *UserOperationV07) GasFees() [32]byte {
+	return packUint128s(op.MaxPriorityFeePerGas, op.MaxFeePerGas)
+}
+
+// PaymasterGasLimits packs paymasterVerificationGasLimit and paymasterPostOpGasLimit as two uint128.
+func (op *UserOperationV07) PaymasterGasLimits() [32]byte {
+	return packUint128s(op.PaymasterVerificationGasLimit, op.PaymasterPostOpGasLimit)
+}
+
+// PaymasterAndData returns paymaster, the paymaster gas limits and paymasterData concatenated,
+// or empty if there is no paymaster.
+func (op *UserOperationV07) PaymasterAndData() []byte {
+	if op.Paymaster == (common.Address{}) {
+		return []byte{}
+	}
+	gasLimits := op.PaymasterGasLimits()
+	return append(append(op.Paymaster.Bytes(), gasLimits[:]...), op.PaymasterData...)
+}
+
+func (op *UserOperationV07) Packed() PackedUserOperation {
+	return PackedUserOperation{
+		Sender:             op.Sender,
+		Nonce:              op.Nonce,
+		InitCode:           op.InitCode(),
+		CallData:           op.CallData,
+		AccountGasLimits:   op.AccountGasLimits(),
+		PreVerificationGas: op.PreVerificationGas,
+		GasFees:            op.GasFees(),
+		PaymasterAndData:   op.PaymasterAndData(),
+		Signature:          op.Signature,
+	}
+}
+
+func (op *UserOperationV07) Pack() []byte {
+	args := abi.Arguments{
+		{Name: "UserOp", Type: PackedUserOpType},
+	}
+	packed, _ := args.Pack(op.Packed())
+
+	enc := hexutil.Encode(packed)
+	enc = "0x" + enc[66:]
+	return (hexutil.MustDecode(enc))
+}
+
+func (op *UserOperationV07) MarshalJSON() ([]byte, error) {
+	type userOp struct {
+		Sender                        string  `json:"sender"`
+		Nonce                         string  `json:"nonce"`
+		Factory                       *string `json:"factory,omitempty"`
+		FactoryData                   *string `json:"factoryData,omitempty"`
+		CallData                      string  `json:"callData"`
+		CallGasLimit                  string  `json:"callGasLimit"`
+		VerificationGasLimit          string  `json:"verificationGasLimit"`
+		PreVerificationGas            string  `json:"preVerificationGas"`
+		MaxFeePerGas                  string  `json:"maxFeePerGas"`
+		MaxPriorityFeePerGas          string  `json:"maxPriorityFeePerGas"`
+		Paymaster                     *string `json:"paymaster,omitempty"`
+		PaymasterVerificationGasLimit *string `json:"paymasterVerificationGasLimit,omitempty"`
+		PaymasterPostOpGasLimit       *string `json:"paymasterPostOpGasLimit,omitempty"`
+		PaymasterData                 *string `json:"paymasterData,omitempty"`
+		Signature                     string  `json:"signature"`
+	}
+	data := &userOp{
+		Sender:               op.Sender.String(),
+		Nonce:                hexutil.EncodeBig(op.Nonce),
+		CallData:             hexutil.Encode(op.CallData),
+		CallGasLimit:         hexutil.EncodeBig(op.CallGasLimit),
+		VerificationGasLimit: hexutil.EncodeBig(op.VerificationGasLimit),
+		PreVerificationGas:   hexutil.EncodeBig(op.PreVerificationGas),
+		MaxFeePerGas:         hexutil.EncodeBig(op.MaxFeePerGas),
+		MaxPriorityFeePerGas: hexutil.EncodeBig(op.MaxPriorityFeePerGas),
+		Signature:            hexutil.Encode(op.Signature),
+	}
+	if op.Factory != (common.Address{}) {
+		factory, factoryData := op.Factory.String(), hexutil.Encode(op.FactoryData)
+		data.Factory, data.FactoryData = &factory, &factoryData
+	}
+	if op.Paymaster != (common.Address{}) {
+		paymaster, paymasterData := op.Paymaster.String(), hexutil.Encode(op.PaymasterData)
+		verificationGasLimit := hexutil.EncodeBig(bigOrZero(op.PaymasterVerificationGasLimit))
+		postOpGasLimit := hexutil.EncodeBig(bigOrZero(op.PaymasterPostOpGasLimit))
+		data.Paymaster, data.PaymasterData = &paymaster, &paymasterData
+		data.PaymasterVerificationGasLimit, data.PaymasterPostOpGasLimit = &verificationGasLimit, &postOpGasLimit
+	}
+	return json.Marshal(data)
+}
+
+func packUint128s(high, low *big.Int) [32]byte {
+	var packed [32]byte
+	bigOrZero(high).FillBytes(packed[:16])
+	bigOrZero(low).FillBytes(packed[16:])
+	return packed
+}
+
+func bigOrZero(n *big.Int) *big.Int {
+	if n == nil {
+		return new(big.Int)
+	}
+	return n
+}
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+const keyAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// RandomKey returns a random alphanumeric string of length n.
+func RandomKey(n int) (string, error) {
+	max := big.NewInt(int64(len(keyAlphabet)))
+	key := make([]byte, n)
+	for i := range key {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		key[i] = keyAlphabet[idx.Int64()]
+	}
+	return string(key), nil
+}